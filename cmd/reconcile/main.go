@@ -0,0 +1,159 @@
+// Command reconcile compares the stored balance of every wallet with the
+// balance recomputed from its history and with the sum of its ledger entries.
+//
+// Discrepancies are written to stdout or to -output as JSON or CSV. The CSV
+// columns are wallet, currency, stored, expected, ledger, difference, fixed
+// and error.
+// With -fix, an adjustment entry is recorded for every wallet whose ledger
+// matches its history, so that the history explains the stored balance.
+// Stored balances are never changed.
+//
+// The exit code is 2 if any discrepancy was found, even if it was fixed.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	_ "github.com/lib/pq"
+
+	"github.com/defbin/walletdb/database"
+	"github.com/defbin/walletdb/lib"
+)
+
+const exitDiscrepancies = 2
+
+type report struct {
+	Wallet     string `json:"wallet"`
+	Currency   string `json:"currency"`
+	Stored     string `json:"stored"`
+	Expected   string `json:"expected"`
+	Ledger     string `json:"ledger"`
+	Difference string `json:"difference"`
+	Fixed      bool   `json:"fixed"`
+	Error      string `json:"error,omitempty"`
+}
+
+func main() {
+	format := flag.String("format", "json", "output format: json or csv")
+	output := flag.String("output", "", "output file, stdout if empty")
+	fix := flag.Bool("fix", false, "record adjustment entries for the discrepancies")
+	memo := flag.String("memo", "reconciliation", "memo of the adjustment entries")
+	flag.Parse()
+
+	if *format != "json" && *format != "csv" {
+		log.Fatalf("reconcile: unknown format %q\n", *format)
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if len(databaseURL) == 0 {
+		log.Fatalln("reconcile: DATABASE_URL is not set")
+	}
+
+	found, err := run(databaseURL, *format, *output, *fix, *memo)
+	if err != nil {
+		log.Fatalf("reconcile: %v\n", err)
+	}
+	if found {
+		os.Exit(exitDiscrepancies)
+	}
+}
+
+// run reports whether any discrepancy was found.
+func run(databaseURL, format, output string, fix bool, memo string) (bool, error) {
+	db, err := database.OpenDB(databaseURL)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	err = lib.LoadCurrencies(ctx, db)
+	if err != nil {
+		return false, err
+	}
+
+	ds, err := lib.Reconcile(ctx, db)
+	if err != nil {
+		return false, err
+	}
+
+	reports := make([]*report, len(ds))
+	for i, d := range ds {
+		reports[i] = &report{
+			Wallet:     d.Wallet.String(),
+			Currency:   d.Currency.String(),
+			Stored:     d.Stored.String(),
+			Expected:   d.Expected.String(),
+			Ledger:     d.Ledger.String(),
+			Difference: d.Difference().String(),
+		}
+
+		if fix {
+			_, err := lib.AdjustBalance(ctx, db, d.Wallet, memo)
+			if err != nil {
+				reports[i].Error = err.Error()
+			} else {
+				reports[i].Fixed = true
+			}
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	if format == "csv" {
+		err = writeCSV(w, reports)
+	} else {
+		err = writeJSON(w, reports)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return len(reports) > 0, nil
+}
+
+func writeJSON(w io.Writer, reports []*report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(map[string][]*report{"data": reports})
+}
+
+func writeCSV(w io.Writer, reports []*report) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write([]string{"wallet", "currency", "stored", "expected", "ledger", "difference", "fixed", "error"})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		err := cw.Write([]string{
+			r.Wallet, r.Currency, r.Stored, r.Expected, r.Ledger, r.Difference, strconv.FormatBool(r.Fixed), r.Error,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
@@ -0,0 +1,54 @@
+// Command snapshot records the balance of every wallet at a point in time,
+// so that historical balances are computed from the nearest snapshot instead
+// of the whole ledger. Run it periodically, e.g. daily after 01:00 UTC, as
+// the time must be at least lib.SnapshotDelay ago.
+//
+// The time defaults to the start of the current UTC day and can be set with
+// -at in RFC 3339 format. Running it twice for the same time is a no-op.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"github.com/defbin/walletdb/database"
+	"github.com/defbin/walletdb/lib"
+)
+
+func main() {
+	at := flag.String("at", "", "snapshot time in RFC 3339 format, the start of the current UTC day if empty")
+	flag.Parse()
+
+	t := time.Now().UTC().Truncate(24 * time.Hour)
+	if *at != "" {
+		var err error
+		t, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatalf("snapshot: %v\n", err)
+		}
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if len(databaseURL) == 0 {
+		log.Fatalln("snapshot: DATABASE_URL is not set")
+	}
+
+	db, err := database.OpenDB(databaseURL)
+	if err != nil {
+		log.Fatalf("snapshot: %v\n", err)
+	}
+	defer db.Close()
+
+	n, err := lib.TakeBalanceSnapshots(context.Background(), db, t)
+	if err != nil {
+		log.Fatalf("snapshot: %v\n", err)
+	}
+
+	fmt.Printf("snapshot: %d balances at %v\n", n, t.Format(time.RFC3339))
+}
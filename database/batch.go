@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanBatch          = errs.Class("scan batch")
+	ErrCreateBatch        = errs.Class("create batch")
+	ErrFindBatch          = errs.Class("find batch")
+	ErrFindBatchTransfers = errs.Class("find batch transfers")
+)
+
+type BatchID = ID
+
+// Batch groups transfers applied together.
+type Batch interface {
+	ID() BatchID
+	CreatedAt() time.Time
+}
+
+type batchImpl struct {
+	id        BatchID
+	createdAt time.Time
+}
+
+func (b *batchImpl) ID() BatchID {
+	return b.id
+}
+
+func (b *batchImpl) CreatedAt() time.Time {
+	return b.createdAt
+}
+
+const (
+	batchColumns = `id, created_at`
+
+	createBatchQuery = `
+	insert into transfer_batches default values
+	returning ` + batchColumns
+	findBatchByIDQuery = `
+	select ` + batchColumns + ` from transfer_batches where id = $1`
+	findBatchTransfersQuery = `
+	select ` + transferColumns + ` from transactions t where t.batch = $1 order by t.id`
+)
+
+func scanBatch(s Scanner) (Batch, error) {
+	var b batchImpl
+
+	err := s.Scan(&b.id, &b.createdAt)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanBatch.Wrap(err)
+	}
+
+	return &b, nil
+}
+
+func CreateBatch(ctx context.Context, q ContextRowQuerier) (Batch, error) {
+	b, err := scanBatch(q.QueryRowContext(ctx, createBatchQuery))
+	if err != nil {
+		return nil, ErrCreateBatch.Wrap(err)
+	}
+
+	return b, nil
+}
+
+func FindBatchByID(ctx context.Context, q ContextRowQuerier, id BatchID) (Batch, error) {
+	b, err := scanBatch(q.QueryRowContext(ctx, findBatchByIDQuery, id))
+	if err != nil {
+		return nil, ErrFindBatch.Wrap(err)
+	}
+
+	return b, nil
+}
+
+// FindBatchTransfers returns the transfers of the batch in the order of its legs.
+func FindBatchTransfers(ctx context.Context, q ContextQuerier, id BatchID) ([]Transfer, error) {
+	rows, err := q.QueryContext(ctx, findBatchTransfersQuery, id)
+	if err != nil {
+		return nil, ErrFindBatchTransfers.Wrap(err)
+	}
+	defer rows.Close()
+
+	var rv []Transfer
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, ErrFindBatchTransfers.Wrap(err)
+		}
+
+		rv = append(rv, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindBatchTransfers.Wrap(err)
+	}
+
+	return rv, nil
+}
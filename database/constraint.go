@@ -0,0 +1,36 @@
+package database
+
+import (
+	pg "github.com/lib/pq"
+	"github.com/zeebo/errs"
+)
+
+// Constraints checked by the database that the application also checks.
+const (
+	ConstraintWalletBalance          = "wallets_balance_check"
+	ConstraintWalletHeld             = "wallets_held_check"
+	ConstraintTransferAmount         = "transactions_amount_check"
+	ConstraintTransferFeeAmount      = "transactions_fee_amount_check"
+	ConstraintTransferSenderReceiver = "transactions_sender_receiver_check"
+	ConstraintTransferSender         = "transactions_sender_fkey"
+	ConstraintTransferReceiver       = "transactions_receiver_fkey"
+	ConstraintHoldSenderReceiver     = "holds_sender_receiver_check"
+)
+
+// ViolatedConstraint returns the name of the constraint err violates, or
+// an empty string if err is not an integrity constraint violation or the
+// violation has no constraint, as for NOT NULL.
+func ViolatedConstraint(err error) string {
+	var pgErr *pg.Error
+	errs.IsFunc(err, func(err error) bool {
+		pgErr, _ = err.(*pg.Error)
+		return pgErr != nil
+	})
+
+	// class 23 is integrity constraint violation
+	if pgErr == nil || pgErr.Code.Class() != "23" {
+		return ""
+	}
+
+	return pgErr.Constraint
+}
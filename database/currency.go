@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanCurrency       = errs.Class("scan currency")
+	ErrCreateCurrency     = errs.Class("create currency")
+	ErrFindAllCurrencies  = errs.Class("find all currencies")
+	ErrSetCurrencyEnabled = errs.Class("set currency enabled")
+	ErrSetFeeWallet       = errs.Class("set currency fee wallet")
+)
+
+type Currency interface {
+	Code() CurrencyCode
+	Name() string
+	Scale() int32
+	MinUnit() Decimal
+	Rounding() string
+	Enabled() bool
+	FeeWallet() *WalletID
+}
+
+type currencyImpl struct {
+	code      CurrencyCode
+	name      string
+	scale     int32
+	minUnit   Decimal
+	rounding  string
+	enabled   bool
+	feeWallet *WalletID
+}
+
+func (c *currencyImpl) Code() CurrencyCode {
+	return c.code
+}
+
+func (c *currencyImpl) Name() string {
+	return c.name
+}
+
+func (c *currencyImpl) Scale() int32 {
+	return c.scale
+}
+
+func (c *currencyImpl) MinUnit() Decimal {
+	return c.minUnit
+}
+
+func (c *currencyImpl) Rounding() string {
+	return c.rounding
+}
+
+func (c *currencyImpl) Enabled() bool {
+	return c.enabled
+}
+
+func (c *currencyImpl) FeeWallet() *WalletID {
+	return c.feeWallet
+}
+
+const (
+	createCurrencyQuery = `
+	insert into currencies (code, name, scale, min_unit, rounding) values ($1, $2, $3, $4, $5)
+	on conflict (code) do nothing
+	returning code, name, scale, min_unit, rounding, enabled, fee_wallet`
+	findAllCurrenciesQuery  = `select code, name, scale, min_unit, rounding, enabled, fee_wallet from currencies order by code`
+	setCurrencyEnabledQuery = `
+	update currencies set enabled = $1 where code = $2
+	returning code, name, scale, min_unit, rounding, enabled, fee_wallet`
+	setFeeWalletQuery = `
+	update currencies c set fee_wallet = w.id from wallets w
+	where c.code = $1 and w.id = $2 and w.currency = c.code and w.status <> 'closed'
+	returning c.code, c.name, c.scale, c.min_unit, c.rounding, c.enabled, c.fee_wallet`
+)
+
+func scanCurrency(s Scanner) (Currency, error) {
+	var c currencyImpl
+	var feeWallet sql.NullInt64
+
+	err := s.Scan(&c.code, &c.name, &c.scale, &c.minUnit, &c.rounding, &c.enabled, &feeWallet)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanCurrency.Wrap(err)
+	}
+
+	if feeWallet.Valid {
+		id := WalletID(feeWallet.Int64)
+		c.feeWallet = &id
+	}
+
+	return &c, nil
+}
+
+// CreateCurrency returns nil currency if a currency with the same code exists.
+func CreateCurrency(ctx context.Context, q ContextRowQuerier, code CurrencyCode, name string, scale int32, minUnit Decimal, rounding string) (Currency, error) {
+	c, err := scanCurrency(q.QueryRowContext(ctx, createCurrencyQuery, code, name, scale, minUnit, rounding))
+	if err != nil {
+		return nil, ErrCreateCurrency.Wrap(err)
+	}
+
+	return c, nil
+}
+
+func FindAllCurrencies(ctx context.Context, q ContextQuerier) ([]Currency, error) {
+	rows, err := q.QueryContext(ctx, findAllCurrenciesQuery)
+	if err != nil {
+		return nil, ErrFindAllCurrencies.Wrap(err)
+	}
+	defer rows.Close()
+
+	var currencies []Currency
+	for rows.Next() {
+		c, err := scanCurrency(rows)
+		if err != nil {
+			return nil, ErrFindAllCurrencies.Wrap(err)
+		}
+
+		currencies = append(currencies, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindAllCurrencies.Wrap(err)
+	}
+
+	return currencies, nil
+}
+
+func SetCurrencyEnabled(ctx context.Context, q ContextRowQuerier, code CurrencyCode, enabled bool) (Currency, error) {
+	c, err := scanCurrency(q.QueryRowContext(ctx, setCurrencyEnabledQuery, enabled, code))
+	if err != nil {
+		return nil, ErrSetCurrencyEnabled.Wrap(err)
+	}
+
+	return c, nil
+}
+
+// SetFeeWallet returns nil currency if the currency or the wallet do not
+// exist or the wallet holds another currency.
+func SetFeeWallet(ctx context.Context, q ContextRowQuerier, code CurrencyCode, walletID WalletID) (Currency, error) {
+	c, err := scanCurrency(q.QueryRowContext(ctx, setFeeWalletQuery, code, walletID))
+	if err != nil {
+		return nil, ErrSetFeeWallet.Wrap(err)
+	}
+
+	return c, nil
+}
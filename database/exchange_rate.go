@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanExchangeRate   = errs.Class("scan exchange rate")
+	ErrFindExchangeRate   = errs.Class("find exchange rate")
+	ErrCreateExchangeRate = errs.Class("create exchange rate")
+)
+
+type ExchangeRateID = ID
+
+// ExchangeRate converts amounts of Base into Quote: 1 Base = Rate Quote.
+type ExchangeRate interface {
+	ID() ExchangeRateID
+	Base() CurrencyCode
+	Quote() CurrencyCode
+	Rate() Decimal
+	ValidFrom() time.Time
+	// ValidTo is exclusive, nil if the rate has no end.
+	ValidTo() *time.Time
+}
+
+type exchangeRateImpl struct {
+	id        ExchangeRateID
+	base      CurrencyCode
+	quote     CurrencyCode
+	rate      Decimal
+	validFrom time.Time
+	validTo   *time.Time
+}
+
+func (r *exchangeRateImpl) ID() ExchangeRateID {
+	return r.id
+}
+
+func (r *exchangeRateImpl) Base() CurrencyCode {
+	return r.base
+}
+
+func (r *exchangeRateImpl) Quote() CurrencyCode {
+	return r.quote
+}
+
+func (r *exchangeRateImpl) Rate() Decimal {
+	return r.rate
+}
+
+func (r *exchangeRateImpl) ValidFrom() time.Time {
+	return r.validFrom
+}
+
+func (r *exchangeRateImpl) ValidTo() *time.Time {
+	return r.validTo
+}
+
+const (
+	exchangeRateColumns = `id, base, quote, rate, valid_from, valid_to`
+
+	// the latest rate wins if the windows of several rates overlap
+	findExchangeRateQuery = `
+	select ` + exchangeRateColumns + ` from exchange_rates
+	where base = $1 and quote = $2 and valid_from <= $3 and (valid_to is null or valid_to > $3)
+	order by valid_from desc, id desc
+	limit 1`
+	createExchangeRateQuery = `
+	insert into exchange_rates (base, quote, rate, valid_from, valid_to) values ($1, $2, $3, $4, $5)
+	returning ` + exchangeRateColumns
+)
+
+func scanExchangeRate(s Scanner) (ExchangeRate, error) {
+	var r exchangeRateImpl
+	var validTo sql.NullTime
+
+	err := s.Scan(&r.id, &r.base, &r.quote, &r.rate, &r.validFrom, &validTo)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanExchangeRate.Wrap(err)
+	}
+
+	if validTo.Valid {
+		r.validTo = &validTo.Time
+	}
+
+	return &r, nil
+}
+
+// FindExchangeRate returns the rate of the pair in effect at the given time,
+// nil if there is none.
+func FindExchangeRate(ctx context.Context, q ContextRowQuerier, base, quote CurrencyCode, at time.Time) (ExchangeRate, error) {
+	r, err := scanExchangeRate(q.QueryRowContext(ctx, findExchangeRateQuery, base, quote, at))
+	if err != nil {
+		return nil, ErrFindExchangeRate.Wrap(err)
+	}
+
+	return r, nil
+}
+
+type CreateExchangeRateParams struct {
+	Base      CurrencyCode
+	Quote     CurrencyCode
+	Rate      Decimal
+	ValidFrom time.Time
+	ValidTo   *time.Time
+}
+
+func CreateExchangeRate(ctx context.Context, q ContextRowQuerier, params *CreateExchangeRateParams) (ExchangeRate, error) {
+	row := q.QueryRowContext(
+		ctx,
+		createExchangeRateQuery,
+		params.Base,
+		params.Quote,
+		params.Rate,
+		params.ValidFrom,
+		params.ValidTo,
+	)
+
+	r, err := scanExchangeRate(row)
+	if err != nil {
+		return nil, ErrCreateExchangeRate.Wrap(err)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanExternalTransaction   = errs.Class("scan external transaction")
+	ErrCreateExternalTransaction = errs.Class("create external transaction")
+	ErrFindExternalTransaction   = errs.Class("find external transaction")
+	ErrFinishExternalTransaction = errs.Class("finish external transaction")
+	ErrFindPendingWithdrawals    = errs.Class("find pending withdrawals")
+	ErrCountPendingWithdrawals   = errs.Class("count pending withdrawals")
+)
+
+type ExternalTransactionID = ID
+
+// Kinds of external transactions.
+const (
+	KindDeposit    = "deposit"
+	KindWithdrawal = "withdrawal"
+)
+
+// Statuses of external transactions. Deposits are completed right away.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// ExternalTransaction moves funds between a wallet and a source outside of
+// the system, e.g. a bank account. Reference identifies it in that source.
+type ExternalTransaction interface {
+	ID() ExternalTransactionID
+	Wallet() WalletID
+	Kind() string
+	Amount() Decimal
+	Reference() string
+	Status() string
+	CreatedAt() time.Time
+	// FinishedAt is nil while the transaction is pending.
+	FinishedAt() *time.Time
+}
+
+type externalTransactionImpl struct {
+	id         ExternalTransactionID
+	wallet     WalletID
+	kind       string
+	amount     Decimal
+	reference  string
+	status     string
+	createdAt  time.Time
+	finishedAt *time.Time
+}
+
+func (t *externalTransactionImpl) ID() ExternalTransactionID {
+	return t.id
+}
+
+func (t *externalTransactionImpl) Wallet() WalletID {
+	return t.wallet
+}
+
+func (t *externalTransactionImpl) Kind() string {
+	return t.kind
+}
+
+func (t *externalTransactionImpl) Amount() Decimal {
+	return t.amount
+}
+
+func (t *externalTransactionImpl) Reference() string {
+	return t.reference
+}
+
+func (t *externalTransactionImpl) Status() string {
+	return t.status
+}
+
+func (t *externalTransactionImpl) CreatedAt() time.Time {
+	return t.createdAt
+}
+
+func (t *externalTransactionImpl) FinishedAt() *time.Time {
+	return t.finishedAt
+}
+
+const (
+	externalTransactionColumns = `id, wallet, kind, amount, reference, status, created_at, finished_at`
+
+	createExternalTransactionQuery = `
+	insert into external_transactions (wallet, kind, amount, reference, status, finished_at)
+	values ($1, $2, $3, $4, $5, case when $5 = 'pending' then null else now() end)
+	on conflict (kind, reference) do nothing
+	returning ` + externalTransactionColumns
+	findExternalTransactionByIDQuery = `
+	select ` + externalTransactionColumns + ` from external_transactions where id = $1`
+	finishExternalTransactionQuery = `
+	update external_transactions set status = $2, finished_at = now()
+	where id = $1 and status = 'pending'
+	returning ` + externalTransactionColumns
+	findPendingWithdrawalsQuery = `
+	select ` + externalTransactionColumns + ` from external_transactions
+	where status = 'pending' and kind = 'withdrawal'
+	order by created_at, id`
+	countPendingWithdrawalsQuery = `
+	select count(*) from external_transactions
+	where wallet = $1 and status = 'pending' and kind = 'withdrawal'`
+)
+
+func scanExternalTransaction(s Scanner) (ExternalTransaction, error) {
+	var t externalTransactionImpl
+	var finishedAt sql.NullTime
+
+	err := s.Scan(&t.id, &t.wallet, &t.kind, &t.amount, &t.reference, &t.status, &t.createdAt, &finishedAt)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanExternalTransaction.Wrap(err)
+	}
+
+	if finishedAt.Valid {
+		t.finishedAt = &finishedAt.Time
+	}
+
+	return &t, nil
+}
+
+// CreateExternalTransaction returns nil if a transaction of the same kind
+// with the reference exists.
+func CreateExternalTransaction(
+	ctx context.Context,
+	q ContextRowQuerier,
+	wallet WalletID,
+	kind string,
+	amount Decimal,
+	reference string,
+	status string,
+) (ExternalTransaction, error) {
+	row := q.QueryRowContext(ctx, createExternalTransactionQuery, wallet, kind, amount, reference, status)
+	t, err := scanExternalTransaction(row)
+	if err != nil {
+		return nil, ErrCreateExternalTransaction.Wrap(err)
+	}
+
+	return t, nil
+}
+
+func FindExternalTransactionByID(ctx context.Context, q ContextRowQuerier, id ExternalTransactionID) (ExternalTransaction, error) {
+	t, err := scanExternalTransaction(q.QueryRowContext(ctx, findExternalTransactionByIDQuery, id))
+	if err != nil {
+		return nil, ErrFindExternalTransaction.Wrap(err)
+	}
+
+	return t, nil
+}
+
+// FinishExternalTransaction sets the final status of a pending transaction.
+// It returns nil if the transaction is not pending.
+func FinishExternalTransaction(ctx context.Context, q ContextRowQuerier, id ExternalTransactionID, status string) (ExternalTransaction, error) {
+	t, err := scanExternalTransaction(q.QueryRowContext(ctx, finishExternalTransactionQuery, id, status))
+	if err != nil {
+		return nil, ErrFinishExternalTransaction.Wrap(err)
+	}
+
+	return t, nil
+}
+
+// FindPendingWithdrawals returns the pending withdrawals from the oldest.
+func FindPendingWithdrawals(ctx context.Context, q ContextQuerier) ([]ExternalTransaction, error) {
+	rows, err := q.QueryContext(ctx, findPendingWithdrawalsQuery)
+	if err != nil {
+		return nil, ErrFindPendingWithdrawals.Wrap(err)
+	}
+	defer rows.Close()
+
+	var rv []ExternalTransaction
+	for rows.Next() {
+		t, err := scanExternalTransaction(rows)
+		if err != nil {
+			return nil, ErrFindPendingWithdrawals.Wrap(err)
+		}
+
+		rv = append(rv, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindPendingWithdrawals.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// CountPendingWithdrawals returns the number of pending withdrawals of the wallet.
+func CountPendingWithdrawals(ctx context.Context, q ContextRowQuerier, wallet WalletID) (int64, error) {
+	var n int64
+
+	err := q.QueryRowContext(ctx, countPendingWithdrawalsQuery, wallet).Scan(&n)
+	if err != nil {
+		return 0, ErrCountPendingWithdrawals.Wrap(err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanFeePolicy       = errs.Class("scan fee policy")
+	ErrFindActiveFeePolicy = errs.Class("find active fee policy")
+)
+
+type FeePolicy interface {
+	ID() string
+	Spec() []byte
+}
+
+type feePolicyImpl struct {
+	id   string
+	spec []byte
+}
+
+func (p *feePolicyImpl) ID() string {
+	return p.id
+}
+
+func (p *feePolicyImpl) Spec() []byte {
+	return p.spec
+}
+
+const findActiveFeePolicyQuery = `select id, spec from fee_policies where active`
+
+func scanFeePolicy(s Scanner) (FeePolicy, error) {
+	var p feePolicyImpl
+
+	err := s.Scan(&p.id, &p.spec)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanFeePolicy.Wrap(err)
+	}
+
+	return &p, nil
+}
+
+func FindActiveFeePolicy(ctx context.Context, q ContextRowQuerier) (FeePolicy, error) {
+	p, err := scanFeePolicy(q.QueryRowContext(ctx, findActiveFeePolicyQuery))
+	if err != nil {
+		return nil, ErrFindActiveFeePolicy.Wrap(err)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanFunding   = errs.Class("scan funding")
+	ErrCreateFunding = errs.Class("create funding")
+)
+
+type FundingID = ID
+
+// Funding is an administrative credit of a wallet, e.g. its opening balance.
+type Funding interface {
+	ID() FundingID
+	Wallet() WalletID
+	Amount() Decimal
+	Reason() string
+	CreatedAt() time.Time
+}
+
+type fundingImpl struct {
+	id        FundingID
+	wallet    WalletID
+	amount    Decimal
+	reason    string
+	createdAt time.Time
+}
+
+func (f *fundingImpl) ID() FundingID {
+	return f.id
+}
+
+func (f *fundingImpl) Wallet() WalletID {
+	return f.wallet
+}
+
+func (f *fundingImpl) Amount() Decimal {
+	return f.amount
+}
+
+func (f *fundingImpl) Reason() string {
+	return f.reason
+}
+
+func (f *fundingImpl) CreatedAt() time.Time {
+	return f.createdAt
+}
+
+const createFundingQuery = `
+	insert into fundings (wallet, amount, reason) values ($1, $2, $3)
+	returning id, wallet, amount, reason, created_at`
+
+func scanFunding(s Scanner) (Funding, error) {
+	var f fundingImpl
+
+	err := s.Scan(&f.id, &f.wallet, &f.amount, &f.reason, &f.createdAt)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanFunding.Wrap(err)
+	}
+
+	return &f, nil
+}
+
+func CreateFunding(ctx context.Context, q ContextRowQuerier, wallet WalletID, amount Decimal, reason string) (Funding, error) {
+	f, err := scanFunding(q.QueryRowContext(ctx, createFundingQuery, wallet, amount, reason))
+	if err != nil {
+		return nil, ErrCreateFunding.Wrap(err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanFXQuote   = errs.Class("scan fx quote")
+	ErrCreateFXQuote = errs.Class("create fx quote")
+	ErrFindFXQuote   = errs.Class("find fx quote")
+	ErrLockFXQuote   = errs.Class("lock fx quote")
+	ErrUseFXQuote    = errs.Class("use fx quote")
+)
+
+type FXQuoteID = ID
+
+// FXQuote locks the conversion of Amount from the sender to the receiver
+// until ExpiresAt.
+type FXQuote interface {
+	ID() FXQuoteID
+	From() WalletID
+	To() WalletID
+	// Base and Quote are the currencies of the sender and the receiver.
+	Base() CurrencyCode
+	Quote() CurrencyCode
+	Amount() Decimal
+	DestinationAmount() Decimal
+	ExchangeRate() Decimal
+	ExchangeRateID() string
+	FXSpread() Decimal
+	// Transfer is set once the quote is used.
+	Transfer() *TransferID
+	ExpiresAt() time.Time
+	CreatedAt() time.Time
+}
+
+type fxQuoteImpl struct {
+	id         FXQuoteID
+	from       WalletID
+	to         WalletID
+	base       CurrencyCode
+	quote      CurrencyCode
+	amount     Decimal
+	destAmount Decimal
+	rate       Decimal
+	rateID     string
+	fxSpread   Decimal
+	transfer   *TransferID
+	expiresAt  time.Time
+	createdAt  time.Time
+}
+
+func (q *fxQuoteImpl) ID() FXQuoteID {
+	return q.id
+}
+
+func (q *fxQuoteImpl) From() WalletID {
+	return q.from
+}
+
+func (q *fxQuoteImpl) To() WalletID {
+	return q.to
+}
+
+func (q *fxQuoteImpl) Base() CurrencyCode {
+	return q.base
+}
+
+func (q *fxQuoteImpl) Quote() CurrencyCode {
+	return q.quote
+}
+
+func (q *fxQuoteImpl) Amount() Decimal {
+	return q.amount
+}
+
+func (q *fxQuoteImpl) DestinationAmount() Decimal {
+	return q.destAmount
+}
+
+func (q *fxQuoteImpl) ExchangeRate() Decimal {
+	return q.rate
+}
+
+func (q *fxQuoteImpl) ExchangeRateID() string {
+	return q.rateID
+}
+
+func (q *fxQuoteImpl) FXSpread() Decimal {
+	return q.fxSpread
+}
+
+func (q *fxQuoteImpl) Transfer() *TransferID {
+	return q.transfer
+}
+
+func (q *fxQuoteImpl) ExpiresAt() time.Time {
+	return q.expiresAt
+}
+
+func (q *fxQuoteImpl) CreatedAt() time.Time {
+	return q.createdAt
+}
+
+const (
+	fxQuoteColumns = `id, sender, receiver, base, quote, amount, destination_amount, exchange_rate, exchange_rate_id, ` +
+		`fx_spread, transfer, expires_at, created_at`
+
+	createFXQuoteQuery = `
+	insert into fx_quotes (
+		sender, receiver, base, quote, amount, destination_amount, exchange_rate, exchange_rate_id, fx_spread, expires_at
+	)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	returning ` + fxQuoteColumns
+	findFXQuoteByIDQuery = `
+	select ` + fxQuoteColumns + ` from fx_quotes where id = $1`
+	lockFXQuoteByIDQuery = `
+	select ` + fxQuoteColumns + ` from fx_quotes where id = $1 for update`
+	useFXQuoteQuery = `
+	update fx_quotes set transfer = $2
+	where id = $1 and transfer is null
+	returning ` + fxQuoteColumns
+)
+
+func scanFXQuote(s Scanner) (FXQuote, error) {
+	var q fxQuoteImpl
+	var transfer sql.NullInt64
+
+	err := s.Scan(
+		&q.id,
+		&q.from,
+		&q.to,
+		&q.base,
+		&q.quote,
+		&q.amount,
+		&q.destAmount,
+		&q.rate,
+		&q.rateID,
+		&q.fxSpread,
+		&transfer,
+		&q.expiresAt,
+		&q.createdAt,
+	)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanFXQuote.Wrap(err)
+	}
+
+	q.transfer = nullID(transfer)
+
+	return &q, nil
+}
+
+type CreateFXQuoteParams struct {
+	From              WalletID
+	To                WalletID
+	Base              CurrencyCode
+	Quote             CurrencyCode
+	Amount            Decimal
+	DestinationAmount Decimal
+	ExchangeRate      Decimal
+	ExchangeRateID    string
+	FXSpread          Decimal
+	ExpiresAt         time.Time
+}
+
+func CreateFXQuote(ctx context.Context, q ContextRowQuerier, params *CreateFXQuoteParams) (FXQuote, error) {
+	row := q.QueryRowContext(
+		ctx,
+		createFXQuoteQuery,
+		params.From,
+		params.To,
+		params.Base,
+		params.Quote,
+		params.Amount,
+		params.DestinationAmount,
+		params.ExchangeRate,
+		params.ExchangeRateID,
+		params.FXSpread,
+		params.ExpiresAt,
+	)
+
+	fq, err := scanFXQuote(row)
+	if err != nil {
+		return nil, ErrCreateFXQuote.Wrap(err)
+	}
+
+	return fq, nil
+}
+
+func FindFXQuoteByID(ctx context.Context, q ContextRowQuerier, id FXQuoteID) (FXQuote, error) {
+	fq, err := scanFXQuote(q.QueryRowContext(ctx, findFXQuoteByIDQuery, id))
+	if err != nil {
+		return nil, ErrFindFXQuote.Wrap(err)
+	}
+
+	return fq, nil
+}
+
+// LockFXQuoteByID loads the quote and holds a row lock on it until the
+// transaction ends.
+func LockFXQuoteByID(ctx context.Context, tx *sql.Tx, id FXQuoteID) (FXQuote, error) {
+	fq, err := scanFXQuote(tx.QueryRowContext(ctx, lockFXQuoteByIDQuery, id))
+	if err != nil {
+		return nil, ErrLockFXQuote.Wrap(err)
+	}
+
+	return fq, nil
+}
+
+// UseFXQuote links the quote to the transfer it was used for. It returns nil
+// if the quote was already used.
+func UseFXQuote(ctx context.Context, q ContextRowQuerier, id FXQuoteID, transfer TransferID) (FXQuote, error) {
+	fq, err := scanFXQuote(q.QueryRowContext(ctx, useFXQuoteQuery, id, transfer))
+	if err != nil {
+		return nil, ErrUseFXQuote.Wrap(err)
+	}
+
+	return fq, nil
+}
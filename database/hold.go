@@ -0,0 +1,247 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanHold         = errs.Class("scan hold")
+	ErrCreateHold       = errs.Class("create hold")
+	ErrFindHold         = errs.Class("find hold")
+	ErrLockHold         = errs.Class("lock hold")
+	ErrFinishHold       = errs.Class("finish hold")
+	ErrFindExpiredHolds = errs.Class("find expired holds")
+)
+
+type HoldID = ID
+
+// Statuses of holds. Only pending holds reserve funds.
+const (
+	HoldPending  = "pending"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+// Hold reserves amount plus fee on the sender until it is captured into a
+// transfer, voided or expires.
+type Hold interface {
+	ID() HoldID
+	From() WalletID
+	To() WalletID
+	Amount() Decimal
+	FeeAmount() Decimal
+	FeePolicy() string
+	Status() string
+	// Transfer is set once the hold is captured.
+	Transfer() *TransferID
+	ExpiresAt() time.Time
+	CreatedAt() time.Time
+	// FinishedAt is nil while the hold is pending.
+	FinishedAt() *time.Time
+}
+
+type holdImpl struct {
+	id         HoldID
+	from       WalletID
+	to         WalletID
+	amount     Decimal
+	feeAmount  Decimal
+	feePolicy  string
+	status     string
+	transfer   *TransferID
+	expiresAt  time.Time
+	createdAt  time.Time
+	finishedAt *time.Time
+}
+
+func (h *holdImpl) ID() HoldID {
+	return h.id
+}
+
+func (h *holdImpl) From() WalletID {
+	return h.from
+}
+
+func (h *holdImpl) To() WalletID {
+	return h.to
+}
+
+func (h *holdImpl) Amount() Decimal {
+	return h.amount
+}
+
+func (h *holdImpl) FeeAmount() Decimal {
+	return h.feeAmount
+}
+
+func (h *holdImpl) FeePolicy() string {
+	return h.feePolicy
+}
+
+func (h *holdImpl) Status() string {
+	return h.status
+}
+
+func (h *holdImpl) Transfer() *TransferID {
+	return h.transfer
+}
+
+func (h *holdImpl) ExpiresAt() time.Time {
+	return h.expiresAt
+}
+
+func (h *holdImpl) CreatedAt() time.Time {
+	return h.createdAt
+}
+
+func (h *holdImpl) FinishedAt() *time.Time {
+	return h.finishedAt
+}
+
+const (
+	holdColumns = `id, sender, receiver, amount, fee_amount, coalesce(fee_policy, ''), status, transfer, expires_at, created_at, finished_at`
+
+	createHoldQuery = `
+	insert into holds (sender, receiver, amount, fee_amount, fee_policy, status, expires_at)
+	values ($1, $2, $3, $4, nullif($5, ''), 'pending', $6)
+	returning ` + holdColumns
+	findHoldByIDQuery = `
+	select ` + holdColumns + ` from holds where id = $1`
+	lockHoldByIDQuery = `
+	select ` + holdColumns + ` from holds where id = $1 for update`
+	finishHoldQuery = `
+	update holds set status = $2, transfer = $3, finished_at = now()
+	where id = $1 and status = 'pending'
+	returning ` + holdColumns
+	findExpiredHoldsQuery = `
+	select ` + holdColumns + ` from holds
+	where status = 'pending' and expires_at <= $1
+	order by expires_at, id
+	limit $2`
+)
+
+func scanHold(s Scanner) (Hold, error) {
+	var h holdImpl
+	var transfer sql.NullInt64
+	var finishedAt sql.NullTime
+
+	err := s.Scan(
+		&h.id,
+		&h.from,
+		&h.to,
+		&h.amount,
+		&h.feeAmount,
+		&h.feePolicy,
+		&h.status,
+		&transfer,
+		&h.expiresAt,
+		&h.createdAt,
+		&finishedAt,
+	)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanHold.Wrap(err)
+	}
+
+	h.transfer = nullID(transfer)
+	if finishedAt.Valid {
+		h.finishedAt = &finishedAt.Time
+	}
+
+	return &h, nil
+}
+
+type CreateHoldParams struct {
+	From      WalletID
+	To        WalletID
+	Amount    Decimal
+	FeeAmount Decimal
+	FeePolicy string
+	ExpiresAt time.Time
+}
+
+// CreateHold only records the hold, the funds are reserved by HoldFunds.
+func CreateHold(ctx context.Context, q ContextRowQuerier, params *CreateHoldParams) (Hold, error) {
+	row := q.QueryRowContext(
+		ctx,
+		createHoldQuery,
+		params.From,
+		params.To,
+		params.Amount,
+		params.FeeAmount,
+		params.FeePolicy,
+		params.ExpiresAt,
+	)
+
+	h, err := scanHold(row)
+	if err != nil {
+		return nil, ErrCreateHold.Wrap(err)
+	}
+
+	return h, nil
+}
+
+func FindHoldByID(ctx context.Context, q ContextRowQuerier, id HoldID) (Hold, error) {
+	h, err := scanHold(q.QueryRowContext(ctx, findHoldByIDQuery, id))
+	if err != nil {
+		return nil, ErrFindHold.Wrap(err)
+	}
+
+	return h, nil
+}
+
+// LockHoldByID loads the hold and holds a row lock on it until the
+// transaction ends.
+func LockHoldByID(ctx context.Context, tx *sql.Tx, id HoldID) (Hold, error) {
+	h, err := scanHold(tx.QueryRowContext(ctx, lockHoldByIDQuery, id))
+	if err != nil {
+		return nil, ErrLockHold.Wrap(err)
+	}
+
+	return h, nil
+}
+
+// FinishHold sets the final status of a pending hold and the transfer it was
+// captured into, if any. It returns nil if the hold is not pending.
+func FinishHold(ctx context.Context, q ContextRowQuerier, id HoldID, status string, transfer *TransferID) (Hold, error) {
+	h, err := scanHold(q.QueryRowContext(ctx, finishHoldQuery, id, status, transfer))
+	if err != nil {
+		return nil, ErrFinishHold.Wrap(err)
+	}
+
+	return h, nil
+}
+
+// FindExpiredHolds returns up to limit pending holds that expired at or
+// before now, from the oldest.
+func FindExpiredHolds(ctx context.Context, q ContextQuerier, now time.Time, limit int) ([]Hold, error) {
+	rows, err := q.QueryContext(ctx, findExpiredHoldsQuery, now, limit)
+	if err != nil {
+		return nil, ErrFindExpiredHolds.Wrap(err)
+	}
+	defer rows.Close()
+
+	var rv []Hold
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, ErrFindExpiredHolds.Wrap(err)
+		}
+
+		rv = append(rv, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindExpiredHolds.Wrap(err)
+	}
+
+	return rv, nil
+}
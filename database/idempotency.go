@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanIdempotencyKey           = errs.Class("scan idempotency key")
+	ErrClaimIdempotencyKey          = errs.Class("claim idempotency key")
+	ErrFindIdempotencyKey           = errs.Class("find idempotency key")
+	ErrSaveIdempotentResponse       = errs.Class("save idempotent response")
+	ErrDeleteExpiredIdempotencyKeys = errs.Class("delete expired idempotency keys")
+)
+
+type IdempotencyKey interface {
+	Key() string
+	RequestHash() string
+	// Status is zero until the response is saved.
+	Status() int
+	Response() []byte
+	TransferID() *TransferID
+	ExpiresAt() time.Time
+}
+
+type idempotencyKeyImpl struct {
+	key         string
+	requestHash string
+	status      int
+	response    []byte
+	transferID  *TransferID
+	expiresAt   time.Time
+}
+
+func (k *idempotencyKeyImpl) Key() string {
+	return k.key
+}
+
+func (k *idempotencyKeyImpl) RequestHash() string {
+	return k.requestHash
+}
+
+func (k *idempotencyKeyImpl) Status() int {
+	return k.status
+}
+
+func (k *idempotencyKeyImpl) Response() []byte {
+	return k.response
+}
+
+func (k *idempotencyKeyImpl) TransferID() *TransferID {
+	return k.transferID
+}
+
+func (k *idempotencyKeyImpl) ExpiresAt() time.Time {
+	return k.expiresAt
+}
+
+const (
+	deleteExpiredIdempotencyKeyQuery = `delete from idempotency_keys where key = $1 and expires_at <= now()`
+	claimIdempotencyKeyQuery         = `
+	insert into idempotency_keys (key, request_hash, expires_at) values ($1, $2, now() + $3 * interval '1 second')
+	on conflict (key) do nothing
+	returning key, request_hash, coalesce(status, 0), response, transfer_id, expires_at`
+	findIdempotencyKeyQuery = `
+	select key, request_hash, coalesce(status, 0), response, transfer_id, expires_at
+	from idempotency_keys where key = $1`
+	saveIdempotentResponseQuery = `
+	update idempotency_keys set status = $2, response = $3, transfer_id = $4 where key = $1
+	returning key, request_hash, coalesce(status, 0), response, transfer_id, expires_at`
+	deleteExpiredIdempotencyKeysQuery = `delete from idempotency_keys where expires_at <= now()`
+)
+
+func scanIdempotencyKey(s Scanner) (IdempotencyKey, error) {
+	var k idempotencyKeyImpl
+	var transferID sql.NullInt64
+
+	err := s.Scan(&k.key, &k.requestHash, &k.status, &k.response, &transferID, &k.expiresAt)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanIdempotencyKey.Wrap(err)
+	}
+
+	if transferID.Valid {
+		id := TransferID(transferID.Int64)
+		k.transferID = &id
+	}
+
+	return &k, nil
+}
+
+// ClaimIdempotencyKey stores the key unless there is an unexpired one already,
+// in which case it returns nil. An expired key is replaced. If another
+// transaction has claimed the key but not committed yet, the call blocks
+// until that transaction ends.
+func ClaimIdempotencyKey(ctx context.Context, q ContextRowQueryExecutor, key, requestHash string, retention time.Duration) (IdempotencyKey, error) {
+	_, err := q.ExecContext(ctx, deleteExpiredIdempotencyKeyQuery, key)
+	if err != nil {
+		return nil, ErrClaimIdempotencyKey.Wrap(err)
+	}
+
+	k, err := scanIdempotencyKey(q.QueryRowContext(ctx, claimIdempotencyKeyQuery, key, requestHash, retention.Seconds()))
+	if err != nil {
+		return nil, ErrClaimIdempotencyKey.Wrap(err)
+	}
+
+	return k, nil
+}
+
+func FindIdempotencyKey(ctx context.Context, q ContextRowQuerier, key string) (IdempotencyKey, error) {
+	k, err := scanIdempotencyKey(q.QueryRowContext(ctx, findIdempotencyKeyQuery, key))
+	if err != nil {
+		return nil, ErrFindIdempotencyKey.Wrap(err)
+	}
+
+	return k, nil
+}
+
+func SaveIdempotentResponse(ctx context.Context, q ContextRowQuerier, key string, status int, response []byte, transferID *TransferID) (IdempotencyKey, error) {
+	k, err := scanIdempotencyKey(q.QueryRowContext(ctx, saveIdempotentResponseQuery, key, status, response, transferID))
+	if err != nil {
+		return nil, ErrSaveIdempotentResponse.Wrap(err)
+	}
+
+	return k, nil
+}
+
+func DeleteExpiredIdempotencyKeys(ctx context.Context, q ContextExecutor) (int64, error) {
+	res, err := q.ExecContext(ctx, deleteExpiredIdempotencyKeysQuery)
+	if err != nil {
+		return 0, ErrDeleteExpiredIdempotencyKeys.Wrap(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, ErrDeleteExpiredIdempotencyKeys.Wrap(err)
+	}
+
+	return n, nil
+}
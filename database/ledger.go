@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanJournal       = errs.Class("scan journal")
+	ErrCreateJournal     = errs.Class("create journal")
+	ErrScanLedgerEntry   = errs.Class("scan ledger entry")
+	ErrCreateLedgerEntry = errs.Class("create ledger entry")
+)
+
+type JournalID = ID
+type LedgerEntryID = ID
+
+// Journal groups the ledger entries of one business operation. The amounts
+// of its entries sum to zero per currency.
+type Journal interface {
+	ID() JournalID
+	Kind() string
+	// The operation the journal was posted for, at most one is not nil.
+	Transfer() *TransferID
+	Funding() *FundingID
+	ExternalTransaction() *ExternalTransactionID
+	// Memo is empty unless the journal is an adjustment.
+	Memo() string
+	CreatedAt() time.Time
+}
+
+type journalImpl struct {
+	id                  JournalID
+	kind                string
+	transfer            *TransferID
+	funding             *FundingID
+	externalTransaction *ExternalTransactionID
+	memo                string
+	createdAt           time.Time
+}
+
+func (j *journalImpl) ID() JournalID {
+	return j.id
+}
+
+func (j *journalImpl) Kind() string {
+	return j.kind
+}
+
+func (j *journalImpl) Transfer() *TransferID {
+	return j.transfer
+}
+
+func (j *journalImpl) Funding() *FundingID {
+	return j.funding
+}
+
+func (j *journalImpl) ExternalTransaction() *ExternalTransactionID {
+	return j.externalTransaction
+}
+
+func (j *journalImpl) Memo() string {
+	return j.memo
+}
+
+func (j *journalImpl) CreatedAt() time.Time {
+	return j.createdAt
+}
+
+// LedgerEntry changes the balance of an account by a signed amount.
+type LedgerEntry interface {
+	ID() LedgerEntryID
+	Journal() JournalID
+	Account() string
+	// Wallet is not nil for wallet accounts.
+	Wallet() *WalletID
+	Currency() CurrencyCode
+	Amount() Decimal
+	// BalanceAfter is the balance of the wallet right after the entry, it is
+	// nil for accounts other than wallets.
+	BalanceAfter() *Decimal
+}
+
+type ledgerEntryImpl struct {
+	id           LedgerEntryID
+	journal      JournalID
+	account      string
+	wallet       *WalletID
+	currency     CurrencyCode
+	amount       Decimal
+	balanceAfter *Decimal
+}
+
+func (e *ledgerEntryImpl) ID() LedgerEntryID {
+	return e.id
+}
+
+func (e *ledgerEntryImpl) Journal() JournalID {
+	return e.journal
+}
+
+func (e *ledgerEntryImpl) Account() string {
+	return e.account
+}
+
+func (e *ledgerEntryImpl) Wallet() *WalletID {
+	return e.wallet
+}
+
+func (e *ledgerEntryImpl) Currency() CurrencyCode {
+	return e.currency
+}
+
+func (e *ledgerEntryImpl) Amount() Decimal {
+	return e.amount
+}
+
+func (e *ledgerEntryImpl) BalanceAfter() *Decimal {
+	return e.balanceAfter
+}
+
+const (
+	journalColumns     = `id, kind, transfer, funding, external_transaction, coalesce(memo, ''), created_at`
+	ledgerEntryColumns = `id, journal, account, wallet, currency, amount, balance_after`
+
+	createJournalQuery = `
+	insert into journals (kind, transfer, funding, external_transaction, memo) values ($1, $2, $3, $4, nullif($5, ''))
+	returning ` + journalColumns
+	createLedgerEntryQuery = `
+	insert into ledger_entries (journal, account, wallet, currency, amount, balance_after)
+	values ($1, $2, $3, $4, $5, $6)
+	returning ` + ledgerEntryColumns
+)
+
+func scanJournal(s Scanner) (Journal, error) {
+	var j journalImpl
+	var transfer, funding, externalTransaction sql.NullInt64
+
+	err := s.Scan(&j.id, &j.kind, &transfer, &funding, &externalTransaction, &j.memo, &j.createdAt)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanJournal.Wrap(err)
+	}
+
+	j.transfer = nullID(transfer)
+	j.funding = nullID(funding)
+	j.externalTransaction = nullID(externalTransaction)
+
+	return &j, nil
+}
+
+func scanLedgerEntry(s Scanner) (LedgerEntry, error) {
+	var e ledgerEntryImpl
+	var wallet sql.NullInt64
+	var balanceAfter sql.NullString
+
+	err := s.Scan(&e.id, &e.journal, &e.account, &wallet, &e.currency, &e.amount, &balanceAfter)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanLedgerEntry.Wrap(err)
+	}
+
+	e.wallet = nullID(wallet)
+	if balanceAfter.Valid {
+		d := Decimal(balanceAfter.String)
+		e.balanceAfter = &d
+	}
+
+	return &e, nil
+}
+
+func nullID(v sql.NullInt64) *ID {
+	if !v.Valid {
+		return nil
+	}
+
+	id := ID(v.Int64)
+	return &id
+}
+
+type CreateJournalParams struct {
+	Kind                string
+	Transfer            *TransferID
+	Funding             *FundingID
+	ExternalTransaction *ExternalTransactionID
+	Memo                string
+}
+
+func CreateJournal(ctx context.Context, q ContextRowQuerier, params *CreateJournalParams) (Journal, error) {
+	row := q.QueryRowContext(ctx, createJournalQuery, params.Kind, params.Transfer, params.Funding, params.ExternalTransaction, params.Memo)
+	j, err := scanJournal(row)
+	if err != nil {
+		return nil, ErrCreateJournal.Wrap(err)
+	}
+
+	return j, nil
+}
+
+type CreateLedgerEntryParams struct {
+	Journal      JournalID
+	Account      string
+	Wallet       *WalletID
+	Currency     CurrencyCode
+	Amount       Decimal
+	BalanceAfter *Decimal
+}
+
+func CreateLedgerEntry(ctx context.Context, q ContextRowQuerier, params *CreateLedgerEntryParams) (LedgerEntry, error) {
+	row := q.QueryRowContext(
+		ctx,
+		createLedgerEntryQuery,
+		params.Journal,
+		params.Account,
+		params.Wallet,
+		params.Currency,
+		params.Amount,
+		params.BalanceAfter,
+	)
+	e, err := scanLedgerEntry(row)
+	if err != nil {
+		return nil, ErrCreateLedgerEntry.Wrap(err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,251 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanOwner              = errs.Class("scan owner")
+	ErrCreateOwner            = errs.Class("create owner")
+	ErrFindOwner              = errs.Class("find owner")
+	ErrFindAllOwners          = errs.Class("find all owners")
+	ErrLockOwner              = errs.Class("lock owner")
+	ErrUpdateOwner            = errs.Class("update owner")
+	ErrDeleteOwner            = errs.Class("delete owner")
+	ErrFindOwnerWallets       = errs.Class("find owner wallets")
+	ErrCountOwnerWallets      = errs.Class("count owner wallets")
+	ErrOwnerHasManyInCurrency = errs.Class("owner has many wallets in currency")
+)
+
+type OwnerID = ID
+
+// Owner is the holder of wallets, e.g. a customer.
+type Owner interface {
+	ID() OwnerID
+	Name() string
+	// Metadata is a JSON object.
+	Metadata() []byte
+	// AllowMultipleWallets lets the owner hold more than one wallet per currency.
+	AllowMultipleWallets() bool
+	CreatedAt() time.Time
+	UpdatedAt() time.Time
+}
+
+type ownerImpl struct {
+	id                   OwnerID
+	name                 string
+	metadata             []byte
+	allowMultipleWallets bool
+	createdAt            time.Time
+	updatedAt            time.Time
+}
+
+func (o *ownerImpl) ID() OwnerID {
+	return o.id
+}
+
+func (o *ownerImpl) Name() string {
+	return o.name
+}
+
+func (o *ownerImpl) Metadata() []byte {
+	return o.metadata
+}
+
+func (o *ownerImpl) AllowMultipleWallets() bool {
+	return o.allowMultipleWallets
+}
+
+func (o *ownerImpl) CreatedAt() time.Time {
+	return o.createdAt
+}
+
+func (o *ownerImpl) UpdatedAt() time.Time {
+	return o.updatedAt
+}
+
+const (
+	ownerColumns = `id, name, metadata, allow_multiple_wallets, created_at, updated_at`
+
+	createOwnerQuery = `
+	insert into owners (name, metadata, allow_multiple_wallets) values ($1, $2, $3)
+	returning ` + ownerColumns
+	findAllOwnersQuery = `select ` + ownerColumns + ` from owners order by id`
+	findOwnerByIDQuery = `select ` + ownerColumns + ` from owners where id = $1`
+	lockOwnerByIDQuery = `select ` + ownerColumns + ` from owners where id = $1 for update`
+	updateOwnerQuery   = `
+	update owners set name = $2, metadata = $3, allow_multiple_wallets = $4, updated_at = now()
+	where id = $1
+	returning ` + ownerColumns
+	deleteOwnerQuery      = `delete from owners where id = $1`
+	findOwnerWalletsQuery = `select ` + walletColumns + ` from wallets where owner_id = $1 order by currency, id`
+	// closed wallets only count without a currency, they still belong to the owner
+	countOwnerWalletsQuery = `
+	select count(*) from wallets
+	where owner_id = $1 and ($2 = '' or currency = $2 and status <> 'closed')`
+	ownerHasManyInCurrencyQuery = `
+	select exists (
+		select 1 from wallets where owner_id = $1 and status <> 'closed' group by currency having count(*) > 1
+	)`
+)
+
+func scanOwner(s Scanner) (Owner, error) {
+	var o ownerImpl
+
+	err := s.Scan(&o.id, &o.name, &o.metadata, &o.allowMultipleWallets, &o.createdAt, &o.updatedAt)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanOwner.Wrap(err)
+	}
+
+	return &o, nil
+}
+
+type OwnerParams struct {
+	Name string
+	// Metadata must be a JSON object.
+	Metadata             []byte
+	AllowMultipleWallets bool
+}
+
+func CreateOwner(ctx context.Context, q ContextRowQuerier, params *OwnerParams) (Owner, error) {
+	row := q.QueryRowContext(ctx, createOwnerQuery, params.Name, string(params.Metadata), params.AllowMultipleWallets)
+
+	o, err := scanOwner(row)
+	if err != nil {
+		return nil, ErrCreateOwner.Wrap(err)
+	}
+
+	return o, nil
+}
+
+func FindAllOwners(ctx context.Context, q ContextQuerier) ([]Owner, error) {
+	rows, err := q.QueryContext(ctx, findAllOwnersQuery)
+	if err != nil {
+		return nil, ErrFindAllOwners.Wrap(err)
+	}
+	defer rows.Close()
+
+	var owners []Owner
+	for rows.Next() {
+		o, err := scanOwner(rows)
+		if err != nil {
+			return nil, ErrFindAllOwners.Wrap(err)
+		}
+
+		owners = append(owners, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindAllOwners.Wrap(err)
+	}
+
+	return owners, nil
+}
+
+func FindOwnerByID(ctx context.Context, q ContextRowQuerier, id OwnerID) (Owner, error) {
+	o, err := scanOwner(q.QueryRowContext(ctx, findOwnerByIDQuery, id))
+	if err != nil {
+		return nil, ErrFindOwner.Wrap(err)
+	}
+
+	return o, nil
+}
+
+// LockOwnerByID loads the owner and holds a row lock on it until the
+// transaction ends. Wallets of the owner are only created or removed under
+// this lock.
+func LockOwnerByID(ctx context.Context, tx *sql.Tx, id OwnerID) (Owner, error) {
+	o, err := scanOwner(tx.QueryRowContext(ctx, lockOwnerByIDQuery, id))
+	if err != nil {
+		return nil, ErrLockOwner.Wrap(err)
+	}
+
+	return o, nil
+}
+
+// UpdateOwner replaces the fields of the owner. It returns nil if the owner
+// does not exist.
+func UpdateOwner(ctx context.Context, q ContextRowQuerier, id OwnerID, params *OwnerParams) (Owner, error) {
+	row := q.QueryRowContext(ctx, updateOwnerQuery, id, params.Name, string(params.Metadata), params.AllowMultipleWallets)
+
+	o, err := scanOwner(row)
+	if err != nil {
+		return nil, ErrUpdateOwner.Wrap(err)
+	}
+
+	return o, nil
+}
+
+// DeleteOwner returns false if the owner does not exist.
+func DeleteOwner(ctx context.Context, q ContextExecutor, id OwnerID) (bool, error) {
+	res, err := q.ExecContext(ctx, deleteOwnerQuery, id)
+	if err != nil {
+		return false, ErrDeleteOwner.Wrap(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, ErrDeleteOwner.Wrap(err)
+	}
+
+	return n > 0, nil
+}
+
+// FindOwnerWallets returns the wallets of the owner ordered by currency.
+func FindOwnerWallets(ctx context.Context, q ContextQuerier, id OwnerID) ([]Wallet, error) {
+	rows, err := q.QueryContext(ctx, findOwnerWalletsQuery, id)
+	if err != nil {
+		return nil, ErrFindOwnerWallets.Wrap(err)
+	}
+	defer rows.Close()
+
+	var wallets []Wallet
+	for rows.Next() {
+		w, err := scanWallet(rows)
+		if err != nil {
+			return nil, ErrFindOwnerWallets.Wrap(err)
+		}
+
+		wallets = append(wallets, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindOwnerWallets.Wrap(err)
+	}
+
+	return wallets, nil
+}
+
+// CountOwnerWallets counts the wallets of the owner that are not closed in
+// the currency, or all the wallets of the owner if currency is empty.
+func CountOwnerWallets(ctx context.Context, q ContextRowQuerier, id OwnerID, currency CurrencyCode) (int64, error) {
+	var n int64
+
+	err := q.QueryRowContext(ctx, countOwnerWalletsQuery, id, currency).Scan(&n)
+	if err != nil {
+		return 0, ErrCountOwnerWallets.Wrap(err)
+	}
+
+	return n, nil
+}
+
+// OwnerHasManyInCurrency tells whether the owner holds more than one wallet
+// that is not closed in any currency.
+func OwnerHasManyInCurrency(ctx context.Context, q ContextRowQuerier, id OwnerID) (bool, error) {
+	var rv bool
+
+	err := q.QueryRowContext(ctx, ownerHasManyInCurrencyQuery, id).Scan(&rv)
+	if err != nil {
+		return false, ErrOwnerHasManyInCurrency.Wrap(err)
+	}
+
+	return rv, nil
+}
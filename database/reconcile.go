@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+
+	pg "github.com/lib/pq"
+	"github.com/zeebo/errs"
+)
+
+var ErrFindWalletBalances = errs.Class("find wallet balances")
+
+// WalletBalances compares the stored balance of a wallet with the balance
+// expected from its history and with the sum of its ledger entries.
+type WalletBalances interface {
+	Wallet() WalletID
+	Currency() CurrencyCode
+	Stored() Decimal
+	Expected() Decimal
+	Ledger() Decimal
+}
+
+type walletBalancesImpl struct {
+	wallet   WalletID
+	currency CurrencyCode
+	stored   Decimal
+	expected Decimal
+	ledger   Decimal
+}
+
+func (b *walletBalancesImpl) Wallet() WalletID {
+	return b.wallet
+}
+
+func (b *walletBalancesImpl) Currency() CurrencyCode {
+	return b.currency
+}
+
+func (b *walletBalancesImpl) Stored() Decimal {
+	return b.stored
+}
+
+func (b *walletBalancesImpl) Expected() Decimal {
+	return b.expected
+}
+
+func (b *walletBalancesImpl) Ledger() Decimal {
+	return b.ledger
+}
+
+// walletBalancesQuery recomputes the balances from the records of every
+// operation that moves funds. Opening and adjustment journals have no other
+// record, so their ledger entries are part of the history.
+const walletBalancesQuery = `
+	select w.id, w.currency, w.balance,
+		coalesce((select sum(amount) from fundings where wallet = w.id), 0)
+		+ coalesce((select sum(coalesce(destination_amount, amount) + fee_refund) from transactions where receiver = w.id), 0)
+		- coalesce((select sum(amount + fee_amount) from transactions where sender = w.id), 0)
+		+ coalesce((select sum(fee_amount - fee_refund) from transactions where fee_wallet = w.id), 0)
+		+ coalesce((select sum(fx_spread) from transactions where spread_wallet = w.id), 0)
+		+ coalesce((
+			-- a failed withdrawal is credited back
+			select sum(case when kind = 'deposit' then amount when status = 'failed' then 0 else -amount end)
+			from external_transactions where wallet = w.id
+		), 0)
+		+ coalesce((
+			select sum(e.amount) from ledger_entries e join journals j on j.id = e.journal
+			where e.wallet = w.id and j.kind in ('opening', 'adjustment')
+		), 0),
+		coalesce((select sum(amount) from ledger_entries where wallet = w.id), 0)
+	from wallets w`
+
+func scanWalletBalances(s Scanner) (WalletBalances, error) {
+	var b walletBalancesImpl
+
+	err := s.Scan(&b.wallet, &b.currency, &b.stored, &b.expected, &b.ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// FindWalletBalances returns the balances of the given wallets or of all
+// wallets if ids is empty.
+func FindWalletBalances(ctx context.Context, q ContextQuerier, ids []WalletID) ([]WalletBalances, error) {
+	query := walletBalancesQuery + ` where cardinality($1::integer[]) = 0 or w.id = any($1) order by w.id`
+	rows, err := q.QueryContext(ctx, query, pg.Array(ids))
+	if err != nil {
+		return nil, ErrFindWalletBalances.Wrap(err)
+	}
+	defer rows.Close()
+
+	var rv []WalletBalances
+	for rows.Next() {
+		b, err := scanWalletBalances(rows)
+		if err != nil {
+			return nil, ErrFindWalletBalances.Wrap(err)
+		}
+
+		rv = append(rv, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindWalletBalances.Wrap(err)
+	}
+
+	return rv, nil
+}
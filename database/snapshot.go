@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrFindBalanceAt          = errs.Class("find balance at")
+	ErrCreateBalanceSnapshots = errs.Class("create balance snapshots")
+)
+
+const (
+	// Both queries start from the latest snapshot and add the entries created
+	// after it. Without a snapshot they sum all the entries of the wallet.
+	findBalanceAtQuery = `
+	select coalesce(s.balance, 0) + coalesce((
+		select sum(e.amount) from ledger_entries e
+		where e.wallet = w.id and e.created_at > coalesce(s.at, '-infinity') and e.created_at <= $2
+	), 0)
+	from wallets w
+	left join lateral (
+		select at, balance from balance_snapshots
+		where wallet = w.id and at <= $2
+		order by at desc limit 1
+	) s on true
+	where w.id = $1`
+	createBalanceSnapshotsQuery = `
+	insert into balance_snapshots (wallet, at, balance)
+	select w.id, $1, coalesce(s.balance, 0) + coalesce((
+		select sum(e.amount) from ledger_entries e
+		where e.wallet = w.id and e.created_at > coalesce(s.at, '-infinity') and e.created_at <= $1
+	), 0)
+	from wallets w
+	left join lateral (
+		select at, balance from balance_snapshots
+		where wallet = w.id and at <= $1
+		order by at desc limit 1
+	) s on true
+	where exists (select 1 from ledger_entries e where e.wallet = w.id and e.created_at <= $1)
+	on conflict (wallet, at) do nothing`
+)
+
+// FindBalanceAt returns the balance of the wallet at the given time, that is
+// the sum of its ledger entries created at or before it. It returns nil if
+// the wallet does not exist.
+func FindBalanceAt(ctx context.Context, q ContextRowQuerier, wallet WalletID, at time.Time) (*Decimal, error) {
+	var balance Decimal
+
+	err := q.QueryRowContext(ctx, findBalanceAtQuery, wallet, at).Scan(&balance)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrFindBalanceAt.Wrap(err)
+	}
+
+	return &balance, nil
+}
+
+// CreateBalanceSnapshots snapshots the balance of every wallet with ledger
+// entries at the given time. Existing snapshots are kept, so it is safe to
+// run it again for the same time. It returns the number of snapshots created.
+func CreateBalanceSnapshots(ctx context.Context, q ContextExecutor, at time.Time) (int64, error) {
+	res, err := q.ExecContext(ctx, createBalanceSnapshotsQuery, at)
+	if err != nil {
+		return 0, ErrCreateBalanceSnapshots.Wrap(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, ErrCreateBalanceSnapshots.Wrap(err)
+	}
+
+	return n, nil
+}
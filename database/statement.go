@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanStatementEntry    = errs.Class("scan statement entry")
+	ErrForEachStatementEntry = errs.Class("for each statement entry")
+)
+
+// StatementEntry is a ledger entry of a wallet described for a statement.
+type StatementEntry interface {
+	ID() LedgerEntryID
+	CreatedAt() time.Time
+	// Type is the direction of a transfer or the kind of the journal.
+	Type() string
+	// Transfer and Counterparty are nil unless the entry belongs to a transfer.
+	Transfer() *TransferID
+	Counterparty() *WalletID
+	// Reference is the external reference, the funding reason or the memo.
+	Reference() string
+	// Amount excludes the fee, the entry changes the balance by Amount - Fee.
+	Amount() Decimal
+	Fee() Decimal
+}
+
+type statementEntryImpl struct {
+	id           LedgerEntryID
+	createdAt    time.Time
+	typ          string
+	transfer     *TransferID
+	counterparty *WalletID
+	reference    string
+	amount       Decimal
+	fee          Decimal
+}
+
+func (e *statementEntryImpl) ID() LedgerEntryID {
+	return e.id
+}
+
+func (e *statementEntryImpl) CreatedAt() time.Time {
+	return e.createdAt
+}
+
+func (e *statementEntryImpl) Type() string {
+	return e.typ
+}
+
+func (e *statementEntryImpl) Transfer() *TransferID {
+	return e.transfer
+}
+
+func (e *statementEntryImpl) Counterparty() *WalletID {
+	return e.counterparty
+}
+
+func (e *statementEntryImpl) Reference() string {
+	return e.reference
+}
+
+func (e *statementEntryImpl) Amount() Decimal {
+	return e.amount
+}
+
+func (e *statementEntryImpl) Fee() Decimal {
+	return e.fee
+}
+
+// statementEntriesQuery lists the entries of the wallet created in (from, to]
+// in the order they are summed in.
+const statementEntriesQuery = `
+	select e.id, e.created_at, d.type, t.id,
+		case when d.type = 'out' then t.receiver when t.id is not null then t.sender end,
+		coalesce(x.reference, f.reason, j.memo, ''),
+		case d.type when 'out' then -t.amount when 'in' then coalesce(t.destination_amount, t.amount) else e.amount end,
+		case d.type when 'out' then t.fee_amount when 'in' then -t.fee_refund else 0 end
+	from ledger_entries e
+	join journals j on j.id = e.journal
+	left join transactions t on t.id = j.transfer
+	left join fundings f on f.id = j.funding
+	left join external_transactions x on x.id = j.external_transaction
+	cross join lateral (
+		select case when t.id is not null then ` + entryDirection + ` else j.kind end as type
+	) d
+	where e.wallet = $1 and e.created_at > $2 and e.created_at <= $3
+	order by e.created_at, e.id`
+
+func scanStatementEntry(s Scanner) (StatementEntry, error) {
+	var e statementEntryImpl
+	var transfer, counterparty sql.NullInt64
+
+	err := s.Scan(&e.id, &e.createdAt, &e.typ, &transfer, &counterparty, &e.reference, &e.amount, &e.fee)
+	if err != nil {
+		return nil, ErrScanStatementEntry.Wrap(err)
+	}
+
+	e.transfer = nullID(transfer)
+	e.counterparty = nullID(counterparty)
+
+	return &e, nil
+}
+
+// ForEachStatementEntry calls fn for the entries of the wallet created in
+// (from, to] as they are read from the database, without loading them all.
+func ForEachStatementEntry(
+	ctx context.Context,
+	q ContextQuerier,
+	wallet WalletID,
+	from, to time.Time,
+	fn func(StatementEntry) error,
+) error {
+	rows, err := q.QueryContext(ctx, statementEntriesQuery, wallet, from, to)
+	if err != nil {
+		return ErrForEachStatementEntry.Wrap(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanStatementEntry(rows)
+		if err != nil {
+			return ErrForEachStatementEntry.Wrap(err)
+		}
+
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return ErrForEachStatementEntry.Wrap(err)
+	}
+
+	return nil
+}
@@ -3,16 +3,23 @@ package database
 import (
 	"context"
 	"database/sql"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zeebo/errs"
 )
 
 var (
-	ErrScanTransfer     = errs.Class("scan transfer")
-	ErrCreateTransfer   = errs.Class("create transfer")
-	ErrFindAllTransfers = errs.Class("find all transfers")
-	ErrFindTransferByID = errs.Class("find transfer by id")
+	ErrScanTransfer        = errs.Class("scan transfer")
+	ErrCreateTransfer      = errs.Class("create transfer")
+	ErrFindAllTransfers    = errs.Class("find all transfers")
+	ErrFindTransfers       = errs.Class("find transfers")
+	ErrScanWalletTransfer  = errs.Class("scan wallet transfer")
+	ErrFindWalletTransfers = errs.Class("find wallet transfers")
+	ErrFindTransferByID    = errs.Class("find transfer by id")
+	ErrSumFeeRevenue       = errs.Class("sum fee revenue")
+	ErrFindReversals       = errs.Class("find reversals")
 )
 
 type TransferID = ID
@@ -23,16 +30,41 @@ type Transfer interface {
 	To() WalletID
 	Amount() Decimal
 	FeeAmount() Decimal
+	FeeWallet() *WalletID
+	FeePolicy() string
+	// ReversalOf is the transfer this one reverses, nil for regular transfers.
+	ReversalOf() *TransferID
+	// FeeRefund is the fee of the reversed transfer returned from the fee
+	// wallet to the receiver, zero for regular transfers.
+	FeeRefund() Decimal
+	// DestinationAmount is what the receiver got, in its currency. It equals
+	// Amount unless the transfer converted the currencies.
+	DestinationAmount() Decimal
+	// ExchangeRate is nil unless the transfer converted the currencies.
+	ExchangeRate() *Decimal
+	ExchangeRateID() string
+	// FXSpread is the part of the converted amount kept by SpreadWallet.
+	FXSpread() Decimal
+	SpreadWallet() *WalletID
 	CreatedAt() time.Time
 }
 
 type transactionImpl struct {
-	id        TransferID
-	from      WalletID
-	to        WalletID
-	amount    Decimal
-	feeAmount Decimal
-	createdAt time.Time
+	id           TransferID
+	from         WalletID
+	to           WalletID
+	amount       Decimal
+	feeAmount    Decimal
+	feeWallet    *WalletID
+	feePolicy    string
+	reversalOf   *TransferID
+	feeRefund    Decimal
+	destAmount   Decimal
+	rate         *Decimal
+	rateID       string
+	fxSpread     Decimal
+	spreadWallet *WalletID
+	createdAt    time.Time
 }
 
 func (t *transactionImpl) ID() TransferID {
@@ -55,23 +87,115 @@ func (t *transactionImpl) FeeAmount() Decimal {
 	return t.feeAmount
 }
 
+func (t *transactionImpl) FeeWallet() *WalletID {
+	return t.feeWallet
+}
+
+func (t *transactionImpl) FeePolicy() string {
+	return t.feePolicy
+}
+
+func (t *transactionImpl) ReversalOf() *TransferID {
+	return t.reversalOf
+}
+
+func (t *transactionImpl) FeeRefund() Decimal {
+	return t.feeRefund
+}
+
+func (t *transactionImpl) DestinationAmount() Decimal {
+	return t.destAmount
+}
+
+func (t *transactionImpl) ExchangeRate() *Decimal {
+	return t.rate
+}
+
+func (t *transactionImpl) ExchangeRateID() string {
+	return t.rateID
+}
+
+func (t *transactionImpl) FXSpread() Decimal {
+	return t.fxSpread
+}
+
+func (t *transactionImpl) SpreadWallet() *WalletID {
+	return t.spreadWallet
+}
+
 func (t *transactionImpl) CreatedAt() time.Time {
 	return t.createdAt
 }
 
 const (
+	// transferColumns are the columns scanTransfer expects, t is transactions.
+	transferColumns = `t.id, t.sender, t.receiver, t.amount, t.fee_amount, t.fee_wallet, coalesce(t.fee_policy, ''), ` +
+		`t.reversal_of, t.fee_refund, coalesce(t.destination_amount, t.amount), t.exchange_rate, ` +
+		`coalesce(t.exchange_rate_id, ''), t.fx_spread, t.spread_wallet, t.created_at`
+
 	createTransactionQuery = `
-	insert into transactions (sender, receiver, amount, fee_amount) values ($1, $2, $3, $4)
-	returning id, sender, receiver, amount, fee_amount, created_at
-	`
-	findAllTransfersQuery = `select id, sender, receiver, amount, fee_amount, created_at from transactions`
-	findTransferByIDQuery = `select id, sender, receiver, amount, fee_amount, created_at from transactions where id = $1`
+	insert into transactions as t (
+		sender, receiver, amount, fee_amount, fee_wallet, fee_policy, reversal_of, fee_refund, batch,
+		destination_amount, exchange_rate, exchange_rate_id, fx_spread, spread_wallet
+	)
+	values ($1, $2, $3, $4, $5, nullif($6, ''), $7, $8, $9, $10, $11, nullif($12, ''), $13, $14)
+	returning ` + transferColumns
+	findAllTransfersQuery = `select ` + transferColumns + ` from transactions t`
+	findTransferByIDQuery = `select ` + transferColumns + ` from transactions t where t.id = $1`
+	findReversalsQuery    = `
+	select ` + transferColumns + ` from transactions t where t.reversal_of = $1 order by t.created_at, t.id`
+	// Fee refunds of reversals are subtracted from the revenue of the period
+	// they happen in. Fees are in the currency of the sender, FX spreads in
+	// the currency of the receiver.
+	sumFeeRevenueQuery = `
+	select currency, count(*) filter (where counted), sum(fee), sum(spread)
+	from (
+		select w.currency, t.reversal_of is null as counted, t.fee_amount - t.fee_refund as fee, 0 as spread
+		from transactions t
+		join wallets w on w.id = t.sender
+		where t.fee_wallet is not null and t.created_at >= $1 and t.created_at < $2
+		union all
+		select w.currency, false, 0, t.fx_spread
+		from transactions t
+		join wallets w on w.id = t.receiver
+		where t.spread_wallet is not null and t.created_at >= $1 and t.created_at < $2
+	) r
+	group by currency
+	order by currency`
 )
 
+// transferScan holds the nullable columns of transferColumns while they are scanned.
+type transferScan struct {
+	feeWallet    sql.NullInt64
+	reversalOf   sql.NullInt64
+	rate         sql.NullString
+	spreadWallet sql.NullInt64
+}
+
+// dest returns the scan destinations of transferColumns.
+func (ts *transferScan) dest(t *transactionImpl) []interface{} {
+	return []interface{}{
+		&t.id, &t.from, &t.to, &t.amount, &t.feeAmount, &ts.feeWallet, &t.feePolicy,
+		&ts.reversalOf, &t.feeRefund, &t.destAmount, &ts.rate,
+		&t.rateID, &t.fxSpread, &ts.spreadWallet, &t.createdAt,
+	}
+}
+
+func (ts *transferScan) apply(t *transactionImpl) {
+	t.feeWallet = nullID(ts.feeWallet)
+	t.reversalOf = nullID(ts.reversalOf)
+	t.spreadWallet = nullID(ts.spreadWallet)
+	if ts.rate.Valid {
+		rate := Decimal(ts.rate.String)
+		t.rate = &rate
+	}
+}
+
 func scanTransfer(s Scanner) (Transfer, error) {
 	var t transactionImpl
+	var ts transferScan
 
-	err := s.Scan(&t.id, &t.from, &t.to, &t.amount, &t.feeAmount, &t.createdAt)
+	err := s.Scan(ts.dest(&t)...)
 	if err != nil {
 		if errs.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -80,11 +204,52 @@ func scanTransfer(s Scanner) (Transfer, error) {
 		return nil, ErrScanTransfer.Wrap(err)
 	}
 
+	ts.apply(&t)
+
 	return &t, nil
 }
 
-func CreateTransaction(ctx context.Context, q ContextRowQuerier, from, to WalletID, amount, feeAmount Decimal) (Transfer, error) {
-	t, err := scanTransfer(q.QueryRowContext(ctx, createTransactionQuery, from, to, amount, feeAmount))
+type CreateTransactionParams struct {
+	From      WalletID
+	To        WalletID
+	Amount    Decimal
+	FeeAmount Decimal
+	FeeWallet *WalletID
+	FeePolicy string
+	// ReversalOf and FeeRefund are only set for reversals.
+	ReversalOf *TransferID
+	FeeRefund  Decimal
+	// Batch is set for the transfers of a batch.
+	Batch *BatchID
+	// The conversion fields are only set for transfers between currencies.
+	DestinationAmount *Decimal
+	ExchangeRate      *Decimal
+	ExchangeRateID    string
+	FXSpread          Decimal
+	SpreadWallet      *WalletID
+}
+
+func CreateTransaction(ctx context.Context, q ContextRowQuerier, params *CreateTransactionParams) (Transfer, error) {
+	row := q.QueryRowContext(
+		ctx,
+		createTransactionQuery,
+		params.From,
+		params.To,
+		params.Amount,
+		params.FeeAmount,
+		params.FeeWallet,
+		params.FeePolicy,
+		params.ReversalOf,
+		params.FeeRefund,
+		params.Batch,
+		params.DestinationAmount,
+		params.ExchangeRate,
+		params.ExchangeRateID,
+		params.FXSpread,
+		params.SpreadWallet,
+	)
+
+	t, err := scanTransfer(row)
 	if err != nil {
 		return nil, ErrCreateTransfer.Wrap(err)
 	}
@@ -116,6 +281,123 @@ func FindAllTransfers(ctx context.Context, q ContextQuerier) ([]Transfer, error)
 	return transfers, nil
 }
 
+// TransferCursor points at the last transfer of a page.
+type TransferCursor struct {
+	CreatedAt time.Time
+	ID        TransferID
+}
+
+// TransferFilter selects transfers, nil fields are not checked. Transfers
+// are ordered from the newest to the oldest.
+type TransferFilter struct {
+	Sender   *WalletID
+	Receiver *WalletID
+	// Wallet matches transfers where the wallet is either the sender or the receiver.
+	Wallet    *WalletID
+	MinAmount *Decimal
+	MaxAmount *Decimal
+	Currency  *CurrencyCode
+	// CreatedFrom is inclusive, CreatedTo is exclusive.
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// After selects the transfers following the cursor.
+	After *TransferCursor
+	// Limit is not applied if it is zero.
+	Limit int
+}
+
+// whereBuilder collects the conditions of a where clause with their arguments.
+type whereBuilder struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg adds an argument and returns its placeholder.
+func (b *whereBuilder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+func (b *whereBuilder) where(cond string) {
+	b.conds = append(b.conds, cond)
+}
+
+func (b *whereBuilder) String() string {
+	if len(b.conds) == 0 {
+		return ""
+	}
+
+	return " where " + strings.Join(b.conds, " and ")
+}
+
+func (f *TransferFilter) query() (string, []interface{}) {
+	var b whereBuilder
+
+	from := " from transactions t"
+	if f.Currency != nil {
+		from += " join wallets s on s.id = t.sender"
+		b.where("s.currency = " + b.arg(*f.Currency))
+	}
+
+	if f.Sender != nil {
+		b.where("t.sender = " + b.arg(*f.Sender))
+	}
+	if f.Receiver != nil {
+		b.where("t.receiver = " + b.arg(*f.Receiver))
+	}
+	if f.Wallet != nil {
+		p := b.arg(*f.Wallet)
+		b.where("(t.sender = " + p + " or t.receiver = " + p + ")")
+	}
+	if f.MinAmount != nil {
+		b.where("t.amount >= " + b.arg(*f.MinAmount))
+	}
+	if f.MaxAmount != nil {
+		b.where("t.amount <= " + b.arg(*f.MaxAmount))
+	}
+	if f.CreatedFrom != nil {
+		b.where("t.created_at >= " + b.arg(*f.CreatedFrom))
+	}
+	if f.CreatedTo != nil {
+		b.where("t.created_at < " + b.arg(*f.CreatedTo))
+	}
+	if f.After != nil {
+		b.where("(t.created_at, t.id) < (" + b.arg(f.After.CreatedAt) + ", " + b.arg(f.After.ID) + ")")
+	}
+
+	query := "select " + transferColumns + from + b.String() + " order by t.created_at desc, t.id desc"
+	if f.Limit > 0 {
+		query += " limit " + b.arg(f.Limit)
+	}
+
+	return query, b.args
+}
+
+func FindTransfers(ctx context.Context, q ContextQuerier, filter *TransferFilter) ([]Transfer, error) {
+	query, args := filter.query()
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, ErrFindTransfers.Wrap(err)
+	}
+	defer rows.Close()
+
+	var transfers []Transfer
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, ErrFindTransfers.Wrap(err)
+		}
+
+		transfers = append(transfers, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindTransfers.Wrap(err)
+	}
+
+	return transfers, nil
+}
+
 func FindTransferByID(ctx context.Context, q ContextRowQuerier, id TransferID) (Transfer, error) {
 	t, err := scanTransfer(q.QueryRowContext(ctx, findTransferByIDQuery, id))
 	if err != nil {
@@ -124,3 +406,250 @@ func FindTransferByID(ctx context.Context, q ContextRowQuerier, id TransferID) (
 
 	return t, nil
 }
+
+// Directions of a transfer relative to a wallet.
+const (
+	DirectionOut = "out"
+	DirectionIn  = "in"
+	// DirectionFee is a fee collected by the wallet.
+	DirectionFee = "fee"
+)
+
+// WalletTransfer is a transfer as seen by one of the wallets involved.
+type WalletTransfer interface {
+	Transfer
+	// Entry is the ledger entry of the wallet.
+	Entry() LedgerEntryID
+	Direction() string
+	// SignedAmount is negative for outgoing transfers and excludes the fee.
+	SignedAmount() Decimal
+	// FeePaid is the fee paid by the wallet, zero unless the transfer is
+	// outgoing or a reversal refunding the fee, which is negative.
+	FeePaid() Decimal
+	// BalanceAfter is the balance of the wallet right after the transfer.
+	BalanceAfter() Decimal
+}
+
+type walletTransferImpl struct {
+	transactionImpl
+	entry        LedgerEntryID
+	direction    string
+	signedAmount Decimal
+	feePaid      Decimal
+	balanceAfter Decimal
+}
+
+func (t *walletTransferImpl) Entry() LedgerEntryID {
+	return t.entry
+}
+
+func (t *walletTransferImpl) Direction() string {
+	return t.direction
+}
+
+func (t *walletTransferImpl) SignedAmount() Decimal {
+	return t.signedAmount
+}
+
+func (t *walletTransferImpl) FeePaid() Decimal {
+	return t.feePaid
+}
+
+func (t *walletTransferImpl) BalanceAfter() Decimal {
+	return t.balanceAfter
+}
+
+// walletTransfersQuery lists the ledger entries of the wallet posted by
+// transfers and reversals along with the transfers. The postings of a
+// transfer journal are written in the order sender, receiver, fee wallet, so
+// the first credit of the receiver is the transferred amount and any other
+// credit is the fee. The credit of the receiver of a reversal includes the
+// fee refunded from the fee wallet, it is reported as a negative fee.
+const walletTransfersQuery = `
+	select ` + transferColumns + `, e.id,
+		d.direction,
+		case d.direction when 'out' then -t.amount when 'in' then coalesce(t.destination_amount, t.amount) else e.amount end,
+		case d.direction when 'out' then t.fee_amount when 'in' then -t.fee_refund else 0 end,
+		e.balance_after
+	from ledger_entries e
+	join journals j on j.id = e.journal and j.kind in ('transfer', 'reversal')
+	join transactions t on t.id = j.transfer
+	cross join lateral (select ` + entryDirection + ` as direction) d`
+
+// entryDirection is the direction of the transfer t for the wallet of its
+// ledger entry e.
+const entryDirection = `
+	case
+		when e.amount < 0 and e.wallet = t.sender then 'out'
+		when e.wallet = t.receiver and not exists (
+			select 1 from ledger_entries p
+			where p.journal = e.journal and p.wallet = e.wallet and p.amount > 0 and p.id < e.id
+		) then 'in'
+		else 'fee'
+	end`
+
+// WalletTransferCursor points at the last entry of a page.
+type WalletTransferCursor struct {
+	CreatedAt time.Time
+	ID        TransferID
+	Entry     LedgerEntryID
+}
+
+// WalletTransferFilter selects the entries of the wallet, nil fields are not
+// checked. Entries are ordered from the newest to the oldest.
+type WalletTransferFilter struct {
+	Wallet WalletID
+	// CreatedFrom is inclusive, CreatedTo is exclusive.
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	After       *WalletTransferCursor
+	// Limit is not applied if it is zero.
+	Limit int
+}
+
+func (f *WalletTransferFilter) query() (string, []interface{}) {
+	var b whereBuilder
+
+	b.where("e.wallet = " + b.arg(f.Wallet))
+
+	if f.CreatedFrom != nil {
+		b.where("t.created_at >= " + b.arg(*f.CreatedFrom))
+	}
+	if f.CreatedTo != nil {
+		b.where("t.created_at < " + b.arg(*f.CreatedTo))
+	}
+	if f.After != nil {
+		b.where("(t.created_at, t.id, e.id) < (" +
+			b.arg(f.After.CreatedAt) + ", " + b.arg(f.After.ID) + ", " + b.arg(f.After.Entry) + ")")
+	}
+
+	query := walletTransfersQuery + b.String() + " order by t.created_at desc, t.id desc, e.id desc"
+	if f.Limit > 0 {
+		query += " limit " + b.arg(f.Limit)
+	}
+
+	return query, b.args
+}
+
+func scanWalletTransfer(s Scanner) (WalletTransfer, error) {
+	var t walletTransferImpl
+	var ts transferScan
+
+	dest := append(ts.dest(&t.transactionImpl), &t.entry, &t.direction, &t.signedAmount, &t.feePaid, &t.balanceAfter)
+	err := s.Scan(dest...)
+	if err != nil {
+		return nil, ErrScanWalletTransfer.Wrap(err)
+	}
+
+	ts.apply(&t.transactionImpl)
+
+	return &t, nil
+}
+
+func FindWalletTransfers(ctx context.Context, q ContextQuerier, filter *WalletTransferFilter) ([]WalletTransfer, error) {
+	query, args := filter.query()
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, ErrFindWalletTransfers.Wrap(err)
+	}
+	defer rows.Close()
+
+	var transfers []WalletTransfer
+	for rows.Next() {
+		t, err := scanWalletTransfer(rows)
+		if err != nil {
+			return nil, ErrFindWalletTransfers.Wrap(err)
+		}
+
+		transfers = append(transfers, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindWalletTransfers.Wrap(err)
+	}
+
+	return transfers, nil
+}
+
+type FeeRevenue interface {
+	Currency() CurrencyCode
+	Count() int64
+	Total() Decimal
+	FXSpread() Decimal
+}
+
+type feeRevenueImpl struct {
+	currency CurrencyCode
+	count    int64
+	total    Decimal
+	fxSpread Decimal
+}
+
+func (f *feeRevenueImpl) FXSpread() Decimal {
+	return f.fxSpread
+}
+
+func (f *feeRevenueImpl) Currency() CurrencyCode {
+	return f.currency
+}
+
+func (f *feeRevenueImpl) Count() int64 {
+	return f.count
+}
+
+func (f *feeRevenueImpl) Total() Decimal {
+	return f.total
+}
+
+// SumFeeRevenue sums the fees collected into fee wallets and the FX spreads
+// collected into spread wallets in [from, to) per currency.
+func SumFeeRevenue(ctx context.Context, q ContextQuerier, from, to time.Time) ([]FeeRevenue, error) {
+	rows, err := q.QueryContext(ctx, sumFeeRevenueQuery, from, to)
+	if err != nil {
+		return nil, ErrSumFeeRevenue.Wrap(err)
+	}
+	defer rows.Close()
+
+	var rv []FeeRevenue
+	for rows.Next() {
+		var f feeRevenueImpl
+
+		err := rows.Scan(&f.currency, &f.count, &f.total, &f.fxSpread)
+		if err != nil {
+			return nil, ErrSumFeeRevenue.Wrap(err)
+		}
+
+		rv = append(rv, &f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrSumFeeRevenue.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// FindReversalsOf returns the reversals of the transfer from the oldest.
+func FindReversalsOf(ctx context.Context, q ContextQuerier, id TransferID) ([]Transfer, error) {
+	rows, err := q.QueryContext(ctx, findReversalsQuery, id)
+	if err != nil {
+		return nil, ErrFindReversals.Wrap(err)
+	}
+	defer rows.Close()
+
+	var rv []Transfer
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, ErrFindReversals.Wrap(err)
+		}
+
+		rv = append(rv, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindReversals.Wrap(err)
+	}
+
+	return rv, nil
+}
@@ -14,25 +14,50 @@ var (
 	ErrFindWalletByID       = errs.Class("find wallet")
 	ErrFindAllWallets       = errs.Class("find all wallets")
 	ErrFindManyWalletsByIDs = errs.Class("find many wallets")
+	ErrLockManyWalletsByIDs = errs.Class("lock many wallets")
 	ErrAddFunds             = errs.Class("add funds")
 	ErrRemoveFunds          = errs.Class("remove funds")
+	ErrHoldFunds            = errs.Class("hold funds")
+	ErrReleaseFunds         = errs.Class("release funds")
+	ErrSetWalletStatus      = errs.Class("set wallet status")
 )
 
 type (
-	WalletID = ID
-	Currency = string
+	WalletID     = ID
+	CurrencyCode = string
+)
+
+// Statuses of wallets.
+const (
+	WalletActive = "active"
+	WalletFrozen = "frozen"
+	WalletClosed = "closed"
 )
 
 type Wallet interface {
 	ID() WalletID
 	Balance() Decimal
-	Currency() Currency
+	// Held is the part of the balance reserved by pending holds.
+	Held() Decimal
+	// OverdraftLimit is how far below zero the balance can go.
+	OverdraftLimit() Decimal
+	Currency() CurrencyCode
+	// Metadata is a JSON object.
+	Metadata() []byte
+	// Owner is nil if the wallet has no owner.
+	Owner() *OwnerID
+	Status() string
 }
 
 type walletImpl struct {
-	id       WalletID
-	balance  Decimal
-	currency Currency
+	id             WalletID
+	balance        Decimal
+	held           Decimal
+	overdraftLimit Decimal
+	currency       CurrencyCode
+	metadata       []byte
+	owner          *OwnerID
+	status         string
 }
 
 func (w *walletImpl) ID() WalletID {
@@ -43,29 +68,63 @@ func (w *walletImpl) Balance() Decimal {
 	return w.balance
 }
 
-func (w *walletImpl) Currency() Currency {
+func (w *walletImpl) Held() Decimal {
+	return w.held
+}
+
+func (w *walletImpl) OverdraftLimit() Decimal {
+	return w.overdraftLimit
+}
+
+func (w *walletImpl) Currency() CurrencyCode {
 	return w.currency
 }
 
+func (w *walletImpl) Metadata() []byte {
+	return w.metadata
+}
+
+func (w *walletImpl) Owner() *OwnerID {
+	return w.owner
+}
+
+func (w *walletImpl) Status() string {
+	return w.status
+}
+
 const (
+	// walletColumns are the columns scanWallet expects.
+	walletColumns = `id, balance, held, overdraft_limit, currency, metadata, owner_id, status`
+
 	createWalletQuery = `
-	insert into wallets (balance, currency) values ($1, $2)
-	returning id, balance, currency`
-	findAllWalletsQuery      = `select id, balance, currency from wallets`
-	findWalletByIDQuery      = `select id, balance, currency from wallets where id = $1`
-	findManyWalletsByIDQuery = `select id, balance, currency from wallets where id = any($1)`
+	insert into wallets (balance, currency, metadata, owner_id) values (0, $1, $2, $3)
+	returning ` + walletColumns
+	findAllWalletsQuery      = `select ` + walletColumns + ` from wallets`
+	findWalletByIDQuery      = `select ` + walletColumns + ` from wallets where id = $1`
+	findManyWalletsByIDQuery = `select ` + walletColumns + ` from wallets where id = any($1)`
+	lockManyWalletsByIDQuery = `select ` + walletColumns + ` from wallets where id = any($1) order by id for update`
 	incByAmountToWalletQuery = `
 	update wallets set balance = balance + $1 where id = $2
-	returning id, balance, currency`
+	returning ` + walletColumns
 	decByAmountToWalletQuery = `
-	update wallets set balance = balance - $1 where id = $2
-	returning id, balance, currency`
+	update wallets set balance = balance - $1 where id = $2 and balance - held + overdraft_limit >= $1
+	returning ` + walletColumns
+	incHeldQuery = `
+	update wallets set held = held + $1 where id = $2 and balance - held + overdraft_limit >= $1
+	returning ` + walletColumns
+	decHeldQuery = `
+	update wallets set held = held - $1 where id = $2 and held >= $1
+	returning ` + walletColumns
+	setWalletStatusQuery = `
+	update wallets set status = $2 where id = $1
+	returning ` + walletColumns
 )
 
 func scanWallet(s Scanner) (Wallet, error) {
 	var w walletImpl
+	var owner sql.NullInt64
 
-	err := s.Scan(&w.id, &w.balance, &w.currency)
+	err := s.Scan(&w.id, &w.balance, &w.held, &w.overdraftLimit, &w.currency, &w.metadata, &owner, &w.status)
 	if err != nil {
 		if errs.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -74,11 +133,15 @@ func scanWallet(s Scanner) (Wallet, error) {
 		return nil, ErrWalletScan.Wrap(err)
 	}
 
+	w.owner = nullID(owner)
+
 	return &w, nil
 }
 
-func CreateWallet(ctx context.Context, q ContextRowQuerier, balance Decimal, currency Currency) (Wallet, error) {
-	w, err := scanWallet(q.QueryRowContext(ctx, createWalletQuery, balance, currency))
+// CreateWallet creates an empty wallet. metadata must be a JSON object,
+// owner may be nil.
+func CreateWallet(ctx context.Context, q ContextRowQuerier, currency CurrencyCode, metadata []byte, owner *OwnerID) (Wallet, error) {
+	w, err := scanWallet(q.QueryRowContext(ctx, createWalletQuery, currency, string(metadata), owner))
 	if err != nil {
 		return nil, ErrCreateWallet.Wrap(err)
 	}
@@ -111,25 +174,45 @@ func FindAllWallets(ctx context.Context, q ContextQuerier) ([]Wallet, error) {
 }
 
 func FindManyWalletsByIDs(ctx context.Context, q ContextQuerier, ids []WalletID) ([]Wallet, error) {
-	arr := pg.Array(ids)
-	rows, err := q.QueryContext(ctx, findManyWalletsByIDQuery, arr)
+	wallets, err := queryManyWalletsByIDs(ctx, q, findManyWalletsByIDQuery, ids)
 	if err != nil {
 		return nil, ErrFindManyWalletsByIDs.Wrap(err)
 	}
+
+	return wallets, nil
+}
+
+// LockManyWalletsByIDs selects the wallets with `for update`, so it must be
+// called within a transaction. Rows are locked in ascending id order, which
+// keeps concurrent callers from deadlocking on each other.
+func LockManyWalletsByIDs(ctx context.Context, q ContextQuerier, ids []WalletID) ([]Wallet, error) {
+	wallets, err := queryManyWalletsByIDs(ctx, q, lockManyWalletsByIDQuery, ids)
+	if err != nil {
+		return nil, ErrLockManyWalletsByIDs.Wrap(err)
+	}
+
+	return wallets, nil
+}
+
+func queryManyWalletsByIDs(ctx context.Context, q ContextQuerier, query string, ids []WalletID) ([]Wallet, error) {
+	rows, err := q.QueryContext(ctx, query, pg.Array(ids))
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	var wallets []Wallet
 	for rows.Next() {
 		w, err := scanWallet(rows)
 		if err != nil {
-			return nil, ErrFindManyWalletsByIDs.Wrap(err)
+			return nil, err
 		}
 
 		wallets = append(wallets, w)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, ErrFindManyWalletsByIDs.Wrap(err)
+		return nil, err
 	}
 
 	return wallets, nil
@@ -153,6 +236,8 @@ func AddFunds(ctx context.Context, q ContextRowQuerier, walletId WalletID, amoun
 	return w, nil
 }
 
+// RemoveFunds returns nil wallet if the wallet does not exist or its
+// available balance is less than amount.
 func RemoveFunds(ctx context.Context, q ContextRowQuerier, walletId WalletID, amount Decimal) (Wallet, error) {
 	w, err := scanWallet(q.QueryRowContext(ctx, decByAmountToWalletQuery, amount, walletId))
 	if err != nil {
@@ -161,3 +246,36 @@ func RemoveFunds(ctx context.Context, q ContextRowQuerier, walletId WalletID, am
 
 	return w, nil
 }
+
+// HoldFunds reserves amount of the available balance. It returns nil wallet
+// if the wallet does not exist or its available balance is less than amount.
+func HoldFunds(ctx context.Context, q ContextRowQuerier, walletId WalletID, amount Decimal) (Wallet, error) {
+	w, err := scanWallet(q.QueryRowContext(ctx, incHeldQuery, amount, walletId))
+	if err != nil {
+		return nil, ErrHoldFunds.Wrap(err)
+	}
+
+	return w, nil
+}
+
+// ReleaseFunds returns amount of the held funds to the available balance. It
+// returns nil wallet if the wallet does not exist or holds less than amount.
+func ReleaseFunds(ctx context.Context, q ContextRowQuerier, walletId WalletID, amount Decimal) (Wallet, error) {
+	w, err := scanWallet(q.QueryRowContext(ctx, decHeldQuery, amount, walletId))
+	if err != nil {
+		return nil, ErrReleaseFunds.Wrap(err)
+	}
+
+	return w, nil
+}
+
+// SetWalletStatus returns nil wallet if the wallet does not exist. The
+// transition is checked by the caller.
+func SetWalletStatus(ctx context.Context, q ContextRowQuerier, walletId WalletID, status string) (Wallet, error) {
+	w, err := scanWallet(q.QueryRowContext(ctx, setWalletStatusQuery, walletId, status))
+	if err != nil {
+		return nil, ErrSetWalletStatus.Wrap(err)
+	}
+
+	return w, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+)
+
+var (
+	ErrScanWalletStatusChange   = errs.Class("scan wallet status change")
+	ErrCreateWalletStatusChange = errs.Class("create wallet status change")
+	ErrFindWalletStatusChanges  = errs.Class("find wallet status changes")
+)
+
+type WalletStatusChangeID = ID
+
+// WalletStatusChange records a change of the status of a wallet.
+type WalletStatusChange interface {
+	ID() WalletStatusChangeID
+	Wallet() WalletID
+	From() string
+	To() string
+	Reason() string
+	CreatedAt() time.Time
+}
+
+type walletStatusChangeImpl struct {
+	id        WalletStatusChangeID
+	wallet    WalletID
+	from      string
+	to        string
+	reason    string
+	createdAt time.Time
+}
+
+func (c *walletStatusChangeImpl) ID() WalletStatusChangeID {
+	return c.id
+}
+
+func (c *walletStatusChangeImpl) Wallet() WalletID {
+	return c.wallet
+}
+
+func (c *walletStatusChangeImpl) From() string {
+	return c.from
+}
+
+func (c *walletStatusChangeImpl) To() string {
+	return c.to
+}
+
+func (c *walletStatusChangeImpl) Reason() string {
+	return c.reason
+}
+
+func (c *walletStatusChangeImpl) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+const (
+	walletStatusChangeColumns = `id, wallet, from_status, to_status, reason, created_at`
+
+	createWalletStatusChangeQuery = `
+	insert into wallet_status_changes (wallet, from_status, to_status, reason) values ($1, $2, $3, $4)
+	returning ` + walletStatusChangeColumns
+	findWalletStatusChangesQuery = `
+	select ` + walletStatusChangeColumns + ` from wallet_status_changes
+	where wallet = $1
+	order by created_at, id`
+)
+
+func scanWalletStatusChange(s Scanner) (WalletStatusChange, error) {
+	var c walletStatusChangeImpl
+
+	err := s.Scan(&c.id, &c.wallet, &c.from, &c.to, &c.reason, &c.createdAt)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, ErrScanWalletStatusChange.Wrap(err)
+	}
+
+	return &c, nil
+}
+
+type CreateWalletStatusChangeParams struct {
+	Wallet WalletID
+	From   string
+	To     string
+	Reason string
+}
+
+func CreateWalletStatusChange(ctx context.Context, q ContextRowQuerier, params *CreateWalletStatusChangeParams) (WalletStatusChange, error) {
+	row := q.QueryRowContext(ctx, createWalletStatusChangeQuery, params.Wallet, params.From, params.To, params.Reason)
+
+	c, err := scanWalletStatusChange(row)
+	if err != nil {
+		return nil, ErrCreateWalletStatusChange.Wrap(err)
+	}
+
+	return c, nil
+}
+
+// FindWalletStatusChanges returns the status changes of the wallet from the oldest.
+func FindWalletStatusChanges(ctx context.Context, q ContextQuerier, wallet WalletID) ([]WalletStatusChange, error) {
+	rows, err := q.QueryContext(ctx, findWalletStatusChangesQuery, wallet)
+	if err != nil {
+		return nil, ErrFindWalletStatusChanges.Wrap(err)
+	}
+	defer rows.Close()
+
+	var rv []WalletStatusChange
+	for rows.Next() {
+		c, err := scanWalletStatusChange(rows)
+		if err != nil {
+			return nil, ErrFindWalletStatusChanges.Wrap(err)
+		}
+
+		rv = append(rv, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, ErrFindWalletStatusChanges.Wrap(err)
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrTransferBatch = errs.Class("transfer batch")
+	ErrFindBatchByID = errs.Class("find batch by id")
+	ErrInvalidBatch  = errs.Class("invalid batch")
+)
+
+// MaxBatchLegs limits the number of transfers in a batch.
+const MaxBatchLegs = 1000
+
+type BatchID database.BatchID
+
+func (id BatchID) String() string {
+	return id.ToDB().String()
+}
+
+func (id BatchID) ToDB() database.BatchID {
+	return database.BatchID(id)
+}
+
+func ParseBatchID(s string) (BatchID, error) {
+	v, err := database.ParseID(s)
+	return BatchID(v), err
+}
+
+// Batch is a group of transfers applied all together or not at all.
+// Transfers are in the order of the legs they were made from.
+type Batch struct {
+	ID        BatchID
+	Transfers []*Transfer
+	CreatedAt time.Time
+}
+
+type BatchLeg struct {
+	From   WalletID
+	To     WalletID
+	Amount Decimal
+}
+
+type TransferBatchParams struct {
+	Legs []BatchLeg
+	// Fee is resolved for the currency of every leg with ResolveFeePolicy.
+	Fee FeePolicy
+	// Status decides whether frozen wallets can receive the funds.
+	Status WalletStatusRule
+}
+
+// TransferBatch makes a transfer for every leg within tx. The legs are
+// verified together before any funds move: a sender must be able to pay for
+// all of its legs and fees with its available balance, funds it receives in
+// the same batch do not count. If any leg fails, no transfer is made.
+// The caller is responsible for committing or rolling back tx.
+func TransferBatch(ctx context.Context, tx *sql.Tx, params *TransferBatchParams) (*Batch, error) {
+	if n := len(params.Legs); n == 0 || n > MaxBatchLegs {
+		return nil, ErrTransferBatch.Wrap(ErrInvalidBatch.New("number of legs must be in [1, %d]", MaxBatchLegs))
+	}
+
+	plans, err := planBatch(ctx, tx, params)
+	if err != nil {
+		return nil, ErrTransferBatch.Wrap(err)
+	}
+
+	b, err := database.CreateBatch(ctx, tx)
+	if err != nil {
+		return nil, ErrTransferBatch.Wrap(err)
+	}
+
+	batch := Batch{
+		ID:        BatchID(b.ID()),
+		Transfers: make([]*Transfer, len(plans)),
+		CreatedAt: b.CreatedAt(),
+	}
+
+	for i, plan := range plans {
+		plan.batch = &batch.ID
+
+		res, err := doTransfer(ctx, tx, plan)
+		if err != nil {
+			return nil, ErrTransferBatch.Wrap(err)
+		}
+
+		batch.Transfers[i] = res.Transfer()
+	}
+
+	return &batch, nil
+}
+
+// planBatch locks the wallets of every leg and verifies the legs.
+func planBatch(ctx context.Context, tx *sql.Tx, params *TransferBatchParams) ([]*transferPlan, error) {
+	senderIDs := make([]WalletID, len(params.Legs))
+	for i, leg := range params.Legs {
+		senderIDs[i] = leg.From
+	}
+
+	// as for a single transfer, the fee wallets are found from the currencies
+	// of the senders before the locks
+	senders, err := FindManyWalletsByIDs(ctx, tx, senderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]WalletID, 0, 3*len(params.Legs))
+	for i, leg := range params.Legs {
+		sender := senders[leg.From]
+		if sender == nil {
+			return nil, ErrInvalidBatch.New("leg %d: %v", i, ErrWalletDoesNotExist.New("%v", leg.From))
+		}
+
+		ids = append(ids, leg.From, leg.To)
+		if id, ok := sender.Currency.FeeWallet(); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	// All the wallets are locked by one statement in the order of their ids,
+	// the same order a single transfer uses, so neither batches nor
+	// transfers can deadlock with each other.
+	ws, err := LockManyWalletsByIDs(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// debits are checked against copies of the senders which are charged leg
+	// by leg, so a sender must afford the sum of its legs
+	charged := make(map[WalletID]*Wallet)
+	plans := make([]*transferPlan, len(params.Legs))
+	for i, leg := range params.Legs {
+		from, to := ws[leg.From], ws[leg.To]
+		if from == nil {
+			return nil, ErrInvalidBatch.New("leg %d: %v", i, ErrWalletDoesNotExist.New("%v", leg.From))
+		}
+		if to == nil {
+			return nil, ErrInvalidBatch.New("leg %d: %v", i, ErrWalletDoesNotExist.New("%v", leg.To))
+		}
+		if err := params.Status.checkTransfer(from, to); err != nil {
+			return nil, ErrInvalidBatch.New("leg %d: %v", i, err)
+		}
+
+		sender, ok := charged[from.ID]
+		if !ok {
+			c := *from
+			sender = &c
+			charged[from.ID] = sender
+		}
+
+		legWallets := transferWallets{from: sender, to: to}
+		if id, ok := from.Currency.FeeWallet(); ok {
+			legWallets.feeWallet = ws[id]
+		}
+
+		policy := ResolveFeePolicy(params.Fee, from.Currency)
+		feeAmount := calcFeeAmount(leg.Amount, policy, from.Currency)
+
+		plan, err := planTransfer(&legWallets, leg.Amount, feeAmount, policy, nil)
+		if err != nil {
+			if ErrFeeWalletNotConfigured.Has(err) {
+				return nil, ErrFeeWalletNotConfigured.New("leg %d: %v", i, err)
+			}
+
+			return nil, ErrInvalidBatch.New("leg %d: %v", i, err)
+		}
+
+		sender.Balance = sender.Balance.Sub(leg.Amount.Add(feeAmount))
+		plans[i] = plan
+	}
+
+	return plans, nil
+}
+
+// FindBatchByID returns the batch with its transfers.
+func FindBatchByID(ctx context.Context, q database.ContextQueryExecutor, id BatchID) (*Batch, error) {
+	b, err := database.FindBatchByID(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindBatchByID.Wrap(err)
+	}
+	if b == nil {
+		return nil, nil
+	}
+
+	ts, err := database.FindBatchTransfers(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindBatchByID.Wrap(err)
+	}
+
+	batch := Batch{
+		ID:        BatchID(b.ID()),
+		Transfers: make([]*Transfer, len(ts)),
+		CreatedAt: b.CreatedAt(),
+	}
+	for i, t := range ts {
+		batch.Transfers[i], err = NewTransferFromDB(t)
+		if err != nil {
+			return nil, ErrFindBatchByID.Wrap(err)
+		}
+	}
+
+	return &batch, nil
+}
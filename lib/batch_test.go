@@ -0,0 +1,78 @@
+package lib
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTransferBatchLegCount(t *testing.T) {
+	for _, n := range []int{0, MaxBatchLegs + 1} {
+		params := TransferBatchParams{Legs: make([]BatchLeg, n)}
+		// the number of legs is checked before the transaction is used
+		_, err := TransferBatch(context.Background(), nil, &params)
+		if !ErrInvalidBatch.Has(err) {
+			t.Errorf("%d legs: want ErrInvalidBatch, got %v", n, err)
+		}
+	}
+}
+
+func TestTransferBatchAggregate(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc := testCurrency(t, "BTC")
+	fee := NewFlatFee("test", Decimal{})
+
+	tests := []struct {
+		name string
+		// legs index the wallets a, b, c, which start with 10, 0 and 0
+		legs [][3]string
+		ok   bool
+	}{
+		{"sum of legs fits", [][3]string{{"a", "b", "4"}, {"a", "c", "6"}}, true},
+		{"sum of legs exceeds", [][3]string{{"a", "b", "6"}, {"a", "c", "6"}}, false},
+		{"received funds do not count", [][3]string{{"a", "b", "5"}, {"b", "c", "5"}}, false},
+	}
+
+	for _, tt := range tests {
+		ws := map[string]*Wallet{
+			"a": createTestWallet(t, db, btc, mustDecimal(t, "10")),
+			"b": createTestWallet(t, db, btc, Decimal{}),
+			"c": createTestWallet(t, db, btc, Decimal{}),
+		}
+
+		params := TransferBatchParams{Fee: fee}
+		for _, l := range tt.legs {
+			params.Legs = append(params.Legs, BatchLeg{From: ws[l[0]].ID, To: ws[l[1]].ID, Amount: mustDecimal(t, l[2])})
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		batch, err := TransferBatch(ctx, tx, &params)
+		if tt.ok {
+			if err != nil {
+				tx.Rollback()
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if len(batch.Transfers) != len(tt.legs) {
+				t.Errorf("%s: got %d transfers, want %d", tt.name, len(batch.Transfers), len(tt.legs))
+			}
+
+			continue
+		}
+
+		tx.Rollback()
+		if !ErrInvalidBatch.Has(err) {
+			t.Errorf("%s: want ErrInvalidBatch, got %v", tt.name, err)
+		}
+		if a := findTestWallet(t, db, ws["a"].ID); !a.Balance.Equal(mustDecimal(t, "10")) {
+			t.Errorf("%s: balance of the sender changed to %v", tt.name, a.Balance)
+		}
+	}
+}
@@ -1,120 +1,46 @@
 package lib
 
 import (
-	"math/big"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/zeebo/errs"
-
-	"github.com/defbin/walletdb/database"
 )
 
 var (
-	ErrUnsupportedCurrency  = errs.Class("unsupported currency")
 	ErrInvalidDecimalString = errs.Class("invalid decimal string")
+	ErrInvalidAmount        = errs.Class("invalid amount")
+	ErrInvalidCursor        = errs.Class("invalid cursor")
 )
 
-const (
-	BTC = "BTC"
-	ETH = "ETH"
-)
-
-type Currency struct {
-	code string
+// encodeCursor makes an opaque pagination cursor out of the sort key of
+// the last item of a page.
+func encodeCursor(parts ...string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(parts, ":")))
 }
 
-func NewCurrency(code string) (Currency, error) {
-	if code != BTC && code != ETH {
-		return Currency{}, ErrUnsupportedCurrency.New(code)
+// decodeCursor returns the n parts of the sort key encoded by encodeCursor.
+func decodeCursor(cursor string, n int) ([]string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor.New("%q", cursor)
 	}
 
-	return Currency{code}, nil
-}
-
-func (c Currency) Equals(o Currency) bool {
-	return c.code == o.code
-}
-
-func (c Currency) String() string {
-	return c.code
-}
-
-func (c Currency) ToDB() database.Currency {
-	return c.String()
-}
-
-type Decimal struct {
-	v big.Float
-}
-
-func NewDecimal(x float64) Decimal {
-	return Decimal{*big.NewFloat(x)}
-}
-
-func NewDecimalFromDB(value database.Decimal) (Decimal, error) {
-	return NewDecimalFromString(string(value))
-}
-
-func NewDecimalFromFloat(v float64) Decimal {
-	return Decimal{*big.NewFloat(v)}
-}
-
-func NewDecimalFromString(s string) (Decimal, error) {
-	var d Decimal
-	_, ok := d.v.SetString(s)
-	if !ok {
-		return d, ErrInvalidDecimalString.New(s)
+	parts := strings.SplitN(string(b), ":", n)
+	if len(parts) != n {
+		return nil, ErrInvalidCursor.New("%q", cursor)
 	}
 
-	return d, nil
+	return parts, nil
 }
 
-func (d Decimal) Copy() Decimal {
-	rv := Decimal{}
-	rv.v.Copy(&d.v)
-	return rv
-}
-
-func (d Decimal) ToDB() database.Decimal {
-	return database.Decimal(d.String())
-}
-
-func (d Decimal) String() string {
-	return d.v.String()
-}
-
-func (d Decimal) Add(m Decimal) Decimal {
-	rv := d.Copy()
-	rv.v.Add(&rv.v, &m.v)
-	return rv
-}
-
-func (d Decimal) Mul(m Decimal) Decimal {
-	rv := d.Copy()
-	rv.v.Mul(&rv.v, &m.v)
-	return rv
-}
-
-func (d Decimal) Div(m Decimal) Decimal {
-	rv := d.Copy()
-	rv.v.Quo(&rv.v, &m.v)
-	return rv
-}
-
-//
-// func (d Decimal) Mul(m Decimal) Decimal {
-// 	d1, o1 := d.v, m.v
-// 	return Decimal{*d1.Mul(&d1, &o1)}
-// }
-//
-// func (d Decimal) Div(m Decimal) Decimal {
-// 	d1, o1 := d.v, m.v
-// 	return Decimal{*d1.Quo(&d1, &o1)}
-// }
-
-func (d Decimal) Equal(o Decimal) bool {
-	return d.v.Cmp(&o.v) == 0
-}
+func parseCursorTime(s string) (time.Time, error) {
+	nsec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
 
-func (d Decimal) Less(o Decimal) bool {
-	return d.v.Cmp(&o.v) == -1
+	return time.Unix(0, nsec), nil
 }
@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferCursorRoundTrip(t *testing.T) {
+	tr := Transfer{ID: 123, CreatedAt: time.Unix(1600000000, 123456789)}
+
+	c, err := decodeTransferCursor(encodeTransferCursor(&tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.CreatedAt.Equal(tr.CreatedAt) || c.ID != tr.ID.ToDB() {
+		t.Errorf("decoded %v %v, want %v %v", c.CreatedAt, c.ID, tr.CreatedAt, tr.ID)
+	}
+}
+
+func TestDecodeTransferCursorInvalid(t *testing.T) {
+	for _, cursor := range []string{
+		"not base64!",
+		encodeCursor("1600000000"),
+		encodeCursor("time", "1"),
+		encodeCursor("1600000000", "id"),
+	} {
+		if _, err := decodeTransferCursor(cursor); !ErrInvalidCursor.Has(err) {
+			t.Errorf("decodeTransferCursor(%q) error = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestTransferFilterLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+		valid bool
+	}{
+		{0, DefaultTransferPageSize, true},
+		{1, 1, true},
+		{MaxTransferPageSize, MaxTransferPageSize, true},
+		{MaxTransferPageSize + 1, 0, false},
+		{-1, 0, false},
+	}
+
+	for _, tt := range tests {
+		f, err := (&TransferFilter{Limit: tt.limit}).toDB()
+		if !tt.valid {
+			if !ErrInvalidTransferFilter.Has(err) {
+				t.Errorf("limit %d: error = %v, want ErrInvalidTransferFilter", tt.limit, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("limit %d: %v", tt.limit, err)
+			continue
+		}
+		// one more row is read to know whether there is a next page
+		if f.Limit != tt.want+1 {
+			t.Errorf("limit %d: database limit %d, want %d", tt.limit, f.Limit, tt.want+1)
+		}
+	}
+}
+
+func TestWalletTransferCursorRoundTrip(t *testing.T) {
+	tr := WalletTransfer{
+		Transfer: &Transfer{ID: 7, CreatedAt: time.Unix(1600000000, 1)},
+		entry:    99,
+	}
+
+	c, err := decodeWalletTransferCursor(encodeWalletTransferCursor(&tr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.CreatedAt.Equal(tr.CreatedAt) || c.ID != tr.ID.ToDB() || c.Entry != tr.entry {
+		t.Errorf("decoded %v %v %v, want %v %v %v", c.CreatedAt, c.ID, c.Entry, tr.CreatedAt, tr.ID, tr.entry)
+	}
+
+	if _, err := decodeWalletTransferCursor(encodeTransferCursor(tr.Transfer)); !ErrInvalidCursor.Has(err) {
+		t.Errorf("a transfer cursor is accepted for wallet transfers: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"github.com/defbin/walletdb/database"
+)
+
+// constraintError returns the error the checks of this package return for
+// the constraint err violates, so a request the checks let through, e.g.
+// because of a race, fails the same way. Other errors are returned as is.
+func constraintError(err error) error {
+	switch database.ViolatedConstraint(err) {
+	case database.ConstraintWalletBalance, database.ConstraintWalletHeld:
+		return ErrInsufficientFunds
+	case database.ConstraintTransferAmount:
+		return ErrInvalidAmount.New("transfer amount must be positive")
+	case database.ConstraintTransferFeeAmount:
+		return ErrInvalidAmount.New("fee amount cannot be negative")
+	case database.ConstraintTransferSenderReceiver, database.ConstraintHoldSenderReceiver:
+		return ErrTransferToSameWallet
+	case database.ConstraintTransferSender, database.ConstraintTransferReceiver:
+		return ErrWalletDoesNotExist.New("transfer wallet")
+	default:
+		return err
+	}
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pg "github.com/lib/pq"
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+func TestConstraintError(t *testing.T) {
+	violation := func(code, constraint string) error {
+		return errs.Wrap(&pg.Error{Code: pg.ErrorCode(code), Constraint: constraint})
+	}
+	other := errors.New("other")
+
+	tests := []struct {
+		err  error
+		want func(error) bool
+	}{
+		{violation("23514", database.ConstraintWalletBalance), func(err error) bool { return err == ErrInsufficientFunds }},
+		{violation("23514", database.ConstraintWalletHeld), func(err error) bool { return err == ErrInsufficientFunds }},
+		{violation("23514", database.ConstraintTransferAmount), ErrInvalidAmount.Has},
+		{violation("23514", database.ConstraintTransferFeeAmount), ErrInvalidAmount.Has},
+		{violation("23514", database.ConstraintTransferSenderReceiver), func(err error) bool { return err == ErrTransferToSameWallet }},
+		{violation("23514", database.ConstraintHoldSenderReceiver), func(err error) bool { return err == ErrTransferToSameWallet }},
+		{violation("23503", database.ConstraintTransferSender), ErrWalletDoesNotExist.Has},
+		{violation("23503", database.ConstraintTransferReceiver), ErrWalletDoesNotExist.Has},
+		// not an integrity constraint violation
+		{violation("40001", database.ConstraintWalletBalance), func(err error) bool { return database.ViolatedConstraint(err) == "" }},
+		{violation("23514", "other_check"), func(err error) bool { return database.ViolatedConstraint(err) == "other_check" }},
+		{other, func(err error) bool { return err == other }},
+	}
+
+	for _, tt := range tests {
+		if got := constraintError(tt.err); !tt.want(got) {
+			t.Errorf("%v: unexpected %v", tt.err, got)
+		}
+	}
+}
+
+func TestWalletAvailable(t *testing.T) {
+	tests := []struct {
+		balance, held, overdraftLimit string
+		want                          string
+	}{
+		{"10", "0", "0", "10"},
+		{"10", "4", "0", "6"},
+		{"10", "4", "5", "11"},
+		{"-3", "0", "5", "2"},
+		{"-3", "2", "5", "0"},
+	}
+
+	for _, tt := range tests {
+		w := Wallet{
+			Balance:        mustDecimal(t, tt.balance),
+			Held:           mustDecimal(t, tt.held),
+			OverdraftLimit: mustDecimal(t, tt.overdraftLimit),
+		}
+		if got := w.Available(); !got.Equal(mustDecimal(t, tt.want)) {
+			t.Errorf("%+v: got %v", tt, got)
+		}
+	}
+}
+
+func TestTransferOverdraftLimit(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc := testCurrency(t, "BTC")
+	from := createTestWallet(t, db, btc, mustDecimal(t, "10"))
+	to := createTestWallet(t, db, btc, Decimal{})
+
+	_, err := db.ExecContext(ctx, `update wallets set overdraft_limit = 5 where id = $1`, int64(from.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := func(amount string) error {
+		_, err := transferInTx(ctx, db, &TransferFundsParams{
+			From:   from.ID,
+			To:     to.ID,
+			Amount: mustDecimal(t, amount),
+			Fee:    NewFlatFee("test", Decimal{}),
+		})
+		return err
+	}
+
+	if err := transfer("14"); err != nil {
+		t.Fatal(err)
+	}
+	if w := findTestWallet(t, db, from.ID); !w.Balance.Equal(mustDecimal(t, "-4")) {
+		t.Errorf("balance %v, want -4", w.Balance)
+	}
+	if err := transfer("2"); !errs.Is(err, ErrInsufficientFunds) {
+		t.Errorf("want ErrInsufficientFunds, got %v", err)
+	}
+
+	// the database refuses a balance below the limit the application let through
+	_, err = db.ExecContext(ctx, `update wallets set balance = -6 where id = $1`, int64(from.ID))
+	if database.ViolatedConstraint(err) != database.ConstraintWalletBalance {
+		t.Errorf("want a violation of %s, got %v", database.ConstraintWalletBalance, err)
+	}
+}
@@ -0,0 +1,320 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrUnsupportedCurrency   = errs.Class("unsupported currency")
+	ErrCurrencyDisabled      = errs.Class("currency disabled")
+	ErrInvalidCurrency       = errs.Class("invalid currency")
+	ErrCurrencyAlreadyExists = errs.Class("currency already exists")
+	ErrNewCurrencyFromDB     = errs.Class("new currency from db")
+	ErrLoadCurrencies        = errs.Class("load currencies")
+	ErrCreateCurrency        = errs.Class("create currency")
+	ErrSetCurrencyEnabled    = errs.Class("set currency enabled")
+	ErrSetFeeWallet          = errs.Class("set fee wallet")
+	ErrInvalidFeeWallet      = errs.Class("invalid fee wallet")
+)
+
+var currencyCodeRe = regexp.MustCompile(`^[A-Z0-9]{2,10}$`)
+
+// The registry of known currencies. It is empty until LoadCurrencies is called.
+var (
+	currenciesMu sync.RWMutex
+	currencies   = map[string]Currency{}
+)
+
+// Currency describes how amounts of a currency are represented: the number
+// of fractional digits, the smallest amount that can be transferred and
+// how computed amounts (e.g. fees) are rounded. Fees charged in the currency
+// are collected into its fee wallet.
+type Currency struct {
+	code         string
+	name         string
+	scale        int32
+	minUnit      Decimal
+	rounding     RoundingMode
+	enabled      bool
+	feeWallet    WalletID
+	hasFeeWallet bool
+}
+
+func NewCurrencyFromDB(currency database.Currency) (Currency, error) {
+	minUnit, err := NewDecimalFromDB(currency.MinUnit())
+	if err != nil {
+		return Currency{}, ErrNewCurrencyFromDB.Wrap(err)
+	}
+
+	rounding, err := ParseRoundingMode(currency.Rounding())
+	if err != nil {
+		return Currency{}, ErrNewCurrencyFromDB.Wrap(err)
+	}
+
+	c := Currency{
+		code:     currency.Code(),
+		name:     currency.Name(),
+		scale:    currency.Scale(),
+		minUnit:  minUnit,
+		rounding: rounding,
+		enabled:  currency.Enabled(),
+	}
+
+	if id := currency.FeeWallet(); id != nil {
+		c.feeWallet, c.hasFeeWallet = WalletIDFromDB(*id), true
+	}
+
+	return c, nil
+}
+
+// NewCurrency looks the code up in the registry. Disabled currencies are
+// returned as well, so existing wallets in them stay readable.
+func NewCurrency(code string) (Currency, error) {
+	currenciesMu.RLock()
+	c, ok := currencies[code]
+	currenciesMu.RUnlock()
+
+	if !ok {
+		return Currency{}, ErrUnsupportedCurrency.New(code)
+	}
+
+	return c, nil
+}
+
+// AllCurrencies returns the registered currencies ordered by code.
+func AllCurrencies() []Currency {
+	currenciesMu.RLock()
+	rv := make([]Currency, 0, len(currencies))
+	for _, c := range currencies {
+		rv = append(rv, c)
+	}
+	currenciesMu.RUnlock()
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].code < rv[j].code })
+
+	return rv
+}
+
+// LoadCurrencies replaces the registry with the currencies stored in the database.
+func LoadCurrencies(ctx context.Context, q database.ContextQuerier) error {
+	cs, err := database.FindAllCurrencies(ctx, q)
+	if err != nil {
+		return ErrLoadCurrencies.Wrap(err)
+	}
+
+	m := make(map[string]Currency, len(cs))
+	for _, dc := range cs {
+		c, err := NewCurrencyFromDB(dc)
+		if err != nil {
+			return ErrLoadCurrencies.Wrap(err)
+		}
+
+		m[c.code] = c
+	}
+
+	currenciesMu.Lock()
+	currencies = m
+	currenciesMu.Unlock()
+
+	return nil
+}
+
+func registerCurrency(c Currency) {
+	currenciesMu.Lock()
+	currencies[c.code] = c
+	currenciesMu.Unlock()
+}
+
+type CreateCurrencyParams struct {
+	Code     string
+	Name     string
+	Scale    int32
+	MinUnit  Decimal
+	Rounding RoundingMode
+}
+
+// CreateCurrency creates the currency along with an empty fee wallet for it
+// and adds it to the registry.
+func CreateCurrency(ctx context.Context, db *sql.DB, params *CreateCurrencyParams) (Currency, error) {
+	if !currencyCodeRe.MatchString(params.Code) {
+		return Currency{}, ErrCreateCurrency.Wrap(ErrInvalidCurrency.New("code %q", params.Code))
+	}
+	if params.Scale < 0 || params.Scale > maxDecimalScale {
+		return Currency{}, ErrCreateCurrency.Wrap(ErrInvalidCurrency.New("scale %d", params.Scale))
+	}
+	if params.MinUnit.Sign() <= 0 || !params.MinUnit.Round(params.Scale, RoundDown).Equal(params.MinUnit) {
+		return Currency{}, ErrCreateCurrency.Wrap(ErrInvalidCurrency.New("min unit %v", params.MinUnit))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return Currency{}, ErrCreateCurrency.Wrap(err)
+	}
+
+	dc, err := createCurrency(ctx, tx, params)
+	if err != nil {
+		return Currency{}, ErrCreateCurrency.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Currency{}, ErrCreateCurrency.Wrap(err)
+	}
+
+	c, err := NewCurrencyFromDB(dc)
+	if err != nil {
+		return Currency{}, ErrCreateCurrency.Wrap(err)
+	}
+
+	registerCurrency(c)
+
+	return c, nil
+}
+
+func createCurrency(ctx context.Context, tx *sql.Tx, params *CreateCurrencyParams) (database.Currency, error) {
+	dc, err := database.CreateCurrency(
+		ctx,
+		tx,
+		params.Code,
+		params.Name,
+		params.Scale,
+		params.MinUnit.ToDB(),
+		params.Rounding.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if dc == nil {
+		return nil, ErrCurrencyAlreadyExists.New(params.Code)
+	}
+
+	w, err := database.CreateWallet(ctx, tx, dc.Code(), []byte("{}"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.SetFeeWallet(ctx, tx, dc.Code(), w.ID())
+}
+
+// SetCurrencyEnabled enables or disables the currency. Disabled currencies
+// cannot be used for new wallets and transfers.
+func SetCurrencyEnabled(ctx context.Context, q database.ContextRowQuerier, code string, enabled bool) (Currency, error) {
+	dc, err := database.SetCurrencyEnabled(ctx, q, code, enabled)
+	if err != nil {
+		return Currency{}, ErrSetCurrencyEnabled.Wrap(err)
+	}
+	if dc == nil {
+		return Currency{}, ErrSetCurrencyEnabled.Wrap(ErrUnsupportedCurrency.New(code))
+	}
+
+	c, err := NewCurrencyFromDB(dc)
+	if err != nil {
+		return Currency{}, ErrSetCurrencyEnabled.Wrap(err)
+	}
+
+	registerCurrency(c)
+
+	return c, nil
+}
+
+// SetFeeWallet makes the wallet collect the fees charged in the currency.
+// The wallet must hold the same currency.
+func SetFeeWallet(ctx context.Context, q database.ContextRowQuerier, code string, walletID WalletID) (Currency, error) {
+	if _, err := NewCurrency(code); err != nil {
+		return Currency{}, ErrSetFeeWallet.Wrap(err)
+	}
+
+	dc, err := database.SetFeeWallet(ctx, q, code, walletID.ToDB())
+	if err != nil {
+		return Currency{}, ErrSetFeeWallet.Wrap(err)
+	}
+	if dc == nil {
+		return Currency{}, ErrSetFeeWallet.Wrap(ErrInvalidFeeWallet.New("wallet %v does not exist, is closed or does not hold %v", walletID, code))
+	}
+
+	c, err := NewCurrencyFromDB(dc)
+	if err != nil {
+		return Currency{}, ErrSetFeeWallet.Wrap(err)
+	}
+
+	registerCurrency(c)
+
+	return c, nil
+}
+
+func (c Currency) Equals(o Currency) bool {
+	return c.code == o.code
+}
+
+func (c Currency) String() string {
+	return c.code
+}
+
+func (c Currency) ToDB() database.CurrencyCode {
+	return c.String()
+}
+
+func (c Currency) Name() string {
+	return c.name
+}
+
+func (c Currency) Scale() int32 {
+	return c.scale
+}
+
+func (c Currency) MinUnit() Decimal {
+	return c.minUnit
+}
+
+func (c Currency) Rounding() RoundingMode {
+	return c.rounding
+}
+
+func (c Currency) Enabled() bool {
+	return c.enabled
+}
+
+// FeeWallet returns the wallet collecting the fees, ok is false if there is none.
+func (c Currency) FeeWallet() (id WalletID, ok bool) {
+	return c.feeWallet, c.hasFeeWallet
+}
+
+// CheckEnabled returns ErrCurrencyDisabled if the currency cannot be used
+// for new wallets and transfers.
+func (c Currency) CheckEnabled() error {
+	if !c.enabled {
+		return ErrCurrencyDisabled.New(c.code)
+	}
+
+	return nil
+}
+
+// Round rounds a computed amount to the precision of the currency.
+func (c Currency) Round(d Decimal) Decimal {
+	return d.Round(c.scale, c.rounding)
+}
+
+// Format returns d with exactly the number of fractional digits of the currency.
+func (c Currency) Format(d Decimal) string {
+	return c.Round(d).String()
+}
+
+// ValidateAmount checks that d is representable in the currency and is not
+// less than its minimum unit.
+func (c Currency) ValidateAmount(d Decimal) error {
+	if !d.Round(c.scale, RoundDown).Equal(d) {
+		return ErrInvalidAmount.New("%v has more than %d decimal places allowed for %v", d, c.scale, c)
+	}
+	if d.Less(c.minUnit) {
+		return ErrInvalidAmount.New("%v is less than minimum %v amount %v", d, c, c.minUnit)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"testing"
+)
+
+// newTestCurrency makes an enabled currency without registering it.
+func newTestCurrency(code string, scale int32, rounding RoundingMode) Currency {
+	return Currency{
+		code:     code,
+		name:     code,
+		scale:    scale,
+		minUnit:  NewDecimal(1, scale),
+		rounding: rounding,
+		enabled:  true,
+	}
+}
+
+func mustDecimal(t *testing.T, s string) Decimal {
+	t.Helper()
+
+	d, err := NewDecimalFromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	for _, m := range []RoundingMode{RoundHalfEven, RoundDown, RoundUp} {
+		got, err := ParseRoundingMode(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseRoundingMode(%q) = %v, %v", m.String(), got, err)
+		}
+	}
+
+	if _, err := ParseRoundingMode("half_up"); !ErrInvalidRoundingMode.Has(err) {
+		t.Errorf("ParseRoundingMode(half_up) error = %v", err)
+	}
+}
+
+func TestCurrencyRound(t *testing.T) {
+	tests := []struct {
+		currency Currency
+		in       string
+		want     string
+	}{
+		{newTestCurrency("USD", 2, RoundHalfEven), "1.005", "1.00"},
+		{newTestCurrency("USD", 2, RoundHalfEven), "1.0051", "1.01"},
+		{newTestCurrency("USD", 2, RoundDown), "1.009", "1.00"},
+		{newTestCurrency("USD", 2, RoundUp), "1.001", "1.01"},
+		{newTestCurrency("USD", 2, RoundUp), "1", "1.00"},
+		{newTestCurrency("JPY", 0, RoundHalfEven), "2.5", "2"},
+		{newTestCurrency("BTC", 8, RoundHalfEven), "0.000000015", "0.00000002"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.currency.Round(mustDecimal(t, tt.in)).String(); got != tt.want {
+			t.Errorf("%v %v.Round(%s) = %s, want %s", tt.currency, tt.currency.rounding, tt.in, got, tt.want)
+		}
+		if got := tt.currency.Format(mustDecimal(t, tt.in)); got != tt.want {
+			t.Errorf("%v.Format(%s) = %s, want %s", tt.currency, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCurrencyValidateAmount(t *testing.T) {
+	usd := newTestCurrency("USD", 2, RoundHalfEven)
+	usd.minUnit = mustDecimal(t, "0.05")
+
+	tests := []struct {
+		in    string
+		valid bool
+	}{
+		{"0.05", true},
+		{"1", true},
+		{"1.10", true},
+		{"1.100", true},
+		{"0.04", false},
+		{"0", false},
+		{"-1", false},
+		{"1.001", false},
+	}
+
+	for _, tt := range tests {
+		err := usd.ValidateAmount(mustDecimal(t, tt.in))
+		if tt.valid && err != nil {
+			t.Errorf("ValidateAmount(%s) = %v", tt.in, err)
+		}
+		if !tt.valid && !ErrInvalidAmount.Has(err) {
+			t.Errorf("ValidateAmount(%s) = %v, want ErrInvalidAmount", tt.in, err)
+		}
+	}
+}
+
+func TestCurrencyRegistry(t *testing.T) {
+	registerCurrency(newTestCurrency("TSTB", 2, RoundHalfEven))
+	registerCurrency(newTestCurrency("TSTA", 2, RoundHalfEven))
+
+	c, err := NewCurrency("TSTA")
+	if err != nil || c.String() != "TSTA" {
+		t.Errorf("NewCurrency(TSTA) = %v, %v", c, err)
+	}
+	if _, err := NewCurrency("TSTC"); !ErrUnsupportedCurrency.Has(err) {
+		t.Errorf("NewCurrency(TSTC) error = %v, want ErrUnsupportedCurrency", err)
+	}
+
+	all := AllCurrencies()
+	for i := 1; i < len(all); i++ {
+		if all[i].String() < all[i-1].String() {
+			t.Errorf("AllCurrencies is not ordered: %v before %v", all[i-1], all[i])
+		}
+	}
+
+	disabled := newTestCurrency("TSTD", 2, RoundHalfEven)
+	disabled.enabled = false
+	if err := disabled.CheckEnabled(); !ErrCurrencyDisabled.Has(err) {
+		t.Errorf("CheckEnabled() = %v, want ErrCurrencyDisabled", err)
+	}
+}
+
+func TestCreateCurrencyInvalid(t *testing.T) {
+	tests := []CreateCurrencyParams{
+		{Code: "usd", Scale: 2, MinUnit: NewDecimal(1, 2)},
+		{Code: "U", Scale: 2, MinUnit: NewDecimal(1, 2)},
+		{Code: "TOOLONGCODE", Scale: 2, MinUnit: NewDecimal(1, 2)},
+		{Code: "USD", Scale: -1, MinUnit: NewDecimal(1, 2)},
+		{Code: "USD", Scale: 2, MinUnit: Decimal{}},
+		{Code: "USD", Scale: 2, MinUnit: NewDecimal(-1, 2)},
+		{Code: "USD", Scale: 2, MinUnit: NewDecimal(1, 3)},
+	}
+
+	for _, params := range tests {
+		// the params are checked before the database is used
+		_, err := CreateCurrency(context.Background(), nil, &params)
+		if !ErrInvalidCurrency.Has(err) {
+			t.Errorf("CreateCurrency(%+v) error = %v, want ErrInvalidCurrency", params, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+// openTestDB connects to the database in DATABASE_URL, which must be
+// migrated (make migrate). Tests that need it are skipped if it is not set.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if len(databaseURL) == 0 {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	db, err := database.OpenDB(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := LoadCurrencies(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func testCurrency(t *testing.T, code string) Currency {
+	t.Helper()
+
+	c, err := NewCurrency(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// createTestWallet creates a wallet funded with balance.
+func createTestWallet(t *testing.T, db *sql.DB, currency Currency, balance Decimal) *Wallet {
+	t.Helper()
+
+	ctx := context.Background()
+
+	w, err := CreateWallet(ctx, db, &CreateWalletParams{Currency: currency})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.IsZero() {
+		return w
+	}
+
+	w, _, err = FundWallet(ctx, db, &FundWalletParams{Wallet: w.ID, Amount: balance, Reason: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func findTestWallet(t *testing.T, db *sql.DB, id WalletID) *Wallet {
+	t.Helper()
+
+	w, err := FindWalletByID(context.Background(), db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w == nil {
+		t.Fatalf("wallet %v does not exist", id)
+	}
+
+	return w
+}
+
+// transferInTx runs TransferFunds in its own transaction.
+func transferInTx(ctx context.Context, db *sql.DB, params *TransferFundsParams) (TransferFundsResult, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := TransferFunds(ctx, tx, params)
+	if err != nil {
+		return nil, errs.Combine(err, tx.Rollback())
+	}
+
+	return res, tx.Commit()
+}
@@ -0,0 +1,329 @@
+package lib
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var ErrInvalidRoundingMode = errs.Class("invalid rounding mode")
+
+// Limits of the postgres numeric type.
+const (
+	maxDecimalScale  = 16383
+	maxDecimalDigits = 131072
+)
+
+var bigTen = big.NewInt(10)
+
+type RoundingMode int
+
+const (
+	// RoundHalfEven rounds to the nearest neighbour, ties go to the even one.
+	RoundHalfEven RoundingMode = iota
+	// RoundDown rounds towards zero.
+	RoundDown
+	// RoundUp rounds away from zero.
+	RoundUp
+)
+
+func (m RoundingMode) String() string {
+	switch m {
+	case RoundHalfEven:
+		return "half_even"
+	case RoundDown:
+		return "down"
+	case RoundUp:
+		return "up"
+	}
+
+	return "RoundingMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	for _, m := range []RoundingMode{RoundHalfEven, RoundDown, RoundUp} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+
+	return 0, ErrInvalidRoundingMode.New("%q", s)
+}
+
+// Decimal is an exact decimal number: v * 10^-scale.
+// The zero value is 0. Decimal values are immutable.
+type Decimal struct {
+	v     *big.Int
+	scale int32
+}
+
+// NewDecimal returns unscaled * 10^-scale, e.g. NewDecimal(15, 1) is 1.5.
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	if scale < 0 {
+		v := big.NewInt(unscaled)
+		v.Mul(v, pow10(-scale))
+		return Decimal{v: v}
+	}
+
+	return Decimal{v: big.NewInt(unscaled), scale: scale}
+}
+
+func NewDecimalFromInt(v int64) Decimal {
+	return NewDecimal(v, 0)
+}
+
+func NewDecimalFromDB(value database.Decimal) (Decimal, error) {
+	return NewDecimalFromString(string(value))
+}
+
+// NewDecimalFromString parses plain (-12.34) and exponent (-1.234e1) notations.
+// The scale of the result is the number of fractional digits in s, so the
+// string produced by postgres for a decimal value round-trips through String.
+func NewDecimalFromString(s string) (Decimal, error) {
+	mantissa, exp, hasExp := s, "", false
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa, exp, hasExp = s[:i], s[i+1:], true
+	}
+
+	var neg bool
+	switch {
+	case strings.HasPrefix(mantissa, "-"):
+		neg, mantissa = true, mantissa[1:]
+	case strings.HasPrefix(mantissa, "+"):
+		mantissa = mantissa[1:]
+	}
+
+	intPart, fracPart := mantissa, ""
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		intPart, fracPart = mantissa[:i], mantissa[i+1:]
+	}
+	if len(intPart)+len(fracPart) == 0 || !isDigits(intPart) || !isDigits(fracPart) {
+		return Decimal{}, ErrInvalidDecimalString.New("%q", s)
+	}
+	if len(intPart)+len(fracPart) > maxDecimalDigits {
+		return Decimal{}, ErrInvalidDecimalString.New("%q: too many digits", s)
+	}
+
+	scale := int64(len(fracPart))
+	if hasExp {
+		e, err := strconv.ParseInt(exp, 10, 32)
+		if err != nil {
+			return Decimal{}, ErrInvalidDecimalString.New("%q", s)
+		}
+		scale -= e
+	}
+	if scale > maxDecimalScale || -scale > maxDecimalDigits {
+		return Decimal{}, ErrInvalidDecimalString.New("%q: out of range", s)
+	}
+
+	v, ok := new(big.Int).SetString(intPart+fracPart, 10)
+	if !ok {
+		return Decimal{}, ErrInvalidDecimalString.New("%q", s)
+	}
+	if neg {
+		v.Neg(v)
+	}
+
+	if scale < 0 {
+		v.Mul(v, pow10(int32(-scale)))
+		scale = 0
+	}
+
+	return Decimal{v: v, scale: int32(scale)}, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) unscaled() *big.Int {
+	if d.v == nil {
+		return new(big.Int)
+	}
+
+	return d.v
+}
+
+// rescaled returns the unscaled value of d at the given scale.
+// The scale must not be less than d.scale.
+func (d Decimal) rescaled(scale int32) *big.Int {
+	v := new(big.Int).Set(d.unscaled())
+	if scale == d.scale {
+		return v
+	}
+
+	return v.Mul(v, pow10(scale-d.scale))
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+func (d Decimal) ToDB() database.Decimal {
+	return database.Decimal(d.String())
+}
+
+// String returns d in plain notation with exactly Scale fractional digits.
+func (d Decimal) String() string {
+	v := d.unscaled()
+	digits := new(big.Int).Abs(v).String()
+
+	if d.scale > 0 {
+		if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+
+		i := len(digits) - int(d.scale)
+		digits = digits[:i] + "." + digits[i:]
+	}
+
+	if v.Sign() < 0 {
+		return "-" + digits
+	}
+
+	return digits
+}
+
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalText(text []byte) error {
+	v, err := NewDecimalFromString(string(text))
+	if err != nil {
+		return err
+	}
+
+	*d = v
+
+	return nil
+}
+
+func (d Decimal) Add(m Decimal) Decimal {
+	scale := maxScale(d.scale, m.scale)
+	v := d.rescaled(scale)
+	return Decimal{v: v.Add(v, m.rescaled(scale)), scale: scale}
+}
+
+func (d Decimal) Sub(m Decimal) Decimal {
+	scale := maxScale(d.scale, m.scale)
+	v := d.rescaled(scale)
+	return Decimal{v: v.Sub(v, m.rescaled(scale)), scale: scale}
+}
+
+// Mul is exact, the scale of the result is the sum of the scales.
+func (d Decimal) Mul(m Decimal) Decimal {
+	v := new(big.Int).Mul(d.unscaled(), m.unscaled())
+	return Decimal{v: v, scale: d.scale + m.scale}
+}
+
+// Div returns d / m rounded to the given scale. It panics if m is zero.
+func (d Decimal) Div(m Decimal, scale int32, mode RoundingMode) Decimal {
+	// d/m = (d.v / m.v) * 10^(m.scale - d.scale)
+	num := new(big.Int).Set(d.unscaled())
+	den := new(big.Int).Set(m.unscaled())
+	if shift := scale + m.scale - d.scale; shift >= 0 {
+		num.Mul(num, pow10(shift))
+	} else {
+		den.Mul(den, pow10(-shift))
+	}
+
+	return Decimal{v: quoRound(num, den, mode), scale: scale}
+}
+
+// Round returns d with the given scale, rounding if d has more digits.
+func (d Decimal) Round(scale int32, mode RoundingMode) Decimal {
+	if scale >= d.scale {
+		return Decimal{v: d.rescaled(scale), scale: scale}
+	}
+
+	return Decimal{v: quoRound(d.unscaled(), pow10(d.scale-scale), mode), scale: scale}
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{v: new(big.Int).Neg(d.unscaled()), scale: d.scale}
+}
+
+func (d Decimal) Abs() Decimal {
+	return Decimal{v: new(big.Int).Abs(d.unscaled()), scale: d.scale}
+}
+
+// Cmp returns -1, 0 or +1 if d is less than, equal to or greater than o.
+func (d Decimal) Cmp(o Decimal) int {
+	scale := maxScale(d.scale, o.scale)
+	return d.rescaled(scale).Cmp(o.rescaled(scale))
+}
+
+func (d Decimal) Sign() int {
+	return d.unscaled().Sign()
+}
+
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+func (d Decimal) Equal(o Decimal) bool {
+	return d.Cmp(o) == 0
+}
+
+func (d Decimal) Less(o Decimal) bool {
+	return d.Cmp(o) < 0
+}
+
+func maxScale(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// quoRound returns num / den rounded with mode.
+func quoRound(num, den *big.Int, mode RoundingMode) *big.Int {
+	if den.Sign() < 0 {
+		num = new(big.Int).Neg(num)
+		den = new(big.Int).Neg(den)
+	}
+
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 {
+		return q
+	}
+
+	var away bool
+	switch mode {
+	case RoundDown:
+		away = false
+	case RoundUp:
+		away = true
+	default:
+		twice := new(big.Int).Abs(r)
+		switch c := twice.Lsh(twice, 1).Cmp(den); {
+		case c > 0:
+			away = true
+		case c == 0:
+			away = q.Bit(0) == 1
+		}
+	}
+
+	if away {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+
+	return q
+}
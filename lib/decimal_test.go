@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNewDecimalFromString(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		scale int32
+	}{
+		{"0", "0", 0},
+		{"12", "12", 0},
+		{"-12.34", "-12.34", 2},
+		{"+1.50", "1.50", 2},
+		{".5", "0.5", 1},
+		{"5.", "5", 0},
+		{"0.000001", "0.000001", 6},
+		{"-1.234e1", "-12.34", 2},
+		{"1.5E-3", "0.0015", 4},
+		{"12e2", "1200", 0},
+		{"1e+2", "100", 0},
+	}
+
+	for _, tt := range tests {
+		d, err := NewDecimalFromString(tt.in)
+		if err != nil {
+			t.Errorf("NewDecimalFromString(%q): %v", tt.in, err)
+			continue
+		}
+		if got := d.String(); got != tt.want || d.Scale() != tt.scale {
+			t.Errorf("NewDecimalFromString(%q) = %s (scale %d), want %s (scale %d)", tt.in, got, d.Scale(), tt.want, tt.scale)
+		}
+	}
+}
+
+func TestNewDecimalFromStringInvalid(t *testing.T) {
+	for _, in := range []string{
+		"", "-", "+", ".", "-.", "abc", "1.2.3", "1,5", " 1", "1 ", "--1", "0x10",
+		"1e", "1E", "-2.5e", "1e+", "1e-", "e5", "1e1.5", "1e99999999999",
+	} {
+		if _, err := NewDecimalFromString(in); !ErrInvalidDecimalString.Has(err) {
+			t.Errorf("NewDecimalFromString(%q) error = %v, want ErrInvalidDecimalString", in, err)
+		}
+	}
+}
+
+func TestDecimalStringRoundTrip(t *testing.T) {
+	for _, in := range []string{"0", "0.00", "-0.01", "1", "123.456", "-99999999999999999999.99999999", "0.10"} {
+		d, err := NewDecimalFromString(in)
+		if err != nil {
+			t.Fatalf("NewDecimalFromString(%q): %v", in, err)
+		}
+		if got := d.String(); got != in {
+			t.Errorf("String() = %q, want %q", got, in)
+		}
+
+		again, err := NewDecimalFromString(d.String())
+		if err != nil || !again.Equal(d) || again.Scale() != d.Scale() {
+			t.Errorf("round trip of %q = %v (scale %d), %v", in, again, again.Scale(), err)
+		}
+	}
+}
+
+func TestQuoRound(t *testing.T) {
+	tests := []struct {
+		num, den int64
+		mode     RoundingMode
+		want     int64
+	}{
+		{7, 2, RoundHalfEven, 4},
+		{5, 2, RoundHalfEven, 2},
+		{-5, 2, RoundHalfEven, -2},
+		{-7, 2, RoundHalfEven, -4},
+		{7, 3, RoundHalfEven, 2},
+		{8, 3, RoundHalfEven, 3},
+		{5, -2, RoundHalfEven, -2},
+		{7, 3, RoundDown, 2},
+		{8, 3, RoundDown, 2},
+		{-8, 3, RoundDown, -2},
+		{7, 3, RoundUp, 3},
+		{-7, 3, RoundUp, -3},
+		{6, 3, RoundUp, 2},
+		{0, 3, RoundUp, 0},
+	}
+
+	for _, tt := range tests {
+		got := quoRound(big.NewInt(tt.num), big.NewInt(tt.den), tt.mode)
+		if got.Int64() != tt.want {
+			t.Errorf("quoRound(%d, %d, %v) = %v, want %d", tt.num, tt.den, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalRound(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int32
+		mode  RoundingMode
+		want  string
+	}{
+		{"1.005", 2, RoundHalfEven, "1.00"},
+		{"1.015", 2, RoundHalfEven, "1.02"},
+		{"1.019", 2, RoundDown, "1.01"},
+		{"-1.019", 2, RoundDown, "-1.01"},
+		{"1.011", 2, RoundUp, "1.02"},
+		{"-1.011", 2, RoundUp, "-1.02"},
+		{"1.5", 3, RoundDown, "1.500"},
+	}
+
+	for _, tt := range tests {
+		d, err := NewDecimalFromString(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Round(tt.scale, tt.mode).String(); got != tt.want {
+			t.Errorf("%s.Round(%d, %v) = %s, want %s", tt.in, tt.scale, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	a, b := NewDecimal(150, 2), NewDecimal(25, 1)
+
+	if got := a.Add(b).String(); got != "4.00" {
+		t.Errorf("Add = %s", got)
+	}
+	if got := a.Sub(b).String(); got != "-1.00" {
+		t.Errorf("Sub = %s", got)
+	}
+	if got := a.Mul(b).String(); got != "3.750" {
+		t.Errorf("Mul = %s", got)
+	}
+	if got := NewDecimalFromInt(1).Div(NewDecimalFromInt(3), 4, RoundHalfEven).String(); got != "0.3333" {
+		t.Errorf("Div = %s", got)
+	}
+	if !NewDecimal(10, 1).Equal(NewDecimalFromInt(1)) || !NewDecimal(9, 1).Less(NewDecimalFromInt(1)) {
+		t.Error("Cmp ignores scale")
+	}
+	if !(Decimal{}).IsZero() || (Decimal{}).String() != "0" {
+		t.Error("zero value is not 0")
+	}
+}
@@ -0,0 +1,306 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrFindExchangeRate      = errs.Class("find exchange rate")
+	ErrCreateExchangeRate    = errs.Class("create exchange rate")
+	ErrInvalidExchangeRate   = errs.Class("invalid exchange rate")
+	ErrExchangeRateNotFound  = errs.Class("exchange rate not found")
+	ErrLoadExchangeRates     = errs.Class("load exchange rates")
+	ErrNewExchangeRateFromDB = errs.Class("new exchange rate from db")
+)
+
+// ExchangeRate converts amounts of Base into Quote: 1 Base = Rate Quote.
+type ExchangeRate struct {
+	// ID identifies the rate in its provider and is recorded on the
+	// transfers converted with it.
+	ID    string
+	Base  Currency
+	Quote Currency
+	Rate  Decimal
+	// ValidFrom is inclusive, ValidTo is exclusive and nil if the rate has no end.
+	ValidFrom time.Time
+	ValidTo   *time.Time
+}
+
+// ValidAt reports whether the rate is in effect at t.
+func (r *ExchangeRate) ValidAt(t time.Time) bool {
+	return !t.Before(r.ValidFrom) && (r.ValidTo == nil || t.Before(*r.ValidTo))
+}
+
+func (r *ExchangeRate) validate() error {
+	if r.Base.Equals(r.Quote) {
+		return ErrInvalidExchangeRate.New("%v to itself", r.Base)
+	}
+	if r.Rate.Sign() <= 0 {
+		return ErrInvalidExchangeRate.New("rate %v", r.Rate)
+	}
+	if r.ValidTo != nil && !r.ValidFrom.Before(*r.ValidTo) {
+		return ErrInvalidExchangeRate.New("valid_to is not after valid_from")
+	}
+
+	return nil
+}
+
+func NewExchangeRateFromDB(rate database.ExchangeRate) (*ExchangeRate, error) {
+	if rate == nil {
+		return nil, nil
+	}
+
+	base, err := NewCurrency(rate.Base())
+	if err != nil {
+		return nil, ErrNewExchangeRateFromDB.Wrap(err)
+	}
+
+	quote, err := NewCurrency(rate.Quote())
+	if err != nil {
+		return nil, ErrNewExchangeRateFromDB.Wrap(err)
+	}
+
+	v, err := NewDecimalFromDB(rate.Rate())
+	if err != nil {
+		return nil, ErrNewExchangeRateFromDB.Wrap(err)
+	}
+
+	r := ExchangeRate{
+		ID:        rate.ID().String(),
+		Base:      base,
+		Quote:     quote,
+		Rate:      v,
+		ValidFrom: rate.ValidFrom(),
+		ValidTo:   rate.ValidTo(),
+	}
+
+	return &r, nil
+}
+
+// ExchangeRateProvider finds the rates of cross-currency transfers.
+type ExchangeRateProvider interface {
+	// ExchangeRate returns the rate converting base into quote in effect at
+	// the given time, nil if there is none.
+	ExchangeRate(ctx context.Context, base, quote Currency, at time.Time) (*ExchangeRate, error)
+}
+
+type staticExchangeRates struct {
+	rates []*ExchangeRate
+}
+
+// NewStaticExchangeRates serves a fixed list of rates. If the windows of
+// several rates of a pair overlap, the one with the latest ValidFrom wins.
+func NewStaticExchangeRates(rates []*ExchangeRate) ExchangeRateProvider {
+	return &staticExchangeRates{rates: append([]*ExchangeRate(nil), rates...)}
+}
+
+func (s *staticExchangeRates) ExchangeRate(_ context.Context, base, quote Currency, at time.Time) (*ExchangeRate, error) {
+	var rv *ExchangeRate
+	for _, r := range s.rates {
+		if !r.Base.Equals(base) || !r.Quote.Equals(quote) || !r.ValidAt(at) {
+			continue
+		}
+		if rv == nil || rv.ValidFrom.Before(r.ValidFrom) {
+			rv = r
+		}
+	}
+
+	return rv, nil
+}
+
+// ExchangeRateSpec is the JSON form of a rate in a rates file.
+type ExchangeRateSpec struct {
+	ID        string     `json:"id"`
+	Base      string     `json:"base"`
+	Quote     string     `json:"quote"`
+	Rate      Decimal    `json:"rate"`
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+}
+
+// LoadExchangeRatesFile reads static rates from a JSON file, e.g.
+//
+//	{"rates": [{"id": "btc-eth-1", "base": "BTC", "quote": "ETH", "rate": "31.5",
+//	  "valid_from": "2020-12-01T00:00:00Z", "valid_to": "2021-01-01T00:00:00Z"}]}
+//
+// Rates without an id get one derived from the pair and the position in the file.
+// The currencies must be loaded before.
+func LoadExchangeRatesFile(path string) (ExchangeRateProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, ErrLoadExchangeRates.Wrap(err)
+	}
+
+	var file struct {
+		Rates []ExchangeRateSpec `json:"rates"`
+	}
+
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, ErrLoadExchangeRates.Wrap(ErrInvalidExchangeRate.Wrap(err))
+	}
+
+	rates := make([]*ExchangeRate, len(file.Rates))
+	for i, spec := range file.Rates {
+		base, err := NewCurrency(spec.Base)
+		if err != nil {
+			return nil, ErrLoadExchangeRates.Wrap(err)
+		}
+
+		quote, err := NewCurrency(spec.Quote)
+		if err != nil {
+			return nil, ErrLoadExchangeRates.Wrap(err)
+		}
+
+		r := ExchangeRate{
+			ID:        spec.ID,
+			Base:      base,
+			Quote:     quote,
+			Rate:      spec.Rate,
+			ValidFrom: spec.ValidFrom,
+			ValidTo:   spec.ValidTo,
+		}
+		if r.ID == "" {
+			r.ID = base.String() + "/" + quote.String() + "/" + strconv.Itoa(i)
+		}
+
+		if err := r.validate(); err != nil {
+			return nil, ErrLoadExchangeRates.New("rate %d: %v", i, err)
+		}
+
+		rates[i] = &r
+	}
+
+	return NewStaticExchangeRates(rates), nil
+}
+
+type dbExchangeRates struct {
+	q database.ContextRowQuerier
+}
+
+// NewDBExchangeRates serves the rates of the exchange_rates table. The ids of
+// the rates are the ids of the rows.
+func NewDBExchangeRates(q database.ContextRowQuerier) ExchangeRateProvider {
+	return &dbExchangeRates{q: q}
+}
+
+func (p *dbExchangeRates) ExchangeRate(ctx context.Context, base, quote Currency, at time.Time) (*ExchangeRate, error) {
+	r, err := database.FindExchangeRate(ctx, p.q, base.ToDB(), quote.ToDB(), at)
+	if err != nil {
+		return nil, ErrFindExchangeRate.Wrap(err)
+	}
+
+	rv, err := NewExchangeRateFromDB(r)
+	if err != nil {
+		return nil, ErrFindExchangeRate.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+type CreateExchangeRateParams struct {
+	Base      Currency
+	Quote     Currency
+	Rate      Decimal
+	ValidFrom time.Time
+	ValidTo   *time.Time
+}
+
+// CreateExchangeRate adds a rate to the exchange_rates table.
+func CreateExchangeRate(ctx context.Context, q database.ContextRowQuerier, params *CreateExchangeRateParams) (*ExchangeRate, error) {
+	r := ExchangeRate{
+		Base:      params.Base,
+		Quote:     params.Quote,
+		Rate:      params.Rate,
+		ValidFrom: params.ValidFrom,
+		ValidTo:   params.ValidTo,
+	}
+	if err := r.validate(); err != nil {
+		return nil, ErrCreateExchangeRate.Wrap(err)
+	}
+
+	dr, err := database.CreateExchangeRate(ctx, q, &database.CreateExchangeRateParams{
+		Base:      params.Base.ToDB(),
+		Quote:     params.Quote.ToDB(),
+		Rate:      params.Rate.ToDB(),
+		ValidFrom: params.ValidFrom,
+		ValidTo:   params.ValidTo,
+	})
+	if err != nil {
+		return nil, ErrCreateExchangeRate.Wrap(err)
+	}
+
+	rv, err := NewExchangeRateFromDB(dr)
+	if err != nil {
+		return nil, ErrCreateExchangeRate.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// conversion is the currency conversion of a transfer: the sender pays the
+// amount of the transfer, gross is its value in the currency of the
+// receiver, and spread is the part of gross kept as revenue by spreadWallet.
+// spreadWallet is nil if spread is zero.
+type conversion struct {
+	rate         *ExchangeRate
+	gross        Decimal
+	spread       Decimal
+	destAmount   Decimal
+	spreadWallet *Wallet
+}
+
+// newConversion converts amount with rate and keeps spreadPercent of the
+// result, e.g. 0.5 is 0.5%. The converted amount is rounded down.
+func newConversion(rate *ExchangeRate, amount, spreadPercent Decimal) (*conversion, error) {
+	to := rate.Quote
+	gross := amount.Mul(rate.Rate).Round(to.Scale(), RoundDown)
+	spread := to.Round(percentOf(gross, spreadPercent))
+
+	c := conversion{
+		rate:       rate,
+		gross:      gross,
+		spread:     spread,
+		destAmount: gross.Sub(spread),
+	}
+	if err := to.ValidateAmount(c.destAmount); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// convertTransfer looks up the rate between the currencies of the wallets in
+// effect at the given time.
+func convertTransfer(
+	ctx context.Context,
+	rates ExchangeRateProvider,
+	spreadPercent Decimal,
+	ws *transferWallets,
+	amount Decimal,
+	at time.Time,
+) (*conversion, error) {
+	if rates == nil {
+		return nil, ErrUnsupportedCurrencyConversation
+	}
+
+	base, quote := ws.from.Currency, ws.to.Currency
+
+	rate, err := rates.ExchangeRate(ctx, base, quote, at)
+	if err != nil {
+		return nil, err
+	}
+	if rate == nil {
+		return nil, ErrExchangeRateNotFound.New("%v to %v at %v", base, quote, at.UTC().Format(time.RFC3339))
+	}
+
+	return newConversion(rate, amount, spreadPercent)
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewConversion(t *testing.T) {
+	base := newTestCurrency("TSTA", 2, RoundHalfEven)
+	quote := newTestCurrency("TSTB", 4, RoundHalfEven)
+
+	tests := []struct {
+		rate, amount, spreadPercent string
+		gross, spread, dest         string
+		valid                       bool
+	}{
+		{"1.5", "10", "0", "15", "0", "15", true},
+		{"0.33333", "1", "0", "0.3333", "0", "0.3333", true},
+		{"0.33333", "1", "1", "0.3333", "0.0033", "0.33", true},
+		{"2", "1.25", "0.5", "2.5", "0.0125", "2.4875", true},
+		{"0.00001", "1", "0", "", "", "", false},
+		{"1", "1", "100", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		rate := ExchangeRate{Base: base, Quote: quote, Rate: mustDecimal(t, tt.rate)}
+
+		c, err := newConversion(&rate, mustDecimal(t, tt.amount), mustDecimal(t, tt.spreadPercent))
+		if !tt.valid {
+			if !ErrInvalidAmount.Has(err) {
+				t.Errorf("%+v: want ErrInvalidAmount, got %v", tt, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt, err)
+			continue
+		}
+
+		if !c.gross.Equal(mustDecimal(t, tt.gross)) {
+			t.Errorf("%+v: gross %v", tt, c.gross)
+		}
+		if !c.spread.Equal(mustDecimal(t, tt.spread)) {
+			t.Errorf("%+v: spread %v", tt, c.spread)
+		}
+		if !c.destAmount.Equal(mustDecimal(t, tt.dest)) {
+			t.Errorf("%+v: destination amount %v", tt, c.destAmount)
+		}
+	}
+}
+
+func TestQuoteTransferConversion(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc, eth := testCurrency(t, "BTC"), testCurrency(t, "ETH")
+	from := createTestWallet(t, db, btc, mustDecimal(t, "10"))
+	to := createTestWallet(t, db, eth, Decimal{})
+
+	rates := NewStaticExchangeRates([]*ExchangeRate{{
+		ID:        "test",
+		Base:      btc,
+		Quote:     eth,
+		Rate:      mustDecimal(t, "30.5"),
+		ValidFrom: time.Now().Add(-time.Hour),
+	}})
+
+	params := QuoteTransferParams{
+		From:   from.ID,
+		To:     to.ID,
+		Amount: mustDecimal(t, "2"),
+		Fee:    NewFlatFee("test", Decimal{}),
+		Rates:  rates,
+	}
+
+	quote, err := QuoteTransfer(ctx, db, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Err != nil {
+		t.Fatalf("unexpected error: %v", quote.Err)
+	}
+	if quote.ExchangeRate == nil || quote.ExchangeRate.ID != "test" {
+		t.Errorf("want the rate test, got %+v", quote.ExchangeRate)
+	}
+	if !quote.DestinationAmount.Equal(mustDecimal(t, "61")) {
+		t.Errorf("destination amount %v, want 61", quote.DestinationAmount)
+	}
+
+	// without a provider the transfer would fail as TransferFunds does
+	params.Rates = nil
+	quote, err = QuoteTransfer(ctx, db, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Err != ErrUnsupportedCurrencyConversation {
+		t.Errorf("want ErrUnsupportedCurrencyConversation, got %v", quote.Err)
+	}
+}
@@ -0,0 +1,391 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrNewExternalTransactionFromDB    = errs.Class("new external transaction from db")
+	ErrDeposit                         = errs.Class("deposit")
+	ErrWithdraw                        = errs.Class("withdraw")
+	ErrFinishWithdrawal                = errs.Class("finish withdrawal")
+	ErrFindExternalTransactionByID     = errs.Class("find external transaction by id")
+	ErrFindPendingWithdrawals          = errs.Class("find pending withdrawals")
+	ErrInvalidExternalTransaction      = errs.Class("invalid external transaction")
+	ErrDuplicateExternalReference      = errs.Class("duplicate external reference")
+	ErrExternalTransactionDoesNotExist = errs.Class("external transaction does not exist")
+	ErrWithdrawalNotPending            = errs.Class("withdrawal is not pending")
+)
+
+const maxExternalReferenceLength = 255
+
+type ExternalTransactionID database.ExternalTransactionID
+
+func (id ExternalTransactionID) String() string {
+	return id.ToDB().String()
+}
+
+func (id ExternalTransactionID) ToDB() database.ExternalTransactionID {
+	return database.ExternalTransactionID(id)
+}
+
+func ParseExternalTransactionID(s string) (ExternalTransactionID, error) {
+	v, err := database.ParseID(s)
+	return ExternalTransactionID(v), err
+}
+
+// Kinds and statuses of external transactions.
+const (
+	KindDeposit    = database.KindDeposit
+	KindWithdrawal = database.KindWithdrawal
+
+	StatusPending   = database.StatusPending
+	StatusCompleted = database.StatusCompleted
+	StatusFailed    = database.StatusFailed
+)
+
+// ExternalTransaction is a deposit into a wallet or a withdrawal from it.
+// Reference identifies the transaction in the external source and is unique
+// per kind, so a deposit or withdrawal cannot be recorded twice.
+type ExternalTransaction struct {
+	ID         ExternalTransactionID
+	Wallet     WalletID
+	Kind       string
+	Amount     Decimal
+	Reference  string
+	Status     string
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+func NewExternalTransactionFromDB(t database.ExternalTransaction) (*ExternalTransaction, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	amount, err := NewDecimalFromDB(t.Amount())
+	if err != nil {
+		return nil, ErrNewExternalTransactionFromDB.Wrap(err)
+	}
+
+	rv := ExternalTransaction{
+		ID:         ExternalTransactionID(t.ID()),
+		Wallet:     WalletIDFromDB(t.Wallet()),
+		Kind:       t.Kind(),
+		Amount:     amount,
+		Reference:  t.Reference(),
+		Status:     t.Status(),
+		CreatedAt:  t.CreatedAt(),
+		FinishedAt: t.FinishedAt(),
+	}
+
+	return &rv, nil
+}
+
+type ExternalTransactionParams struct {
+	Wallet    WalletID
+	Amount    Decimal
+	Reference string
+	// Status decides whether a frozen wallet can receive a deposit.
+	Status WalletStatusRule
+}
+
+func (p *ExternalTransactionParams) validate() error {
+	if p.Amount.Sign() <= 0 {
+		return ErrInvalidExternalTransaction.New("amount %v", p.Amount)
+	}
+	if strings.TrimSpace(p.Reference) == "" {
+		return ErrInvalidExternalTransaction.New("missing reference")
+	}
+	if len(p.Reference) > maxExternalReferenceLength {
+		return ErrInvalidExternalTransaction.New("reference is longer than %d", maxExternalReferenceLength)
+	}
+
+	return nil
+}
+
+// Deposit credits the wallet with funds received from outside of the system.
+// Deposits are completed right away.
+func Deposit(ctx context.Context, db *sql.DB, params *ExternalTransactionParams) (*Wallet, *ExternalTransaction, error) {
+	if err := params.validate(); err != nil {
+		return nil, nil, ErrDeposit.Wrap(err)
+	}
+
+	wallet, t, err := inExternalTx(ctx, db, func(tx *sql.Tx) (*Wallet, *ExternalTransaction, error) {
+		return deposit(ctx, tx, params)
+	})
+	if err != nil {
+		return nil, nil, ErrDeposit.Wrap(err)
+	}
+
+	return wallet, t, nil
+}
+
+func deposit(ctx context.Context, tx *sql.Tx, params *ExternalTransactionParams) (*Wallet, *ExternalTransaction, error) {
+	wallet, err := lockExternalWallet(ctx, tx, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := params.Status.checkReceive(wallet); err != nil {
+		return nil, nil, err
+	}
+
+	t, err := createExternalTransaction(ctx, tx, params, KindDeposit, StatusCompleted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ws, err := postJournal(ctx, tx, &Journal{
+		Kind:                JournalDeposit,
+		ExternalTransaction: &t.ID,
+		Postings: []Posting{
+			{Account: WalletAccount(wallet.ID), Currency: wallet.Currency, Amount: t.Amount},
+			{Account: ExternalAccount(wallet.Currency), Currency: wallet.Currency, Amount: t.Amount.Neg()},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ws[wallet.ID], t, nil
+}
+
+// Withdraw debits the wallet and creates a pending withdrawal. The funds
+// leave the system when the withdrawal is completed with FinishWithdrawal,
+// or are returned to the wallet if it fails.
+func Withdraw(ctx context.Context, db *sql.DB, params *ExternalTransactionParams) (*Wallet, *ExternalTransaction, error) {
+	if err := params.validate(); err != nil {
+		return nil, nil, ErrWithdraw.Wrap(err)
+	}
+
+	wallet, t, err := inExternalTx(ctx, db, func(tx *sql.Tx) (*Wallet, *ExternalTransaction, error) {
+		return withdraw(ctx, tx, params)
+	})
+	if err != nil {
+		return nil, nil, ErrWithdraw.Wrap(err)
+	}
+
+	return wallet, t, nil
+}
+
+func withdraw(ctx context.Context, tx *sql.Tx, params *ExternalTransactionParams) (*Wallet, *ExternalTransaction, error) {
+	wallet, err := lockExternalWallet(ctx, tx, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := params.Status.checkSend(wallet); err != nil {
+		return nil, nil, err
+	}
+	if wallet.Available().Less(params.Amount) {
+		return nil, nil, ErrInsufficientFunds
+	}
+
+	t, err := createExternalTransaction(ctx, tx, params, KindWithdrawal, StatusPending)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ws, err := postJournal(ctx, tx, &Journal{
+		Kind:                JournalWithdrawal,
+		ExternalTransaction: &t.ID,
+		Postings: []Posting{
+			{Account: WalletAccount(wallet.ID), Currency: wallet.Currency, Amount: t.Amount.Neg()},
+			{Account: WithdrawalClearingAccount(wallet.Currency), Currency: wallet.Currency, Amount: t.Amount},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ws[wallet.ID], t, nil
+}
+
+// FinishWithdrawal completes or fails a pending withdrawal. A failed
+// withdrawal returns the funds to the wallet, even if it is frozen. It fails
+// with ErrWalletClosed if the wallet is closed, the withdrawal stays pending
+// and can still be completed.
+func FinishWithdrawal(ctx context.Context, db *sql.DB, id ExternalTransactionID, success bool) (*Wallet, *ExternalTransaction, error) {
+	wallet, t, err := inExternalTx(ctx, db, func(tx *sql.Tx) (*Wallet, *ExternalTransaction, error) {
+		return finishWithdrawal(ctx, tx, id, success)
+	})
+	if err != nil {
+		return nil, nil, ErrFinishWithdrawal.Wrap(err)
+	}
+
+	return wallet, t, nil
+}
+
+func finishWithdrawal(ctx context.Context, tx *sql.Tx, id ExternalTransactionID, success bool) (*Wallet, *ExternalTransaction, error) {
+	// the wallet is locked before the withdrawal, in the same order as Withdraw does
+	dt, err := database.FindExternalTransactionByID(ctx, tx, id.ToDB())
+	if err != nil {
+		return nil, nil, err
+	}
+	if dt == nil || dt.Kind() != KindWithdrawal {
+		return nil, nil, ErrExternalTransactionDoesNotExist.New("%v", id)
+	}
+
+	walletID := WalletIDFromDB(dt.Wallet())
+	ws, err := LockManyWalletsByIDs(ctx, tx, []WalletID{walletID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wallet := ws[walletID]
+	if !success && wallet.Status == WalletClosed {
+		// Closing is refused while withdrawals are pending, so this is a
+		// wallet closed before that check. Its funds cannot go back to it.
+		return nil, nil, ErrWalletClosed.New("%v: cannot return the funds of withdrawal %v", walletID, id)
+	}
+
+	status := StatusCompleted
+	if !success {
+		status = StatusFailed
+	}
+
+	dt, err = database.FinishExternalTransaction(ctx, tx, id.ToDB(), status)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dt == nil {
+		return nil, nil, ErrWithdrawalNotPending.New("%v", id)
+	}
+
+	t, err := NewExternalTransactionFromDB(dt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the funds leave the clearing account either to the outside or back to the wallet
+	journal := Journal{
+		Kind:                JournalWithdrawalCompleted,
+		ExternalTransaction: &t.ID,
+		Postings: []Posting{
+			{Account: WithdrawalClearingAccount(wallet.Currency), Currency: wallet.Currency, Amount: t.Amount.Neg()},
+			{Account: ExternalAccount(wallet.Currency), Currency: wallet.Currency, Amount: t.Amount},
+		},
+	}
+	if !success {
+		journal.Kind = JournalWithdrawalFailed
+		journal.Postings[1].Account = WalletAccount(wallet.ID)
+	}
+
+	ws, err = postJournal(ctx, tx, &journal)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if w, ok := ws[wallet.ID]; ok {
+		wallet = w
+	}
+
+	return wallet, t, nil
+}
+
+func FindExternalTransactionByID(ctx context.Context, q database.ContextRowQuerier, id ExternalTransactionID) (*ExternalTransaction, error) {
+	t, err := database.FindExternalTransactionByID(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindExternalTransactionByID.Wrap(err)
+	}
+
+	rv, err := NewExternalTransactionFromDB(t)
+	if err != nil {
+		return nil, ErrFindExternalTransactionByID.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// FindPendingWithdrawals returns the withdrawals waiting for FinishWithdrawal
+// from the oldest.
+func FindPendingWithdrawals(ctx context.Context, q database.ContextQuerier) ([]*ExternalTransaction, error) {
+	ts, err := database.FindPendingWithdrawals(ctx, q)
+	if err != nil {
+		return nil, ErrFindPendingWithdrawals.Wrap(err)
+	}
+
+	rv := make([]*ExternalTransaction, len(ts))
+	for i, t := range ts {
+		rv[i], err = NewExternalTransactionFromDB(t)
+		if err != nil {
+			return nil, ErrFindPendingWithdrawals.Wrap(err)
+		}
+	}
+
+	return rv, nil
+}
+
+func lockExternalWallet(ctx context.Context, tx *sql.Tx, params *ExternalTransactionParams) (*Wallet, error) {
+	ws, err := LockManyWalletsByIDs(ctx, tx, []WalletID{params.Wallet})
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, ok := ws[params.Wallet]
+	if !ok {
+		return nil, ErrWalletDoesNotExist.New("%v", params.Wallet)
+	}
+	if err := wallet.Currency.CheckEnabled(); err != nil {
+		return nil, err
+	}
+	if err := wallet.Currency.ValidateAmount(params.Amount); err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+func createExternalTransaction(
+	ctx context.Context,
+	tx *sql.Tx,
+	params *ExternalTransactionParams,
+	kind, status string,
+) (*ExternalTransaction, error) {
+	t, err := database.CreateExternalTransaction(
+		ctx,
+		tx,
+		params.Wallet.ToDB(),
+		kind,
+		params.Amount.ToDB(),
+		params.Reference,
+		status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrDuplicateExternalReference.New("%s %q", kind, params.Reference)
+	}
+
+	return NewExternalTransactionFromDB(t)
+}
+
+// inExternalTx runs f in a transaction that is committed if f succeeds.
+func inExternalTx(
+	ctx context.Context,
+	db *sql.DB,
+	f func(tx *sql.Tx) (*Wallet, *ExternalTransaction, error),
+) (*Wallet, *ExternalTransaction, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wallet, t, err := f(tx)
+	if err != nil {
+		return nil, nil, errs.Combine(err, tx.Rollback())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return wallet, t, nil
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExternalTransactionParamsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    string
+		reference string
+		valid     bool
+	}{
+		{"valid", "1", "bank-1", true},
+		{"zero amount", "0", "bank-1", false},
+		{"negative amount", "-1", "bank-1", false},
+		{"missing reference", "1", "", false},
+		{"blank reference", "1", " \t", false},
+		{"max reference", "1", strings.Repeat("r", maxExternalReferenceLength), true},
+		{"reference too long", "1", strings.Repeat("r", maxExternalReferenceLength+1), false},
+	}
+
+	for _, tt := range tests {
+		p := ExternalTransactionParams{Wallet: 1, Amount: mustDecimal(t, tt.amount), Reference: tt.reference}
+		err := p.validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.valid && !ErrInvalidExternalTransaction.Has(err) {
+			t.Errorf("%s: want ErrInvalidExternalTransaction, got %v", tt.name, err)
+		}
+	}
+}
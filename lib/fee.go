@@ -0,0 +1,377 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrInvalidFeePolicy = errs.Class("invalid fee policy")
+	ErrLoadFeePolicy    = errs.Class("load fee policy")
+)
+
+// FeePolicy computes the fee charged on top of a transferred amount.
+type FeePolicy interface {
+	// ID identifies the policy in the transactions it was applied to.
+	ID() string
+	// Fee returns the fee for amount. The result is rounded by the caller.
+	Fee(amount Decimal, currency Currency) Decimal
+}
+
+// FeePolicyResolver is implemented by policies that delegate to another
+// policy depending on the currency.
+type FeePolicyResolver interface {
+	Resolve(currency Currency) FeePolicy
+}
+
+// ResolveFeePolicy returns the policy that is actually applied to transfers in currency.
+func ResolveFeePolicy(policy FeePolicy, currency Currency) FeePolicy {
+	for {
+		r, ok := policy.(FeePolicyResolver)
+		if !ok {
+			return policy
+		}
+
+		policy = r.Resolve(currency)
+	}
+}
+
+type percentageFee struct {
+	id      string
+	percent Decimal
+}
+
+// NewPercentageFee charges percent of the amount, e.g. 1.5 is 1.5%.
+func NewPercentageFee(id string, percent Decimal) FeePolicy {
+	return &percentageFee{id: id, percent: percent}
+}
+
+func (p *percentageFee) ID() string {
+	return p.id
+}
+
+func (p *percentageFee) Fee(amount Decimal, _ Currency) Decimal {
+	return percentOf(amount, p.percent)
+}
+
+// percentOf is exact: dividing by 100 only needs two more digits of scale.
+func percentOf(amount, percent Decimal) Decimal {
+	v := amount.Mul(percent)
+	return v.Div(NewDecimalFromInt(100), v.Scale()+2, RoundDown)
+}
+
+type flatFee struct {
+	id     string
+	amount Decimal
+}
+
+// NewFlatFee charges the same amount regardless of the transferred amount.
+func NewFlatFee(id string, amount Decimal) FeePolicy {
+	return &flatFee{id: id, amount: amount}
+}
+
+func (f *flatFee) ID() string {
+	return f.id
+}
+
+func (f *flatFee) Fee(Decimal, Currency) Decimal {
+	return f.amount
+}
+
+type percentagePlusFlatFee struct {
+	id      string
+	percent Decimal
+	flat    Decimal
+}
+
+// NewPercentagePlusFlatFee charges percent of the amount plus a flat amount.
+func NewPercentagePlusFlatFee(id string, percent, flat Decimal) FeePolicy {
+	return &percentagePlusFlatFee{id: id, percent: percent, flat: flat}
+}
+
+func (p *percentagePlusFlatFee) ID() string {
+	return p.id
+}
+
+func (p *percentagePlusFlatFee) Fee(amount Decimal, _ Currency) Decimal {
+	return percentOf(amount, p.percent).Add(p.flat)
+}
+
+type clampedFee struct {
+	policy FeePolicy
+	min    *Decimal
+	max    *Decimal
+}
+
+// NewClampedFee limits the fee of policy to [min, max]. Nil bounds are not checked.
+// The clamped policy keeps the id of the wrapped one.
+func NewClampedFee(policy FeePolicy, min, max *Decimal) FeePolicy {
+	return &clampedFee{policy: policy, min: min, max: max}
+}
+
+func (c *clampedFee) ID() string {
+	return c.policy.ID()
+}
+
+func (c *clampedFee) Fee(amount Decimal, currency Currency) Decimal {
+	fee := c.policy.Fee(amount, currency)
+	if c.min != nil && fee.Less(*c.min) {
+		return *c.min
+	}
+	if c.max != nil && c.max.Less(fee) {
+		return *c.max
+	}
+
+	return fee
+}
+
+// FeeBracket applies Policy to amounts starting from From.
+type FeeBracket struct {
+	From   Decimal
+	Policy FeePolicy
+}
+
+type tieredFee struct {
+	id       string
+	brackets []FeeBracket
+}
+
+// NewTieredFee applies the policy of the bracket with the greatest From not
+// exceeding the amount. Amounts below every bracket are free.
+func NewTieredFee(id string, brackets []FeeBracket) FeePolicy {
+	bs := append([]FeeBracket(nil), brackets...)
+	sort.SliceStable(bs, func(i, j int) bool { return bs[i].From.Less(bs[j].From) })
+
+	return &tieredFee{id: id, brackets: bs}
+}
+
+func (t *tieredFee) ID() string {
+	return t.id
+}
+
+func (t *tieredFee) Fee(amount Decimal, currency Currency) Decimal {
+	for i := len(t.brackets) - 1; i >= 0; i-- {
+		if !amount.Less(t.brackets[i].From) {
+			return t.brackets[i].Policy.Fee(amount, currency)
+		}
+	}
+
+	return Decimal{}
+}
+
+type perCurrencyFee struct {
+	id        string
+	fallback  FeePolicy
+	overrides map[string]FeePolicy
+}
+
+// NewPerCurrencyFee applies the override for the currency of the transfer
+// or fallback if there is none.
+func NewPerCurrencyFee(id string, fallback FeePolicy, overrides map[string]FeePolicy) FeePolicy {
+	return &perCurrencyFee{id: id, fallback: fallback, overrides: overrides}
+}
+
+func (p *perCurrencyFee) ID() string {
+	return p.id
+}
+
+func (p *perCurrencyFee) Resolve(currency Currency) FeePolicy {
+	if o, ok := p.overrides[currency.String()]; ok {
+		return o
+	}
+
+	return p.fallback
+}
+
+func (p *perCurrencyFee) Fee(amount Decimal, currency Currency) Decimal {
+	return p.Resolve(currency).Fee(amount, currency)
+}
+
+// FeePolicySpec is the JSON form of a fee policy, e.g.
+//
+//	{"id": "default", "type": "per_currency",
+//	 "default": {"type": "percentage", "percent": "1.5", "max": "0.1"},
+//	 "currencies": {"ETH": {"type": "flat", "flat": "0.001"}}}
+//
+// Any policy can be clamped with min and max. Nested policies without an id
+// get one derived from the parent id.
+type FeePolicySpec struct {
+	ID         string                    `json:"id"`
+	Type       string                    `json:"type"`
+	Percent    *Decimal                  `json:"percent,omitempty"`
+	Flat       *Decimal                  `json:"flat,omitempty"`
+	Min        *Decimal                  `json:"min,omitempty"`
+	Max        *Decimal                  `json:"max,omitempty"`
+	Brackets   []FeeBracketSpec          `json:"brackets,omitempty"`
+	Default    *FeePolicySpec            `json:"default,omitempty"`
+	Currencies map[string]*FeePolicySpec `json:"currencies,omitempty"`
+}
+
+type FeeBracketSpec struct {
+	From   Decimal        `json:"from"`
+	Policy *FeePolicySpec `json:"policy"`
+}
+
+const (
+	FeePercentage         = "percentage"
+	FeeFlat               = "flat"
+	FeePercentagePlusFlat = "percentage_plus_flat"
+	FeeTiered             = "tiered"
+	FeePerCurrency        = "per_currency"
+)
+
+// ParseFeePolicy builds a policy from its JSON spec.
+func ParseFeePolicy(data []byte) (FeePolicy, error) {
+	var spec FeePolicySpec
+
+	err := json.Unmarshal(data, &spec)
+	if err != nil {
+		return nil, ErrInvalidFeePolicy.Wrap(err)
+	}
+	if spec.ID == "" {
+		return nil, ErrInvalidFeePolicy.New("missing id")
+	}
+
+	return spec.Build(spec.ID)
+}
+
+// Build makes the policy described by the spec. id is used if the spec has none.
+func (s *FeePolicySpec) Build(id string) (FeePolicy, error) {
+	if s.ID != "" {
+		id = s.ID
+	}
+
+	for _, d := range []*Decimal{s.Percent, s.Flat, s.Min, s.Max} {
+		if d != nil && d.Sign() < 0 {
+			return nil, ErrInvalidFeePolicy.New("%s: negative value %v", id, d)
+		}
+	}
+
+	var policy FeePolicy
+	switch s.Type {
+	case FeePercentage:
+		if s.Percent == nil {
+			return nil, ErrInvalidFeePolicy.New("%s: missing percent", id)
+		}
+
+		policy = NewPercentageFee(id, *s.Percent)
+	case FeeFlat:
+		if s.Flat == nil {
+			return nil, ErrInvalidFeePolicy.New("%s: missing flat", id)
+		}
+
+		policy = NewFlatFee(id, *s.Flat)
+	case FeePercentagePlusFlat:
+		if s.Percent == nil || s.Flat == nil {
+			return nil, ErrInvalidFeePolicy.New("%s: missing percent or flat", id)
+		}
+
+		policy = NewPercentagePlusFlatFee(id, *s.Percent, *s.Flat)
+	case FeeTiered:
+		if len(s.Brackets) == 0 {
+			return nil, ErrInvalidFeePolicy.New("%s: missing brackets", id)
+		}
+
+		brackets := make([]FeeBracket, len(s.Brackets))
+		for i, b := range s.Brackets {
+			if b.Policy == nil {
+				return nil, ErrInvalidFeePolicy.New("%s: bracket %d: missing policy", id, i)
+			}
+
+			p, err := b.Policy.Build(id + "/" + strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+
+			brackets[i] = FeeBracket{From: b.From, Policy: p}
+		}
+
+		policy = NewTieredFee(id, brackets)
+	case FeePerCurrency:
+		if s.Default == nil {
+			return nil, ErrInvalidFeePolicy.New("%s: missing default", id)
+		}
+
+		fallback, err := s.Default.Build(id + "/default")
+		if err != nil {
+			return nil, err
+		}
+
+		overrides := make(map[string]FeePolicy, len(s.Currencies))
+		for code, cs := range s.Currencies {
+			overrides[code], err = cs.Build(id + "/" + code)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		policy = NewPerCurrencyFee(id, fallback, overrides)
+	default:
+		return nil, ErrInvalidFeePolicy.New("%s: unknown type %q", id, s.Type)
+	}
+
+	if s.Min != nil || s.Max != nil {
+		if s.Min != nil && s.Max != nil && s.Max.Less(*s.Min) {
+			return nil, ErrInvalidFeePolicy.New("%s: max is less than min", id)
+		}
+
+		policy = NewClampedFee(policy, s.Min, s.Max)
+	}
+
+	return policy, nil
+}
+
+// LoadFeePolicyFile reads the policy spec from a JSON file.
+func LoadFeePolicyFile(path string) (FeePolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, ErrLoadFeePolicy.Wrap(err)
+	}
+
+	policy, err := ParseFeePolicy(data)
+	if err != nil {
+		return nil, ErrLoadFeePolicy.Wrap(err)
+	}
+
+	return policy, nil
+}
+
+// LoadActiveFeePolicy reads the active policy from the fee_policies table.
+func LoadActiveFeePolicy(ctx context.Context, q database.ContextRowQuerier) (FeePolicy, error) {
+	p, err := database.FindActiveFeePolicy(ctx, q)
+	if err != nil {
+		return nil, ErrLoadFeePolicy.Wrap(err)
+	}
+	if p == nil {
+		return nil, ErrLoadFeePolicy.New("no active fee policy")
+	}
+
+	var spec FeePolicySpec
+
+	err = json.Unmarshal(p.Spec(), &spec)
+	if err != nil {
+		return nil, ErrLoadFeePolicy.Wrap(ErrInvalidFeePolicy.Wrap(err))
+	}
+
+	// the row id is authoritative
+	spec.ID = p.ID()
+
+	policy, err := spec.Build(spec.ID)
+	if err != nil {
+		return nil, ErrLoadFeePolicy.Wrap(err)
+	}
+
+	return policy, nil
+}
+
+// calcFeeAmount returns the fee rounded to the precision of the currency.
+func calcFeeAmount(amount Decimal, policy FeePolicy, currency Currency) Decimal {
+	return currency.Round(policy.Fee(amount, currency))
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"testing"
+)
+
+type feeCase struct {
+	name     string
+	policy   FeePolicy
+	currency Currency
+	amount   string
+	want     string
+}
+
+func TestFeePolicies(t *testing.T) {
+	usd := newTestCurrency("USD", 2, RoundHalfEven)
+	eur := newTestCurrency("EUR", 2, RoundHalfEven)
+	min, max := NewDecimal(50, 2), NewDecimal(500, 2)
+
+	tests := []feeCase{
+		{"percentage", NewPercentageFee("p", mustDecimal(t, "1.5")), usd, "100", "1.50"},
+		{"percentage rounded", NewPercentageFee("p", mustDecimal(t, "1.5")), usd, "0.33", "0.00"},
+		{"percentage of zero", NewPercentageFee("p", mustDecimal(t, "1.5")), usd, "0", "0.00"},
+		{"flat", NewFlatFee("f", NewDecimal(25, 2)), usd, "1000", "0.25"},
+		{"percentage plus flat", NewPercentagePlusFlatFee("pf", NewDecimalFromInt(1), NewDecimal(10, 2)), usd, "50", "0.60"},
+		{"clamped to min", NewClampedFee(NewPercentageFee("c", NewDecimalFromInt(1)), &min, &max), usd, "10", "0.50"},
+		{"clamped to max", NewClampedFee(NewPercentageFee("c", NewDecimalFromInt(1)), &min, &max), usd, "1000", "5.00"},
+		{"within clamp", NewClampedFee(NewPercentageFee("c", NewDecimalFromInt(1)), &min, &max), usd, "100", "1.00"},
+		{"clamped without max", NewClampedFee(NewPercentageFee("c", NewDecimalFromInt(1)), &min, nil), usd, "100000", "1000.00"},
+	}
+
+	tiered := NewTieredFee("t", []FeeBracket{
+		{From: NewDecimalFromInt(1000), Policy: NewFlatFee("t/1", NewDecimalFromInt(5))},
+		{From: NewDecimalFromInt(100), Policy: NewFlatFee("t/0", NewDecimalFromInt(1))},
+	})
+	tests = append(tests, []feeCase{
+		{"below every bracket", tiered, usd, "99.99", "0.00"},
+		{"first bracket", tiered, usd, "100", "1.00"},
+		{"last bracket", tiered, usd, "5000", "5.00"},
+	}...)
+
+	perCurrency := NewPerCurrencyFee("c", NewFlatFee("c/default", NewDecimalFromInt(1)), map[string]FeePolicy{
+		"EUR": NewFlatFee("c/EUR", NewDecimalFromInt(2)),
+	})
+	tests = append(tests, []feeCase{
+		{"per currency fallback", perCurrency, usd, "10", "1.00"},
+		{"per currency override", perCurrency, eur, "10", "2.00"},
+	}...)
+
+	for _, tt := range tests {
+		got := calcFeeAmount(mustDecimal(t, tt.amount), tt.policy, tt.currency)
+		if got.String() != tt.want {
+			t.Errorf("%s: fee of %s = %v, want %s", tt.name, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestResolveFeePolicy(t *testing.T) {
+	usd := newTestCurrency("USD", 2, RoundHalfEven)
+	eur := newTestCurrency("EUR", 2, RoundHalfEven)
+
+	inner := NewPerCurrencyFee("inner", NewFlatFee("inner/default", Decimal{}), map[string]FeePolicy{
+		"EUR": NewFlatFee("inner/EUR", Decimal{}),
+	})
+	outer := NewPerCurrencyFee("outer", inner, nil)
+
+	if got := ResolveFeePolicy(outer, usd).ID(); got != "inner/default" {
+		t.Errorf("resolved %q for USD", got)
+	}
+	if got := ResolveFeePolicy(outer, eur).ID(); got != "inner/EUR" {
+		t.Errorf("resolved %q for EUR", got)
+	}
+}
+
+func TestParseFeePolicy(t *testing.T) {
+	usd := newTestCurrency("USD", 2, RoundHalfEven)
+	eth := newTestCurrency("ETH", 18, RoundHalfEven)
+
+	policy, err := ParseFeePolicy([]byte(`{
+		"id": "default", "type": "per_currency",
+		"default": {"type": "percentage", "percent": "1.5", "max": "0.1"},
+		"currencies": {"ETH": {"type": "flat", "flat": "0.001"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := policy.ID(); got != "default" {
+		t.Errorf("ID() = %q", got)
+	}
+	if got := ResolveFeePolicy(policy, eth).ID(); got != "default/ETH" {
+		t.Errorf("ETH policy id = %q", got)
+	}
+	if got := calcFeeAmount(NewDecimalFromInt(100), policy, usd).String(); got != "0.10" {
+		t.Errorf("USD fee = %s, want the max 0.10", got)
+	}
+	if got := calcFeeAmount(NewDecimalFromInt(100), policy, eth); !got.Equal(NewDecimal(1, 3)) {
+		t.Errorf("ETH fee = %v, want 0.001", got)
+	}
+}
+
+func TestParseFeePolicyInvalid(t *testing.T) {
+	for _, spec := range []string{
+		`not json`,
+		`{"type": "flat", "flat": "1"}`,
+		`{"id": "x", "type": "unknown"}`,
+		`{"id": "x", "type": "percentage"}`,
+		`{"id": "x", "type": "flat"}`,
+		`{"id": "x", "type": "flat", "flat": "-1"}`,
+		`{"id": "x", "type": "percentage_plus_flat", "percent": "1"}`,
+		`{"id": "x", "type": "tiered"}`,
+		`{"id": "x", "type": "tiered", "brackets": [{"from": "0"}]}`,
+		`{"id": "x", "type": "per_currency"}`,
+		`{"id": "x", "type": "flat", "flat": "1", "min": "2", "max": "1"}`,
+	} {
+		if _, err := ParseFeePolicy([]byte(spec)); !ErrInvalidFeePolicy.Has(err) {
+			t.Errorf("ParseFeePolicy(%s) error = %v, want ErrInvalidFeePolicy", spec, err)
+		}
+	}
+}
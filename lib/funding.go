@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrNewFundingFromDB = errs.Class("new funding from db")
+	ErrFundWallet       = errs.Class("fund wallet")
+	ErrInvalidFunding   = errs.Class("invalid funding")
+)
+
+type FundingID database.FundingID
+
+func (id FundingID) String() string {
+	return database.ID(id).String()
+}
+
+// Funding credits a wallet from outside of the system, e.g. with its opening
+// balance. Every funding is recorded with the reason given by the operator.
+type Funding struct {
+	ID        FundingID
+	Wallet    WalletID
+	Amount    Decimal
+	Reason    string
+	CreatedAt time.Time
+}
+
+func NewFundingFromDB(funding database.Funding) (*Funding, error) {
+	if funding == nil {
+		return nil, nil
+	}
+
+	amount, err := NewDecimalFromDB(funding.Amount())
+	if err != nil {
+		return nil, ErrNewFundingFromDB.Wrap(err)
+	}
+
+	f := Funding{
+		ID:        FundingID(funding.ID()),
+		Wallet:    WalletIDFromDB(funding.Wallet()),
+		Amount:    amount,
+		Reason:    funding.Reason(),
+		CreatedAt: funding.CreatedAt(),
+	}
+
+	return &f, nil
+}
+
+type FundWalletParams struct {
+	Wallet WalletID
+	Amount Decimal
+	Reason string
+	// Status decides whether a frozen wallet can be funded.
+	Status WalletStatusRule
+}
+
+// FundWallet adds the amount to the wallet and records the funding.
+func FundWallet(ctx context.Context, db *sql.DB, params *FundWalletParams) (*Wallet, *Funding, error) {
+	if strings.TrimSpace(params.Reason) == "" {
+		return nil, nil, ErrFundWallet.Wrap(ErrInvalidFunding.New("missing reason"))
+	}
+	if params.Amount.Sign() <= 0 {
+		return nil, nil, ErrFundWallet.Wrap(ErrInvalidFunding.New("cannot fund: %v", params.Amount))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, ErrFundWallet.Wrap(err)
+	}
+
+	wallet, funding, err := fundWallet(ctx, tx, params)
+	if err != nil {
+		return nil, nil, ErrFundWallet.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, ErrFundWallet.Wrap(err)
+	}
+
+	return wallet, funding, nil
+}
+
+func fundWallet(ctx context.Context, tx *sql.Tx, params *FundWalletParams) (*Wallet, *Funding, error) {
+	ws, err := LockManyWalletsByIDs(ctx, tx, []WalletID{params.Wallet})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wallet, ok := ws[params.Wallet]
+	if !ok {
+		return nil, nil, ErrWalletDoesNotExist.New("%v", params.Wallet)
+	}
+	if err := params.Status.checkReceive(wallet); err != nil {
+		return nil, nil, err
+	}
+	if err := wallet.Currency.CheckEnabled(); err != nil {
+		return nil, nil, err
+	}
+	if err := wallet.Currency.ValidateAmount(params.Amount); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := database.CreateFunding(ctx, tx, params.Wallet.ToDB(), params.Amount.ToDB(), params.Reason)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	funding, err := NewFundingFromDB(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ws, err = postJournal(ctx, tx, &Journal{
+		Kind:    JournalFunding,
+		Funding: &funding.ID,
+		Postings: []Posting{
+			{Account: WalletAccount(wallet.ID), Currency: wallet.Currency, Amount: params.Amount},
+			{Account: FundingAccount(wallet.Currency), Currency: wallet.Currency, Amount: params.Amount.Neg()},
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ws[wallet.ID], funding, nil
+}
@@ -0,0 +1,273 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrNewFXQuoteFromDB    = errs.Class("new fx quote from db")
+	ErrCreateFXQuote       = errs.Class("create fx quote")
+	ErrFindFXQuoteByID     = errs.Class("find fx quote by id")
+	ErrInvalidFXQuote      = errs.Class("invalid fx quote")
+	ErrFXQuoteDoesNotExist = errs.Class("fx quote does not exist")
+	ErrFXQuoteUsed         = errs.Class("fx quote already used")
+	ErrFXQuoteExpired      = errs.Class("fx quote expired")
+)
+
+const (
+	// DefaultFXQuoteTTL is used if CreateFXQuoteParams.TTL is zero.
+	DefaultFXQuoteTTL = time.Minute
+	MaxFXQuoteTTL     = time.Hour
+)
+
+type FXQuoteID database.FXQuoteID
+
+func (id FXQuoteID) String() string {
+	return id.ToDB().String()
+}
+
+func (id FXQuoteID) ToDB() database.FXQuoteID {
+	return database.FXQuoteID(id)
+}
+
+func ParseFXQuoteID(s string) (FXQuoteID, error) {
+	v, err := database.ParseID(s)
+	return FXQuoteID(v), err
+}
+
+// FXQuote locks the conversion of Amount from From to To until ExpiresAt.
+// A transfer made with the quote converts at its rate and spread instead of
+// the ones in effect when the transfer executes.
+type FXQuote struct {
+	ID   FXQuoteID
+	From WalletID
+	To   WalletID
+	// Base and Quote are the currencies of From and To.
+	Base              Currency
+	Quote             Currency
+	Amount            Decimal
+	DestinationAmount Decimal
+	ExchangeRate      Decimal
+	ExchangeRateID    string
+	FXSpread          Decimal
+	// Transfer is the transfer the quote was used for, nil until it is used.
+	Transfer  *TransferID
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func NewFXQuoteFromDB(quote database.FXQuote) (*FXQuote, error) {
+	if quote == nil {
+		return nil, nil
+	}
+
+	base, err := NewCurrency(quote.Base())
+	if err != nil {
+		return nil, ErrNewFXQuoteFromDB.Wrap(err)
+	}
+
+	quoteCurrency, err := NewCurrency(quote.Quote())
+	if err != nil {
+		return nil, ErrNewFXQuoteFromDB.Wrap(err)
+	}
+
+	amount, err := NewDecimalFromDB(quote.Amount())
+	if err != nil {
+		return nil, ErrNewFXQuoteFromDB.Wrap(err)
+	}
+
+	destAmount, err := NewDecimalFromDB(quote.DestinationAmount())
+	if err != nil {
+		return nil, ErrNewFXQuoteFromDB.Wrap(err)
+	}
+
+	rate, err := NewDecimalFromDB(quote.ExchangeRate())
+	if err != nil {
+		return nil, ErrNewFXQuoteFromDB.Wrap(err)
+	}
+
+	fxSpread, err := NewDecimalFromDB(quote.FXSpread())
+	if err != nil {
+		return nil, ErrNewFXQuoteFromDB.Wrap(err)
+	}
+
+	q := FXQuote{
+		ID:                FXQuoteID(quote.ID()),
+		From:              WalletIDFromDB(quote.From()),
+		To:                WalletIDFromDB(quote.To()),
+		Base:              base,
+		Quote:             quoteCurrency,
+		Amount:            amount,
+		DestinationAmount: destAmount,
+		ExchangeRate:      rate,
+		ExchangeRateID:    quote.ExchangeRateID(),
+		FXSpread:          fxSpread,
+		ExpiresAt:         quote.ExpiresAt(),
+		CreatedAt:         quote.CreatedAt(),
+	}
+
+	if id := quote.Transfer(); id != nil {
+		transfer := TransferIDFomDB(*id)
+		q.Transfer = &transfer
+	}
+
+	return &q, nil
+}
+
+// conversion returns the conversion the quote locked.
+func (q *FXQuote) conversion() *conversion {
+	rate := ExchangeRate{
+		ID:        q.ExchangeRateID,
+		Base:      q.Base,
+		Quote:     q.Quote,
+		Rate:      q.ExchangeRate,
+		ValidFrom: q.CreatedAt,
+		ValidTo:   &q.ExpiresAt,
+	}
+
+	return &conversion{
+		rate:       &rate,
+		gross:      q.DestinationAmount.Add(q.FXSpread),
+		spread:     q.FXSpread,
+		destAmount: q.DestinationAmount,
+	}
+}
+
+type CreateFXQuoteParams struct {
+	From   WalletID
+	To     WalletID
+	Amount Decimal
+	// Rates and FXSpread are used as TransferFunds would use them.
+	Rates    ExchangeRateProvider
+	FXSpread Decimal
+	// TTL defaults to DefaultFXQuoteTTL and cannot exceed MaxFXQuoteTTL.
+	TTL time.Duration
+}
+
+// CreateFXQuote converts the amount with the rate in effect now and keeps
+// the result for a transfer between the wallets until the quote expires.
+// Balances are not checked, they are when the quote is used.
+func CreateFXQuote(ctx context.Context, q database.ContextQueryExecutor, params *CreateFXQuoteParams) (*FXQuote, error) {
+	ttl := params.TTL
+	switch {
+	case ttl == 0:
+		ttl = DefaultFXQuoteTTL
+	case ttl < 0 || ttl > MaxFXQuoteTTL:
+		return nil, ErrCreateFXQuote.Wrap(ErrInvalidFXQuote.New("ttl must be in (0, %v]", MaxFXQuoteTTL))
+	}
+
+	ws, err := FindManyWalletsByIDs(ctx, q, []WalletID{params.From, params.To})
+	if err != nil {
+		return nil, ErrCreateFXQuote.Wrap(err)
+	}
+
+	from, to := ws[params.From], ws[params.To]
+	if from == nil {
+		return nil, ErrCreateFXQuote.Wrap(ErrWalletDoesNotExist.New("%v", params.From))
+	}
+	if to == nil {
+		return nil, ErrCreateFXQuote.Wrap(ErrWalletDoesNotExist.New("%v", params.To))
+	}
+	if from.Currency.Equals(to.Currency) {
+		return nil, ErrCreateFXQuote.Wrap(ErrInvalidFXQuote.New("both wallets are in %v", from.Currency))
+	}
+	if params.Amount.Sign() <= 0 {
+		return nil, ErrCreateFXQuote.Wrap(ErrInvalidFXQuote.New("amount %v", params.Amount))
+	}
+	if err := from.Currency.ValidateAmount(params.Amount); err != nil {
+		return nil, ErrCreateFXQuote.Wrap(err)
+	}
+
+	now := time.Now()
+
+	conv, err := convertTransfer(ctx, params.Rates, params.FXSpread, &transferWallets{from: from, to: to}, params.Amount, now)
+	if err != nil {
+		return nil, ErrCreateFXQuote.Wrap(err)
+	}
+
+	dq, err := database.CreateFXQuote(ctx, q, &database.CreateFXQuoteParams{
+		From:              from.ID.ToDB(),
+		To:                to.ID.ToDB(),
+		Base:              from.Currency.ToDB(),
+		Quote:             to.Currency.ToDB(),
+		Amount:            params.Amount.ToDB(),
+		DestinationAmount: conv.destAmount.ToDB(),
+		ExchangeRate:      conv.rate.Rate.ToDB(),
+		ExchangeRateID:    conv.rate.ID,
+		FXSpread:          conv.spread.ToDB(),
+		ExpiresAt:         now.Add(ttl),
+	})
+	if err != nil {
+		return nil, ErrCreateFXQuote.Wrap(err)
+	}
+
+	rv, err := NewFXQuoteFromDB(dq)
+	if err != nil {
+		return nil, ErrCreateFXQuote.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+func FindFXQuoteByID(ctx context.Context, q database.ContextRowQuerier, id FXQuoteID) (*FXQuote, error) {
+	fq, err := database.FindFXQuoteByID(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindFXQuoteByID.Wrap(err)
+	}
+
+	rv, err := NewFXQuoteFromDB(fq)
+	if err != nil {
+		return nil, ErrFindFXQuoteByID.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// lockFXQuote locks the quote for a transfer of amount between the locked
+// wallets and checks it can still be used. A zero amount matches any quote.
+func lockFXQuote(ctx context.Context, tx *sql.Tx, id FXQuoteID, ws *transferWallets, amount Decimal) (*FXQuote, error) {
+	dq, err := database.LockFXQuoteByID(ctx, tx, id.ToDB())
+	if err != nil {
+		return nil, err
+	}
+	if dq == nil {
+		return nil, ErrFXQuoteDoesNotExist.New("%v", id)
+	}
+
+	quote, err := NewFXQuoteFromDB(dq)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case quote.Transfer != nil:
+		return nil, ErrFXQuoteUsed.New("%v by transfer %v", id, *quote.Transfer)
+	case !time.Now().Before(quote.ExpiresAt):
+		return nil, ErrFXQuoteExpired.New("%v", id)
+	case quote.From != ws.from.ID || quote.To != ws.to.ID:
+		return nil, ErrInvalidFXQuote.New("%v is for a transfer from %v to %v", id, quote.From, quote.To)
+	case !amount.IsZero() && !amount.Equal(quote.Amount):
+		return nil, ErrInvalidFXQuote.New("%v is for an amount of %v", id, quote.Amount)
+	}
+
+	return quote, nil
+}
+
+// useFXQuote marks the quote locked by lockFXQuote as used by the transfer.
+func useFXQuote(ctx context.Context, tx *sql.Tx, id FXQuoteID, transfer TransferID) error {
+	dq, err := database.UseFXQuote(ctx, tx, id.ToDB(), transfer.ToDB())
+	if err != nil {
+		return err
+	}
+	if dq == nil {
+		return ErrFXQuoteUsed.New("%v", id)
+	}
+
+	return nil
+}
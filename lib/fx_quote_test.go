@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFXQuoteConversion(t *testing.T) {
+	q := FXQuote{
+		Base:              newTestCurrency("TSTA", 2, RoundHalfEven),
+		Quote:             newTestCurrency("TSTB", 4, RoundHalfEven),
+		DestinationAmount: mustDecimal(t, "2.4875"),
+		ExchangeRate:      mustDecimal(t, "2"),
+		ExchangeRateID:    "test",
+		FXSpread:          mustDecimal(t, "0.0125"),
+		ExpiresAt:         time.Now().Add(DefaultFXQuoteTTL),
+	}
+
+	c := q.conversion()
+	if !c.gross.Equal(mustDecimal(t, "2.5")) {
+		t.Errorf("gross %v, want 2.5", c.gross)
+	}
+	if !c.destAmount.Equal(q.DestinationAmount) || !c.spread.Equal(q.FXSpread) {
+		t.Errorf("got %v and %v, want the amounts of the quote", c.destAmount, c.spread)
+	}
+	if c.rate.ID != "test" || !c.rate.Rate.Equal(q.ExchangeRate) {
+		t.Errorf("got rate %+v, want the rate of the quote", c.rate)
+	}
+}
+
+func TestFXQuoteSingleUse(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc, eth := testCurrency(t, "BTC"), testCurrency(t, "ETH")
+	from := createTestWallet(t, db, btc, mustDecimal(t, "10"))
+	to := createTestWallet(t, db, eth, Decimal{})
+	other := createTestWallet(t, db, eth, Decimal{})
+
+	rates := NewStaticExchangeRates([]*ExchangeRate{{
+		ID:        "test",
+		Base:      btc,
+		Quote:     eth,
+		Rate:      mustDecimal(t, "30"),
+		ValidFrom: time.Now().Add(-time.Hour),
+	}})
+
+	quote, err := CreateFXQuote(ctx, db, &CreateFXQuoteParams{From: from.ID, To: to.ID, Amount: mustDecimal(t, "1"), Rates: rates})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fee := NewFlatFee("test", Decimal{})
+	transfer := func(to WalletID, amount string) error {
+		// no rates, the transfer converts with the quote only
+		_, err := transferInTx(ctx, db, &TransferFundsParams{
+			From:   from.ID,
+			To:     to,
+			Amount: mustDecimal(t, amount),
+			Fee:    fee,
+			Quote:  &quote.ID,
+		})
+		return err
+	}
+
+	if err := transfer(other.ID, "1"); !ErrInvalidFXQuote.Has(err) {
+		t.Errorf("other wallet: want ErrInvalidFXQuote, got %v", err)
+	}
+	if err := transfer(to.ID, "2"); !ErrInvalidFXQuote.Has(err) {
+		t.Errorf("other amount: want ErrInvalidFXQuote, got %v", err)
+	}
+	if err := transfer(to.ID, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer(to.ID, "1"); !ErrFXQuoteUsed.Has(err) {
+		t.Errorf("second use: want ErrFXQuoteUsed, got %v", err)
+	}
+
+	if w := findTestWallet(t, db, to.ID); !w.Balance.Equal(mustDecimal(t, "30")) {
+		t.Errorf("receiver balance %v, want 30", w.Balance)
+	}
+	if w := findTestWallet(t, db, from.ID); !w.Balance.Equal(mustDecimal(t, "9")) {
+		t.Errorf("sender balance %v, want 9", w.Balance)
+	}
+}
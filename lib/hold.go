@@ -0,0 +1,404 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrNewHoldFromDB    = errs.Class("new hold from db")
+	ErrCreateHold       = errs.Class("create hold")
+	ErrCaptureHold      = errs.Class("capture hold")
+	ErrVoidHold         = errs.Class("void hold")
+	ErrExpireHolds      = errs.Class("expire holds")
+	ErrFindHoldByID     = errs.Class("find hold by id")
+	ErrInvalidHold      = errs.Class("invalid hold")
+	ErrHoldDoesNotExist = errs.Class("hold does not exist")
+	ErrHoldNotPending   = errs.Class("hold is not pending")
+	ErrHoldExpired      = errs.Class("hold expired")
+)
+
+const (
+	// DefaultHoldTTL is used if CreateHoldParams.TTL is zero.
+	DefaultHoldTTL = 7 * 24 * time.Hour
+	MaxHoldTTL     = 30 * 24 * time.Hour
+
+	// expireHoldsBatch is the number of holds ExpireHolds loads at once.
+	expireHoldsBatch = 100
+)
+
+// Statuses of holds.
+const (
+	HoldPending  = database.HoldPending
+	HoldCaptured = database.HoldCaptured
+	HoldVoided   = database.HoldVoided
+	HoldExpired  = database.HoldExpired
+)
+
+type HoldID database.HoldID
+
+func (id HoldID) String() string {
+	return id.ToDB().String()
+}
+
+func (id HoldID) ToDB() database.HoldID {
+	return database.HoldID(id)
+}
+
+func ParseHoldID(s string) (HoldID, error) {
+	v, err := database.ParseID(s)
+	return HoldID(v), err
+}
+
+// Hold reserves Amount plus FeeAmount of the available balance of From for
+// a transfer to To. The balance itself does not change until the hold is
+// captured.
+type Hold struct {
+	ID        HoldID
+	From      WalletID
+	To        WalletID
+	Amount    Decimal
+	FeeAmount Decimal
+	FeePolicy string
+	Status    string
+	// Transfer is the transfer the hold was captured into.
+	Transfer   *TransferID
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// Total is the amount reserved on the sender.
+func (h *Hold) Total() Decimal {
+	return h.Amount.Add(h.FeeAmount)
+}
+
+func NewHoldFromDB(hold database.Hold) (*Hold, error) {
+	if hold == nil {
+		return nil, nil
+	}
+
+	amount, err := NewDecimalFromDB(hold.Amount())
+	if err != nil {
+		return nil, ErrNewHoldFromDB.Wrap(err)
+	}
+
+	feeAmount, err := NewDecimalFromDB(hold.FeeAmount())
+	if err != nil {
+		return nil, ErrNewHoldFromDB.Wrap(err)
+	}
+
+	h := Hold{
+		ID:         HoldID(hold.ID()),
+		From:       WalletIDFromDB(hold.From()),
+		To:         WalletIDFromDB(hold.To()),
+		Amount:     amount,
+		FeeAmount:  feeAmount,
+		FeePolicy:  hold.FeePolicy(),
+		Status:     hold.Status(),
+		ExpiresAt:  hold.ExpiresAt(),
+		CreatedAt:  hold.CreatedAt(),
+		FinishedAt: hold.FinishedAt(),
+	}
+
+	if id := hold.Transfer(); id != nil {
+		transfer := TransferIDFomDB(*id)
+		h.Transfer = &transfer
+	}
+
+	return &h, nil
+}
+
+type CreateHoldParams struct {
+	From   WalletID
+	To     WalletID
+	Amount Decimal
+	// Fee is resolved for the currency of the transfer with ResolveFeePolicy.
+	Fee FeePolicy
+	// TTL defaults to DefaultHoldTTL and cannot exceed MaxHoldTTL.
+	TTL time.Duration
+	// Status decides whether frozen wallets can receive the funds.
+	Status WalletStatusRule
+}
+
+// CreateHold reserves the amount and the fee of a transfer on the sender.
+// The checks are the same as TransferFunds does, except that the funds stay
+// on the sender until the hold is captured.
+func CreateHold(ctx context.Context, db *sql.DB, params *CreateHoldParams) (*Hold, error) {
+	ttl := params.TTL
+	switch {
+	case ttl == 0:
+		ttl = DefaultHoldTTL
+	case ttl < 0 || ttl > MaxHoldTTL:
+		return nil, ErrCreateHold.Wrap(ErrInvalidHold.New("ttl must be in (0, %v]", MaxHoldTTL))
+	}
+	if params.Amount.Sign() <= 0 {
+		return nil, ErrCreateHold.Wrap(ErrInvalidHold.New("amount %v", params.Amount))
+	}
+
+	h, err := inHoldTx(ctx, db, func(tx *sql.Tx) (*Hold, error) {
+		return createHold(ctx, tx, params, ttl)
+	})
+	if err != nil {
+		return nil, ErrCreateHold.Wrap(err)
+	}
+
+	return h, nil
+}
+
+func createHold(ctx context.Context, tx *sql.Tx, params *CreateHoldParams, ttl time.Duration) (*Hold, error) {
+	ws, err := lockTransferWallets(ctx, tx, params.From, params.To)
+	if err != nil {
+		return nil, err
+	}
+	if err := params.Status.checkTransfer(ws.from, ws.to); err != nil {
+		return nil, err
+	}
+
+	policy := ResolveFeePolicy(params.Fee, ws.from.Currency)
+	feeAmount := calcFeeAmount(params.Amount, policy, ws.from.Currency)
+
+	// the plan is only verified, it is executed on capture
+	plan, err := planTransfer(ws, params.Amount, feeAmount, policy, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = holdFunds(ctx, tx, plan.from.ID, plan.amount.Add(plan.feeAmount))
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := database.CreateHold(ctx, tx, &database.CreateHoldParams{
+		From:      plan.from.ID.ToDB(),
+		To:        plan.to.ID.ToDB(),
+		Amount:    plan.amount.ToDB(),
+		FeeAmount: plan.feeAmount.ToDB(),
+		FeePolicy: policy.ID(),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return nil, constraintError(err)
+	}
+
+	return NewHoldFromDB(h)
+}
+
+// CaptureHold releases the reserved funds and transfers amount from them.
+// A nil amount captures the whole hold, a smaller one returns the rest to
+// the available balance. The fee is recalculated for the captured amount
+// with policy but never exceeds the reserved fee. The wallets are checked
+// against statuses again, a hold on a wallet frozen since can only be voided.
+func CaptureHold(ctx context.Context, db *sql.DB, id HoldID, amount *Decimal, policy FeePolicy, statuses WalletStatusRule) (*Hold, error) {
+	h, err := inHoldTx(ctx, db, func(tx *sql.Tx) (*Hold, error) {
+		return captureHold(ctx, tx, id, amount, policy, statuses)
+	})
+	if err != nil {
+		return nil, ErrCaptureHold.Wrap(err)
+	}
+
+	return h, nil
+}
+
+func captureHold(ctx context.Context, tx *sql.Tx, id HoldID, amount *Decimal, policy FeePolicy, statuses WalletStatusRule) (*Hold, error) {
+	// the wallets are locked before the hold, in the same order as CreateHold does
+	h, err := FindHoldByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, ErrHoldDoesNotExist.New("%v", id)
+	}
+
+	ws, err := lockTransferWallets(ctx, tx, h.From, h.To)
+	if err != nil {
+		return nil, err
+	}
+	if err := statuses.checkTransfer(ws.from, ws.to); err != nil {
+		return nil, err
+	}
+
+	h, err = lockPendingHold(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(h.ExpiresAt) {
+		return nil, ErrHoldExpired.New("%v", id)
+	}
+
+	captured := h.Amount
+	if amount != nil {
+		if amount.Sign() <= 0 || h.Amount.Less(*amount) {
+			return nil, ErrInvalidHold.New("capture amount must be in (0, %v]", h.Amount)
+		}
+
+		captured = *amount
+	}
+
+	policy = ResolveFeePolicy(policy, ws.from.Currency)
+	feeAmount := calcFeeAmount(captured, policy, ws.from.Currency)
+	if h.FeeAmount.Less(feeAmount) {
+		feeAmount = h.FeeAmount
+	}
+
+	ws.from, err = releaseFunds(ctx, tx, h.From, h.Total())
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := planTransfer(ws, captured, feeAmount, policy, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := doTransfer(ctx, tx, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	return finishHold(ctx, tx, id, HoldCaptured, &res.Transfer().ID)
+}
+
+// VoidHold returns the reserved funds to the available balance.
+func VoidHold(ctx context.Context, db *sql.DB, id HoldID) (*Hold, error) {
+	h, err := inHoldTx(ctx, db, func(tx *sql.Tx) (*Hold, error) {
+		return releaseHold(ctx, tx, id, HoldVoided)
+	})
+	if err != nil {
+		return nil, ErrVoidHold.Wrap(err)
+	}
+
+	return h, nil
+}
+
+// ExpireHolds releases the funds of the pending holds that have expired and
+// returns how many holds were expired. Each hold is expired in its own
+// transaction, so a failure does not undo the holds expired before it.
+func ExpireHolds(ctx context.Context, db *sql.DB) (int, error) {
+	var n int
+	for {
+		hs, err := database.FindExpiredHolds(ctx, db, time.Now(), expireHoldsBatch)
+		if err != nil {
+			return n, ErrExpireHolds.Wrap(err)
+		}
+
+		for _, dh := range hs {
+			_, err := inHoldTx(ctx, db, func(tx *sql.Tx) (*Hold, error) {
+				return releaseHold(ctx, tx, HoldID(dh.ID()), HoldExpired)
+			})
+			switch {
+			case ErrHoldNotPending.Has(err):
+				// captured or voided concurrently
+				continue
+			case err != nil:
+				return n, ErrExpireHolds.Wrap(err)
+			}
+
+			n++
+		}
+
+		if len(hs) < expireHoldsBatch {
+			return n, nil
+		}
+	}
+}
+
+// releaseHold finishes a pending hold with status and returns the funds to
+// the available balance of the sender.
+func releaseHold(ctx context.Context, tx *sql.Tx, id HoldID, status string) (*Hold, error) {
+	h, err := FindHoldByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		return nil, ErrHoldDoesNotExist.New("%v", id)
+	}
+
+	_, err = LockManyWalletsByIDs(ctx, tx, []WalletID{h.From})
+	if err != nil {
+		return nil, err
+	}
+
+	h, err = lockPendingHold(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = releaseFunds(ctx, tx, h.From, h.Total())
+	if err != nil {
+		return nil, err
+	}
+
+	return finishHold(ctx, tx, id, status, nil)
+}
+
+func FindHoldByID(ctx context.Context, q database.ContextRowQuerier, id HoldID) (*Hold, error) {
+	h, err := database.FindHoldByID(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindHoldByID.Wrap(err)
+	}
+
+	rv, err := NewHoldFromDB(h)
+	if err != nil {
+		return nil, ErrFindHoldByID.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+func lockPendingHold(ctx context.Context, tx *sql.Tx, id HoldID) (*Hold, error) {
+	dh, err := database.LockHoldByID(ctx, tx, id.ToDB())
+	if err != nil {
+		return nil, err
+	}
+	if dh == nil {
+		return nil, ErrHoldDoesNotExist.New("%v", id)
+	}
+	if dh.Status() != HoldPending {
+		return nil, ErrHoldNotPending.New("%v is %s", id, dh.Status())
+	}
+
+	return NewHoldFromDB(dh)
+}
+
+func finishHold(ctx context.Context, tx *sql.Tx, id HoldID, status string, transfer *TransferID) (*Hold, error) {
+	var dbTransfer *database.TransferID
+	if transfer != nil {
+		v := transfer.ToDB()
+		dbTransfer = &v
+	}
+
+	dh, err := database.FinishHold(ctx, tx, id.ToDB(), status, dbTransfer)
+	if err != nil {
+		return nil, err
+	}
+	if dh == nil {
+		return nil, ErrHoldNotPending.New("%v", id)
+	}
+
+	return NewHoldFromDB(dh)
+}
+
+// inHoldTx runs f in a transaction that is committed if f succeeds.
+func inHoldTx(ctx context.Context, db *sql.DB, f func(tx *sql.Tx) (*Hold, error)) (*Hold, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := f(tx)
+	if err != nil {
+		return nil, errs.Combine(err, tx.Rollback())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zeebo/errs"
+)
+
+func TestQuoteTransferWithHold(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc := testCurrency(t, "BTC")
+	from := createTestWallet(t, db, btc, mustDecimal(t, "10"))
+	to := createTestWallet(t, db, btc, Decimal{})
+	fee := NewFlatFee("test", Decimal{})
+
+	_, err := CreateHold(ctx, db, &CreateHoldParams{From: from.ID, To: to.ID, Amount: mustDecimal(t, "4"), Fee: fee})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		amount         string
+		balanceAfter   string
+		availableAfter string
+		ok             bool
+	}{
+		{"6", "4", "0", true},
+		{"8", "2", "-2", false},
+	}
+
+	for _, tt := range tests {
+		quote, err := QuoteTransfer(ctx, db, &QuoteTransferParams{
+			From:   from.ID,
+			To:     to.ID,
+			Amount: mustDecimal(t, tt.amount),
+			Fee:    fee,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !quote.BalanceAfter.Equal(mustDecimal(t, tt.balanceAfter)) {
+			t.Errorf("%s: balance after %v, want %s", tt.amount, quote.BalanceAfter, tt.balanceAfter)
+		}
+		if !quote.AvailableAfter.Equal(mustDecimal(t, tt.availableAfter)) {
+			t.Errorf("%s: available after %v, want %s", tt.amount, quote.AvailableAfter, tt.availableAfter)
+		}
+		if tt.ok && quote.Err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.amount, quote.Err)
+		}
+		if !tt.ok && !errs.Is(quote.Err, ErrInsufficientFunds) {
+			t.Errorf("%s: want ErrInsufficientFunds, got %v", tt.amount, quote.Err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrClaimIdempotencyKey          = errs.Class("claim idempotency key")
+	ErrIdempotencyKeyReused         = errs.Class("idempotency key reused with another request")
+	ErrSaveIdempotentResponse       = errs.Class("save idempotent response")
+	ErrDeleteExpiredIdempotencyKeys = errs.Class("delete expired idempotency keys")
+)
+
+// IdempotentResponse is the response saved for an idempotency key.
+type IdempotentResponse struct {
+	Status     int
+	Body       []byte
+	TransferID *TransferID
+}
+
+// ClaimIdempotencyKey returns the response saved for the key by an earlier
+// request, or nil if the request has to be processed. In the latter case
+// the key stays claimed until tx ends, and the response must be saved with
+// SaveIdempotentResponse before tx is committed. Concurrent requests with
+// the same key wait for tx to end.
+//
+// ErrIdempotencyKeyReused is returned if the key was used for a request
+// with another hash.
+func ClaimIdempotencyKey(ctx context.Context, tx *sql.Tx, key, requestHash string, retention time.Duration) (*IdempotentResponse, error) {
+	k, err := database.ClaimIdempotencyKey(ctx, tx, key, requestHash, retention)
+	if err != nil {
+		return nil, ErrClaimIdempotencyKey.Wrap(err)
+	}
+	if k != nil {
+		return nil, nil
+	}
+
+	k, err = database.FindIdempotencyKey(ctx, tx, key)
+	if err != nil {
+		return nil, ErrClaimIdempotencyKey.Wrap(err)
+	}
+	if k == nil {
+		// the key has expired and been deleted in the meantime
+		return nil, ErrClaimIdempotencyKey.New("%q is in use, retry", key)
+	}
+	if k.RequestHash() != requestHash {
+		return nil, ErrClaimIdempotencyKey.Wrap(ErrIdempotencyKeyReused.New("%q", key))
+	}
+
+	rv := IdempotentResponse{
+		Status: k.Status(),
+		Body:   k.Response(),
+	}
+	if id := k.TransferID(); id != nil {
+		transferID := TransferIDFomDB(*id)
+		rv.TransferID = &transferID
+	}
+
+	return &rv, nil
+}
+
+func SaveIdempotentResponse(ctx context.Context, tx *sql.Tx, key string, resp *IdempotentResponse) error {
+	var transferID *database.TransferID
+	if resp.TransferID != nil {
+		id := resp.TransferID.ToDB()
+		transferID = &id
+	}
+
+	k, err := database.SaveIdempotentResponse(ctx, tx, key, resp.Status, resp.Body, transferID)
+	if err != nil {
+		return ErrSaveIdempotentResponse.Wrap(err)
+	}
+	if k == nil {
+		return ErrSaveIdempotentResponse.New("%q is not claimed", key)
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys returns the number of deleted keys.
+func DeleteExpiredIdempotencyKeys(ctx context.Context, q database.ContextExecutor) (int64, error) {
+	n, err := database.DeleteExpiredIdempotencyKeys(ctx, q)
+	if err != nil {
+		return 0, ErrDeleteExpiredIdempotencyKeys.Wrap(err)
+	}
+
+	return n, nil
+}
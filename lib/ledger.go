@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"context"
+	"strings"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrPostJournal       = errs.Class("post journal")
+	ErrUnbalancedJournal = errs.Class("unbalanced journal")
+)
+
+// Account is a ledger account. Wallets are accounts themselves, the other
+// accounts are the counterparts of money entering and leaving the wallets.
+type Account string
+
+const walletAccountPrefix = "wallet:"
+
+func WalletAccount(id WalletID) Account {
+	return Account(walletAccountPrefix + id.String())
+}
+
+// ExternalAccount holds the funds outside of the system, e.g. in banks.
+func ExternalAccount(currency Currency) Account {
+	return Account("external:" + currency.String())
+}
+
+// WithdrawalClearingAccount holds the funds of pending withdrawals.
+func WithdrawalClearingAccount(currency Currency) Account {
+	return Account("clearing:withdrawals:" + currency.String())
+}
+
+// FundingAccount is the source of administrative fundings.
+func FundingAccount(currency Currency) Account {
+	return Account("equity:funding:" + currency.String())
+}
+
+// AdjustmentAccount is the counterpart of reconciliation adjustments.
+func AdjustmentAccount(currency Currency) Account {
+	return Account("equity:adjustments:" + currency.String())
+}
+
+// FXAccount is the counterpart of currency conversions. Its balance in a
+// currency is the net amount of the currency sold by the system.
+func FXAccount(currency Currency) Account {
+	return Account("fx:" + currency.String())
+}
+
+// Wallet returns the wallet of a wallet account.
+func (a Account) Wallet() (WalletID, bool) {
+	s := string(a)
+	if !strings.HasPrefix(s, walletAccountPrefix) {
+		return 0, false
+	}
+
+	id, err := ParseWalletID(strings.TrimPrefix(s, walletAccountPrefix))
+	if err != nil {
+		return 0, false
+	}
+
+	return id, true
+}
+
+// Journal kinds.
+const (
+	JournalTransfer            = "transfer"
+	JournalReversal            = "reversal"
+	JournalFunding             = "funding"
+	JournalDeposit             = "deposit"
+	JournalWithdrawal          = "withdrawal"
+	JournalWithdrawalCompleted = "withdrawal_completed"
+	JournalWithdrawalFailed    = "withdrawal_failed"
+	JournalAdjustment          = "adjustment"
+)
+
+// Posting changes the balance of the account by a signed amount.
+type Posting struct {
+	Account  Account
+	Currency Currency
+	Amount   Decimal
+}
+
+// Journal is the set of postings of one operation. At most one of the
+// operation ids is set.
+type Journal struct {
+	Kind                string
+	Transfer            *TransferID
+	Funding             *FundingID
+	ExternalTransaction *ExternalTransactionID
+	Memo                string
+	Postings            []Posting
+}
+
+// Validate checks that the postings sum to zero in every currency.
+func (j *Journal) Validate() error {
+	if len(j.Postings) == 0 {
+		return ErrUnbalancedJournal.New("%s: no postings", j.Kind)
+	}
+
+	sums := make(map[string]Decimal)
+	for _, p := range j.Postings {
+		if p.Amount.IsZero() {
+			return ErrUnbalancedJournal.New("%s: zero posting to %s", j.Kind, p.Account)
+		}
+
+		code := p.Currency.String()
+		sums[code] = sums[code].Add(p.Amount)
+	}
+
+	for code, sum := range sums {
+		if !sum.IsZero() {
+			return ErrUnbalancedJournal.New("%s: postings in %s sum to %v", j.Kind, code, sum)
+		}
+	}
+
+	return nil
+}
+
+// postJournal writes the ledger entries of the journal and applies the
+// postings of wallet accounts to the cached wallet balances. It returns the
+// wallets as they are after the journal. The wallets must be locked by the
+// caller, a debit that exceeds the balance fails with ErrInsufficientFunds.
+func postJournal(ctx context.Context, q database.ContextRowQueryExecutor, j *Journal) (map[WalletID]*Wallet, error) {
+	return writeJournal(ctx, q, j, applyPosting)
+}
+
+// recordJournal writes the ledger entries of the journal without changing
+// the wallet balances. It is used for adjustments that explain a balance.
+func recordJournal(ctx context.Context, q database.ContextRowQueryExecutor, j *Journal) (map[WalletID]*Wallet, error) {
+	return writeJournal(ctx, q, j, findPostingWallet)
+}
+
+type postingFunc func(ctx context.Context, q database.ContextRowQueryExecutor, id WalletID, p Posting) (*Wallet, error)
+
+func writeJournal(ctx context.Context, q database.ContextRowQueryExecutor, j *Journal, post postingFunc) (map[WalletID]*Wallet, error) {
+	if err := j.Validate(); err != nil {
+		return nil, ErrPostJournal.Wrap(err)
+	}
+
+	params := database.CreateJournalParams{Kind: j.Kind, Memo: j.Memo}
+	if j.Transfer != nil {
+		id := j.Transfer.ToDB()
+		params.Transfer = &id
+	}
+	if j.Funding != nil {
+		id := database.FundingID(*j.Funding)
+		params.Funding = &id
+	}
+	if j.ExternalTransaction != nil {
+		id := j.ExternalTransaction.ToDB()
+		params.ExternalTransaction = &id
+	}
+
+	dj, err := database.CreateJournal(ctx, q, &params)
+	if err != nil {
+		return nil, ErrPostJournal.Wrap(err)
+	}
+
+	wallets := make(map[WalletID]*Wallet)
+	for _, p := range j.Postings {
+		entry := database.CreateLedgerEntryParams{
+			Journal:  dj.ID(),
+			Account:  string(p.Account),
+			Currency: p.Currency.ToDB(),
+			Amount:   p.Amount.ToDB(),
+		}
+
+		if id, ok := p.Account.Wallet(); ok {
+			w, err := post(ctx, q, id, p)
+			if err != nil {
+				return nil, ErrPostJournal.Wrap(err)
+			}
+
+			wallets[id] = w
+
+			walletID := id.ToDB()
+			balance := w.Balance.ToDB()
+			entry.Wallet = &walletID
+			entry.BalanceAfter = &balance
+		}
+
+		_, err = database.CreateLedgerEntry(ctx, q, &entry)
+		if err != nil {
+			return nil, ErrPostJournal.Wrap(err)
+		}
+	}
+
+	return wallets, nil
+}
+
+func applyPosting(ctx context.Context, q database.ContextRowQueryExecutor, id WalletID, p Posting) (*Wallet, error) {
+	var w *Wallet
+	var err error
+	if p.Amount.Sign() > 0 {
+		w, err = addFunds(ctx, q, id, p.Amount)
+	} else {
+		w, err = removeFunds(ctx, q, id, p.Amount.Neg())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return checkPostingWallet(w, id, p)
+}
+
+func findPostingWallet(ctx context.Context, q database.ContextRowQueryExecutor, id WalletID, p Posting) (*Wallet, error) {
+	w, err := FindWalletByID(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkPostingWallet(w, id, p)
+}
+
+func checkPostingWallet(w *Wallet, id WalletID, p Posting) (*Wallet, error) {
+	if w == nil {
+		return nil, ErrWalletDoesNotExist.New("%v", id)
+	}
+	if !w.Currency.Equals(p.Currency) {
+		return nil, ErrUnbalancedJournal.New("posting in %v to %v wallet %v", p.Currency, w.Currency, id)
+	}
+
+	return w, nil
+}
@@ -0,0 +1,80 @@
+package lib
+
+import "testing"
+
+func TestJournalValidate(t *testing.T) {
+	btc := newTestCurrency("BTC", 8, RoundHalfEven)
+	eth := newTestCurrency("ETH", 8, RoundHalfEven)
+	posting := func(a Account, c Currency, amount string) Posting {
+		return Posting{Account: a, Currency: c, Amount: mustDecimal(t, amount)}
+	}
+
+	tests := []struct {
+		name     string
+		postings []Posting
+		valid    bool
+	}{
+		{"no postings", nil, false},
+		{"balanced", []Posting{
+			posting(WalletAccount(1), btc, "-10"),
+			posting(WalletAccount(2), btc, "9.5"),
+			posting(WalletAccount(3), btc, "0.5"),
+		}, true},
+		{"unbalanced", []Posting{
+			posting(WalletAccount(1), btc, "-10"),
+			posting(WalletAccount(2), btc, "9.5"),
+		}, false},
+		{"zero posting", []Posting{
+			posting(WalletAccount(1), btc, "-1"),
+			posting(WalletAccount(2), btc, "1"),
+			posting(WalletAccount(3), btc, "0"),
+		}, false},
+		{"balanced per currency", []Posting{
+			posting(WalletAccount(1), btc, "-1"),
+			posting(FXAccount(btc), btc, "1"),
+			posting(FXAccount(eth), eth, "-20"),
+			posting(WalletAccount(2), eth, "20"),
+		}, true},
+		{"balanced only across currencies", []Posting{
+			posting(WalletAccount(1), btc, "-1"),
+			posting(WalletAccount(2), eth, "1"),
+		}, false},
+	}
+
+	for _, tt := range tests {
+		j := Journal{Kind: JournalTransfer, Postings: tt.postings}
+		err := j.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.valid && !ErrUnbalancedJournal.Has(err) {
+			t.Errorf("%s: want ErrUnbalancedJournal, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestAccountWallet(t *testing.T) {
+	btc := newTestCurrency("BTC", 8, RoundHalfEven)
+
+	tests := []struct {
+		account Account
+		id      WalletID
+		ok      bool
+	}{
+		{WalletAccount(42), 42, true},
+		{ExternalAccount(btc), 0, false},
+		{WithdrawalClearingAccount(btc), 0, false},
+		{FundingAccount(btc), 0, false},
+		{AdjustmentAccount(btc), 0, false},
+		{FXAccount(btc), 0, false},
+		{Account("wallet:"), 0, false},
+		{Account("wallet:abc"), 0, false},
+	}
+
+	for _, tt := range tests {
+		id, ok := tt.account.Wallet()
+		if id != tt.id || ok != tt.ok {
+			t.Errorf("%s: got (%v, %v), want (%v, %v)", tt.account, id, ok, tt.id, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrNewOwnerFromDB      = errs.Class("new owner from db")
+	ErrCreateOwner         = errs.Class("create owner")
+	ErrUpdateOwner         = errs.Class("update owner")
+	ErrDeleteOwner         = errs.Class("delete owner")
+	ErrFindOwnerByID       = errs.Class("find owner by id")
+	ErrFindAllOwners       = errs.Class("find all owners")
+	ErrFindOwnerWallets    = errs.Class("find owner wallets")
+	ErrInvalidOwner        = errs.Class("invalid owner")
+	ErrOwnerDoesNotExist   = errs.Class("owner does not exist")
+	ErrOwnerHasWallets     = errs.Class("owner has wallets")
+	ErrOwnerWalletCurrency = errs.Class("owner already has a wallet in currency")
+)
+
+const maxOwnerNameLength = 200
+
+type OwnerID database.OwnerID
+
+func (id OwnerID) String() string {
+	return id.ToDB().String()
+}
+
+func (id OwnerID) ToDB() database.OwnerID {
+	return database.OwnerID(id)
+}
+
+func ParseOwnerID(s string) (OwnerID, error) {
+	v, err := database.ParseID(s)
+	return OwnerID(v), err
+}
+
+// Owner holds wallets. Unless AllowMultipleWallets is set, an owner has at
+// most one wallet per currency.
+type Owner struct {
+	ID                   OwnerID
+	Name                 string
+	Metadata             map[string]string
+	AllowMultipleWallets bool
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+func NewOwnerFromDB(owner database.Owner) (*Owner, error) {
+	if owner == nil {
+		return nil, nil
+	}
+
+	var m map[string]string
+	if len(owner.Metadata()) > 0 {
+		err := json.Unmarshal(owner.Metadata(), &m)
+		if err != nil {
+			return nil, ErrNewOwnerFromDB.Wrap(err)
+		}
+	}
+
+	o := Owner{
+		ID:                   OwnerID(owner.ID()),
+		Name:                 owner.Name(),
+		Metadata:             m,
+		AllowMultipleWallets: owner.AllowMultipleWallets(),
+		CreatedAt:            owner.CreatedAt(),
+		UpdatedAt:            owner.UpdatedAt(),
+	}
+
+	return &o, nil
+}
+
+type CreateOwnerParams struct {
+	Name                 string
+	Metadata             map[string]string
+	AllowMultipleWallets bool
+}
+
+// UpdateOwnerParams changes the fields that are not nil.
+type UpdateOwnerParams struct {
+	Name                 *string
+	Metadata             map[string]string
+	AllowMultipleWallets *bool
+}
+
+// ownerToDB validates the fields of an owner.
+func ownerToDB(name string, metadata map[string]string, allowMultipleWallets bool) (*database.OwnerParams, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxOwnerNameLength {
+		return nil, ErrInvalidOwner.New("name: length must be 1 to %d", maxOwnerNameLength)
+	}
+	if err := validateMetadata(metadata); err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	m, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &database.OwnerParams{Name: name, Metadata: m, AllowMultipleWallets: allowMultipleWallets}, nil
+}
+
+func CreateOwner(ctx context.Context, q database.ContextRowQuerier, params *CreateOwnerParams) (*Owner, error) {
+	p, err := ownerToDB(params.Name, params.Metadata, params.AllowMultipleWallets)
+	if err != nil {
+		return nil, ErrCreateOwner.Wrap(err)
+	}
+
+	o, err := database.CreateOwner(ctx, q, p)
+	if err != nil {
+		return nil, ErrCreateOwner.Wrap(err)
+	}
+
+	rv, err := NewOwnerFromDB(o)
+	if err != nil {
+		return nil, ErrCreateOwner.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// UpdateOwner changes the owner. Multiple wallets per currency cannot be
+// disallowed while the owner still has them.
+func UpdateOwner(ctx context.Context, db *sql.DB, id OwnerID, params *UpdateOwnerParams) (*Owner, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrUpdateOwner.Wrap(err)
+	}
+
+	o, err := updateOwner(ctx, tx, id, params)
+	if err != nil {
+		return nil, ErrUpdateOwner.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, ErrUpdateOwner.Wrap(err)
+	}
+
+	return o, nil
+}
+
+func updateOwner(ctx context.Context, tx *sql.Tx, id OwnerID, params *UpdateOwnerParams) (*Owner, error) {
+	o, err := lockOwner(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	name, metadata, allowMultiple := o.Name, o.Metadata, o.AllowMultipleWallets
+	if params.Name != nil {
+		name = *params.Name
+	}
+	if params.Metadata != nil {
+		metadata = params.Metadata
+	}
+	if params.AllowMultipleWallets != nil {
+		allowMultiple = *params.AllowMultipleWallets
+	}
+
+	if o.AllowMultipleWallets && !allowMultiple {
+		many, err := database.OwnerHasManyInCurrency(ctx, tx, id.ToDB())
+		if err != nil {
+			return nil, err
+		}
+		if many {
+			return nil, ErrInvalidOwner.New("%v has several wallets in a currency", id)
+		}
+	}
+
+	p, err := ownerToDB(name, metadata, allowMultiple)
+	if err != nil {
+		return nil, err
+	}
+
+	dbo, err := database.UpdateOwner(ctx, tx, id.ToDB(), p)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOwnerFromDB(dbo)
+}
+
+// DeleteOwner deletes an owner without wallets.
+func DeleteOwner(ctx context.Context, db *sql.DB, id OwnerID) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return ErrDeleteOwner.Wrap(err)
+	}
+
+	err = deleteOwner(ctx, tx, id)
+	if err != nil {
+		return ErrDeleteOwner.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	return ErrDeleteOwner.Wrap(tx.Commit())
+}
+
+func deleteOwner(ctx context.Context, tx *sql.Tx, id OwnerID) error {
+	if _, err := lockOwner(ctx, tx, id); err != nil {
+		return err
+	}
+
+	n, err := database.CountOwnerWallets(ctx, tx, id.ToDB(), "")
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrOwnerHasWallets.New("%v has %d wallets", id, n)
+	}
+
+	_, err = database.DeleteOwner(ctx, tx, id.ToDB())
+
+	return err
+}
+
+func FindOwnerByID(ctx context.Context, q database.ContextRowQuerier, id OwnerID) (*Owner, error) {
+	o, err := database.FindOwnerByID(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindOwnerByID.Wrap(err)
+	}
+
+	rv, err := NewOwnerFromDB(o)
+	if err != nil {
+		return nil, ErrFindOwnerByID.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+func FindAllOwners(ctx context.Context, q database.ContextQuerier) ([]*Owner, error) {
+	owners, err := database.FindAllOwners(ctx, q)
+	if err != nil {
+		return nil, ErrFindAllOwners.Wrap(err)
+	}
+
+	rv := make([]*Owner, len(owners))
+	for i, o := range owners {
+		rv[i], err = NewOwnerFromDB(o)
+		if err != nil {
+			return nil, ErrFindAllOwners.Wrap(err)
+		}
+	}
+
+	return rv, nil
+}
+
+// CurrencyTotal sums the wallets of an owner in one currency.
+type CurrencyTotal struct {
+	Currency  Currency
+	Wallets   int
+	Balance   Decimal
+	Available Decimal
+}
+
+// OwnerWallets are the wallets of an owner ordered by currency, with a total
+// per currency in the same order.
+type OwnerWallets struct {
+	Owner   *Owner
+	Wallets []*Wallet
+	Totals  []*CurrencyTotal
+}
+
+// FindOwnerWallets returns nil if the owner does not exist.
+func FindOwnerWallets(ctx context.Context, q database.ContextQueryExecutor, id OwnerID) (*OwnerWallets, error) {
+	o, err := FindOwnerByID(ctx, q, id)
+	if err != nil {
+		return nil, ErrFindOwnerWallets.Wrap(err)
+	}
+	if o == nil {
+		return nil, nil
+	}
+
+	ws, err := database.FindOwnerWallets(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindOwnerWallets.Wrap(err)
+	}
+
+	rv := OwnerWallets{Owner: o, Wallets: make([]*Wallet, len(ws))}
+
+	var total *CurrencyTotal
+	for i, dw := range ws {
+		w, err := NewWalletFromDB(dw)
+		if err != nil {
+			return nil, ErrFindOwnerWallets.Wrap(err)
+		}
+
+		rv.Wallets[i] = w
+
+		if total == nil || !total.Currency.Equals(w.Currency) {
+			total = &CurrencyTotal{Currency: w.Currency}
+			rv.Totals = append(rv.Totals, total)
+		}
+
+		total.Wallets++
+		total.Balance = total.Balance.Add(w.Balance)
+		total.Available = total.Available.Add(w.Available())
+	}
+
+	return &rv, nil
+}
+
+func lockOwner(ctx context.Context, tx *sql.Tx, id OwnerID) (*Owner, error) {
+	o, err := database.LockOwnerByID(ctx, tx, id.ToDB())
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, ErrOwnerDoesNotExist.New("%v", id)
+	}
+
+	return NewOwnerFromDB(o)
+}
+
+// checkOwnerCanHoldWallet locks the owner and checks it can have one more
+// wallet in the currency.
+func checkOwnerCanHoldWallet(ctx context.Context, tx *sql.Tx, id OwnerID, currency Currency) error {
+	o, err := lockOwner(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if o.AllowMultipleWallets {
+		return nil
+	}
+
+	n, err := database.CountOwnerWallets(ctx, tx, id.ToDB(), currency.ToDB())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrOwnerWalletCurrency.New("%v already has a %v wallet", id, currency)
+	}
+
+	return nil
+}
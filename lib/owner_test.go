@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOwnerToDB(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     string
+		valid    bool
+	}{
+		{"Alice", nil, "Alice", true},
+		{"  Alice\t", nil, "Alice", true},
+		{strings.Repeat("ж", maxOwnerNameLength), nil, strings.Repeat("ж", maxOwnerNameLength), true},
+		{"", nil, "", false},
+		{"   ", nil, "", false},
+		{strings.Repeat("a", maxOwnerNameLength+1), nil, "", false},
+		{"Alice", map[string]string{"": "v"}, "", false},
+	}
+
+	for _, tt := range tests {
+		p, err := ownerToDB(tt.name, tt.metadata, false)
+		if !tt.valid {
+			if !ErrInvalidOwner.Has(err) && !ErrInvalidMetadata.Has(err) {
+				t.Errorf("%q: want an invalid owner, got %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.name, err)
+			continue
+		}
+
+		if p.Name != tt.want {
+			t.Errorf("%q: name %q, want %q", tt.name, p.Name, tt.want)
+		}
+		if string(p.Metadata) != "{}" {
+			t.Errorf("%q: metadata %s, want {}", tt.name, p.Metadata)
+		}
+	}
+}
+
+func TestOwnerWalletPerCurrency(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc, eth := testCurrency(t, "BTC"), testCurrency(t, "ETH")
+
+	tests := []struct {
+		allowMultipleWallets bool
+		currencies           []Currency
+		ok                   bool
+	}{
+		{false, []Currency{btc, eth}, true},
+		{false, []Currency{btc, btc}, false},
+		{true, []Currency{btc, btc}, true},
+	}
+
+	for _, tt := range tests {
+		o, err := CreateOwner(ctx, db, &CreateOwnerParams{Name: "test", AllowMultipleWallets: tt.allowMultipleWallets})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range tt.currencies {
+			_, err = CreateWallet(ctx, db, &CreateWalletParams{Currency: c, Owner: &o.ID})
+			if err != nil {
+				break
+			}
+		}
+
+		if tt.ok && err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt, err)
+		}
+		if !tt.ok && !ErrOwnerWalletCurrency.Has(err) {
+			t.Errorf("%+v: want ErrOwnerWalletCurrency, got %v", tt, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrReconcile         = errs.Class("reconcile")
+	ErrAdjustBalance     = errs.Class("adjust balance")
+	ErrCannotAdjust      = errs.Class("cannot adjust")
+	ErrNewBalancesFromDB = errs.Class("new balances from db")
+)
+
+// Discrepancy is a wallet whose stored balance is not explained by its
+// history or by its ledger entries.
+type Discrepancy struct {
+	Wallet   WalletID
+	Currency Currency
+	// Stored is the cached balance of the wallet.
+	Stored Decimal
+	// Expected is recomputed from fundings, transfers, deposits, withdrawals
+	// and adjustments.
+	Expected Decimal
+	// Ledger is the sum of the ledger entries of the wallet.
+	Ledger Decimal
+}
+
+// Difference is the part of the stored balance the history does not explain.
+func (d *Discrepancy) Difference() Decimal {
+	return d.Stored.Sub(d.Expected)
+}
+
+// Adjustable reports whether an adjustment entry brings the history and the
+// ledger in line with the stored balance. It is not the case if the ledger
+// does not match the history.
+func (d *Discrepancy) Adjustable() bool {
+	return d.Ledger.Equal(d.Expected)
+}
+
+func newDiscrepancyFromDB(b database.WalletBalances) (*Discrepancy, error) {
+	c, err := NewCurrency(b.Currency())
+	if err != nil {
+		return nil, ErrNewBalancesFromDB.Wrap(err)
+	}
+
+	stored, err := NewDecimalFromDB(b.Stored())
+	if err != nil {
+		return nil, ErrNewBalancesFromDB.Wrap(err)
+	}
+
+	expected, err := NewDecimalFromDB(b.Expected())
+	if err != nil {
+		return nil, ErrNewBalancesFromDB.Wrap(err)
+	}
+
+	ledger, err := NewDecimalFromDB(b.Ledger())
+	if err != nil {
+		return nil, ErrNewBalancesFromDB.Wrap(err)
+	}
+
+	d := Discrepancy{
+		Wallet:   WalletIDFromDB(b.Wallet()),
+		Currency: c,
+		Stored:   stored,
+		Expected: expected,
+		Ledger:   ledger,
+	}
+
+	return &d, nil
+}
+
+// Reconcile returns the wallets whose stored balance, expected balance and
+// ledger balance differ. All wallets are checked if ids is empty.
+func Reconcile(ctx context.Context, q database.ContextQuerier, ids ...WalletID) ([]*Discrepancy, error) {
+	dbIDs := make([]database.WalletID, len(ids))
+	for i := range ids {
+		dbIDs[i] = ids[i].ToDB()
+	}
+
+	bs, err := database.FindWalletBalances(ctx, q, dbIDs)
+	if err != nil {
+		return nil, ErrReconcile.Wrap(err)
+	}
+
+	var rv []*Discrepancy
+	for _, b := range bs {
+		d, err := newDiscrepancyFromDB(b)
+		if err != nil {
+			return nil, ErrReconcile.Wrap(err)
+		}
+
+		if !d.Stored.Equal(d.Expected) || !d.Ledger.Equal(d.Expected) {
+			rv = append(rv, d)
+		}
+	}
+
+	return rv, nil
+}
+
+// AdjustBalance records an adjustment that explains the difference between
+// the stored and the expected balance of the wallet. The stored balance is
+// left as is. It returns the discrepancy that was adjusted or nil if there
+// was none.
+func AdjustBalance(ctx context.Context, db *sql.DB, wallet WalletID, memo string) (*Discrepancy, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrAdjustBalance.Wrap(err)
+	}
+
+	d, err := adjustBalance(ctx, tx, wallet, memo)
+	if err != nil {
+		return nil, ErrAdjustBalance.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, ErrAdjustBalance.Wrap(err)
+	}
+
+	return d, nil
+}
+
+func adjustBalance(ctx context.Context, tx *sql.Tx, wallet WalletID, memo string) (*Discrepancy, error) {
+	// the lock keeps the balances from changing between the check and the adjustment
+	_, err := LockManyWalletsByIDs(ctx, tx, []WalletID{wallet})
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := Reconcile(ctx, tx, wallet)
+	if err != nil {
+		return nil, err
+	}
+	if len(ds) == 0 {
+		return nil, nil
+	}
+
+	d := ds[0]
+	if !d.Adjustable() {
+		return nil, ErrCannotAdjust.New("wallet %v: ledger %v does not match history %v", wallet, d.Ledger, d.Expected)
+	}
+
+	diff := d.Difference()
+	_, err = recordJournal(ctx, tx, &Journal{
+		Kind: JournalAdjustment,
+		Memo: memo,
+		Postings: []Posting{
+			{Account: WalletAccount(wallet), Currency: d.Currency, Amount: diff},
+			{Account: AdjustmentAccount(d.Currency), Currency: d.Currency, Amount: diff.Neg()},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiscrepancy(t *testing.T) {
+	tests := []struct {
+		stored, expected, ledger string
+		difference               string
+		adjustable               bool
+	}{
+		{"10", "9", "9", "1", true},
+		{"9", "10", "10", "-1", true},
+		{"10", "10", "9", "0", false},
+		{"10", "9", "10", "1", false},
+	}
+
+	for _, tt := range tests {
+		d := Discrepancy{
+			Stored:   mustDecimal(t, tt.stored),
+			Expected: mustDecimal(t, tt.expected),
+			Ledger:   mustDecimal(t, tt.ledger),
+		}
+		if got := d.Difference(); !got.Equal(mustDecimal(t, tt.difference)) {
+			t.Errorf("%+v: difference %v, want %s", tt, got, tt.difference)
+		}
+		if got := d.Adjustable(); got != tt.adjustable {
+			t.Errorf("%+v: adjustable %v, want %v", tt, got, tt.adjustable)
+		}
+	}
+}
+
+func TestAdjustBalance(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	w := createTestWallet(t, db, testCurrency(t, "BTC"), mustDecimal(t, "10"))
+
+	// the stored balance drifts from the history
+	_, err := db.ExecContext(ctx, `update wallets set balance = balance + 1 where id = $1`, int64(w.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := Reconcile(ctx, db, w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || !ds[0].Difference().Equal(mustDecimal(t, "1")) {
+		t.Fatalf("want a discrepancy of 1, got %+v", ds)
+	}
+
+	d, err := AdjustBalance(ctx, db, w.ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil || !d.Difference().Equal(mustDecimal(t, "1")) {
+		t.Fatalf("want an adjustment of 1, got %+v", d)
+	}
+
+	ds, err = Reconcile(ctx, db, w.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 0 {
+		t.Errorf("want no discrepancies after the adjustment, got %+v", ds)
+	}
+
+	d, err = AdjustBalance(ctx, db, w.ID, "test")
+	if err != nil || d != nil {
+		t.Errorf("want nothing to adjust, got %+v, %v", d, err)
+	}
+}
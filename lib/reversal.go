@@ -0,0 +1,286 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrReverseTransfer         = errs.Class("reverse transfer")
+	ErrFindReversals           = errs.Class("find reversals")
+	ErrInvalidReversal         = errs.Class("invalid reversal")
+	ErrReversalExceedsTransfer = errs.Class("reversal exceeds transfer")
+	ErrTransferDoesNotExist    = errs.Class("transfer does not exist")
+	ErrInvalidFeeRefundRule    = errs.Class("invalid fee refund rule")
+)
+
+// maxReversalReasonLength limits the reason stored as the memo of the journal.
+const maxReversalReasonLength = 500
+
+// FeeRefundRule decides which part of the fee of a transfer is returned to
+// the sender when the transfer is reversed. The refund is taken from the fee
+// wallet that collected the fee.
+type FeeRefundRule int
+
+const (
+	// FeeRefundNone keeps the whole fee.
+	FeeRefundNone FeeRefundRule = iota
+	// FeeRefundProportional refunds the share of the fee matching the share
+	// of the amount reversed so far. The reversal that completes the
+	// transfer refunds whatever is left of the fee.
+	FeeRefundProportional
+	// FeeRefundFull refunds the whole fee with the first reversal.
+	FeeRefundFull
+)
+
+func (r FeeRefundRule) String() string {
+	switch r {
+	case FeeRefundNone:
+		return "none"
+	case FeeRefundProportional:
+		return "proportional"
+	case FeeRefundFull:
+		return "full"
+	}
+
+	return "FeeRefundRule(" + strconv.Itoa(int(r)) + ")"
+}
+
+func ParseFeeRefundRule(s string) (FeeRefundRule, error) {
+	for _, r := range []FeeRefundRule{FeeRefundNone, FeeRefundProportional, FeeRefundFull} {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+
+	return 0, ErrInvalidFeeRefundRule.New("%q", s)
+}
+
+// refund returns the fee to refund with a reversal of amount. reversed and
+// refunded are the sums of the previous reversals of the transfer.
+func (r FeeRefundRule) refund(t *Transfer, reversed, refunded, amount Decimal, currency Currency) Decimal {
+	if t.FeeWallet == nil {
+		// the fee was not collected by a wallet, there is nothing to take it from
+		return Decimal{}
+	}
+
+	left := t.FeeAmount.Sub(refunded)
+
+	var rv Decimal
+	switch r {
+	case FeeRefundFull:
+		rv = left
+	case FeeRefundProportional:
+		total := reversed.Add(amount)
+		if total.Equal(t.Amount) {
+			rv = left
+			break
+		}
+
+		// the share is computed over the total reversed so far, so rounding
+		// errors of the previous reversals do not add up
+		due := t.FeeAmount.Mul(total).Div(t.Amount, currency.Scale(), RoundDown)
+		rv = due.Sub(refunded)
+	}
+
+	// the rule may have changed since the previous reversals
+	if rv.Sign() < 0 {
+		return Decimal{}
+	}
+
+	return rv
+}
+
+type ReverseTransferParams struct {
+	Transfer TransferID
+	// Amount defaults to the part of the transfer that is not reversed yet.
+	Amount *Decimal
+	// Reason is kept as the memo of the journal.
+	Reason    string
+	FeeRefund FeeRefundRule
+	// Status applies to the reversal as to a transfer from the receiver of
+	// the original transfer to its sender.
+	Status WalletStatusRule
+}
+
+// ReverseTransfer moves the amount of the transfer, fully or partially, back
+// from its receiver to its sender with a new transfer linked to the original
+// one. The reversals of a transfer can never exceed its amount, and a
+// reversal cannot be reversed.
+func ReverseTransfer(ctx context.Context, db *sql.DB, params *ReverseTransferParams) (*Transfer, error) {
+	if len(params.Reason) > maxReversalReasonLength {
+		return nil, ErrReverseTransfer.Wrap(ErrInvalidReversal.New("reason is longer than %d", maxReversalReasonLength))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrReverseTransfer.Wrap(err)
+	}
+
+	t, err := reverseTransfer(ctx, tx, params)
+	if err != nil {
+		return nil, ErrReverseTransfer.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, ErrReverseTransfer.Wrap(err)
+	}
+
+	return t, nil
+}
+
+func reverseTransfer(ctx context.Context, tx *sql.Tx, params *ReverseTransferParams) (*Transfer, error) {
+	// transfers never change, so the original is read before the locks
+	original, err := FindTransferByID(ctx, tx, params.Transfer)
+	if err != nil {
+		return nil, err
+	}
+	if original == nil {
+		return nil, ErrTransferDoesNotExist.New("%v", params.Transfer)
+	}
+	if original.ReversalOf != nil {
+		return nil, ErrInvalidReversal.New("%v is a reversal of %v", original.ID, *original.ReversalOf)
+	}
+	if original.ExchangeRate != nil {
+		// the rate of the reversal would have to be chosen, and the spread
+		// refunded or not, neither of which is supported yet
+		return nil, ErrInvalidReversal.New("%v converted currencies", original.ID)
+	}
+
+	ids := []WalletID{original.From, original.To}
+	if original.FeeWallet != nil {
+		ids = append(ids, *original.FeeWallet)
+	}
+
+	// every reversal of the transfer locks the same wallets, so the sums of
+	// the previous reversals cannot change until the transaction ends
+	ws, err := LockManyWalletsByIDs(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := ws[original.To], ws[original.From]
+	if from == nil || to == nil {
+		return nil, ErrWalletDoesNotExist.New("transfer %v", original.ID)
+	}
+	if err := params.Status.checkTransfer(from, to); err != nil {
+		return nil, err
+	}
+
+	previous, err := FindReversalsOf(ctx, tx, original.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reversed, refunded Decimal
+	for _, r := range previous {
+		reversed = reversed.Add(r.Amount)
+		refunded = refunded.Add(r.FeeRefund)
+	}
+
+	left := original.Amount.Sub(reversed)
+	amount := left
+	if params.Amount != nil {
+		amount = *params.Amount
+	}
+	if amount.Sign() <= 0 {
+		if left.Sign() <= 0 {
+			return nil, ErrReversalExceedsTransfer.New("%v is fully reversed", original.ID)
+		}
+
+		return nil, ErrInvalidReversal.New("cannot reverse: %v", amount)
+	}
+	if left.Less(amount) {
+		return nil, ErrReversalExceedsTransfer.New("%v: only %v of %v is left to reverse", original.ID, left, original.Amount)
+	}
+
+	currency := from.Currency
+	if err := currency.ValidateAmount(amount); err != nil {
+		return nil, err
+	}
+	if from.Available().Less(amount) {
+		return nil, ErrInsufficientFunds
+	}
+
+	feeRefund := params.FeeRefund.refund(original, reversed, refunded, amount, currency)
+
+	var feeWallet *Wallet
+	if feeRefund.Sign() > 0 {
+		if feeWallet = ws[*original.FeeWallet]; feeWallet == nil {
+			return nil, ErrWalletDoesNotExist.New("%v", *original.FeeWallet)
+		}
+		if feeWallet.Available().Less(feeRefund) {
+			return nil, ErrInsufficientFunds
+		}
+	}
+
+	originalID := original.ID.ToDB()
+	createParams := database.CreateTransactionParams{
+		From:       from.ID.ToDB(),
+		To:         to.ID.ToDB(),
+		Amount:     amount.ToDB(),
+		FeeAmount:  Decimal{}.ToDB(),
+		ReversalOf: &originalID,
+		FeeRefund:  feeRefund.ToDB(),
+		FXSpread:   Decimal{}.ToDB(),
+	}
+	if feeWallet != nil {
+		id := feeWallet.ID.ToDB()
+		createParams.FeeWallet = &id
+	}
+
+	c, err := database.CreateTransaction(ctx, tx, &createParams)
+	if err != nil {
+		return nil, constraintError(err)
+	}
+
+	reversal, err := NewTransferFromDB(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// the postings are in the order of a transfer journal: sender, receiver, fee wallet
+	journal := Journal{
+		Kind:     JournalReversal,
+		Transfer: &reversal.ID,
+		Memo:     params.Reason,
+		Postings: []Posting{
+			{Account: WalletAccount(from.ID), Currency: currency, Amount: amount.Neg()},
+			{Account: WalletAccount(to.ID), Currency: currency, Amount: amount.Add(feeRefund)},
+		},
+	}
+	if feeWallet != nil {
+		journal.Postings = append(journal.Postings,
+			Posting{Account: WalletAccount(feeWallet.ID), Currency: currency, Amount: feeRefund.Neg()})
+	}
+
+	_, err = postJournal(ctx, tx, &journal)
+	if err != nil {
+		return nil, err
+	}
+
+	return reversal, nil
+}
+
+// FindReversalsOf returns the reversals of the transfer from the oldest.
+func FindReversalsOf(ctx context.Context, q database.ContextQuerier, id TransferID) ([]*Transfer, error) {
+	ts, err := database.FindReversalsOf(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindReversals.Wrap(err)
+	}
+
+	rv := make([]*Transfer, len(ts))
+	for i, t := range ts {
+		rv[i], err = NewTransferFromDB(t)
+		if err != nil {
+			return nil, ErrFindReversals.Wrap(err)
+		}
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,60 @@
+package lib
+
+import "testing"
+
+func TestParseFeeRefundRule(t *testing.T) {
+	for _, r := range []FeeRefundRule{FeeRefundNone, FeeRefundProportional, FeeRefundFull} {
+		got, err := ParseFeeRefundRule(r.String())
+		if err != nil || got != r {
+			t.Errorf("%v: got %v, %v", r, got, err)
+		}
+	}
+
+	for _, s := range []string{"", "Full", "partial", "FeeRefundRule(3)"} {
+		if _, err := ParseFeeRefundRule(s); !ErrInvalidFeeRefundRule.Has(err) {
+			t.Errorf("%q: want ErrInvalidFeeRefundRule, got %v", s, err)
+		}
+	}
+}
+
+func TestFeeRefundRuleRefund(t *testing.T) {
+	c := newTestCurrency("TST", 2, RoundHalfEven)
+	feeWallet := WalletID(3)
+
+	tests := []struct {
+		name                       string
+		rule                       FeeRefundRule
+		transferAmount, fee        string
+		noFeeWallet                bool
+		reversed, refunded, amount string
+		want                       string
+	}{
+		{"none", FeeRefundNone, "10", "1", false, "0", "0", "10", "0"},
+		{"full", FeeRefundFull, "10", "1", false, "0", "0", "3", "1"},
+		{"full already refunded", FeeRefundFull, "10", "1", false, "3", "1", "3", "0"},
+		{"proportional first", FeeRefundProportional, "10", "1", false, "0", "0", "3", "0.3"},
+		{"proportional second", FeeRefundProportional, "10", "1", false, "3", "0.3", "3", "0.3"},
+		{"proportional last", FeeRefundProportional, "10", "1", false, "6", "0.6", "4", "0.4"},
+		{"proportional rounds down", FeeRefundProportional, "10", "0.1", false, "0", "0", "3.33", "0.03"},
+		{"proportional does not drift", FeeRefundProportional, "10", "0.1", false, "3.33", "0.03", "3.33", "0.03"},
+		{"proportional last takes the rest", FeeRefundProportional, "10", "0.1", false, "6.66", "0.06", "3.34", "0.04"},
+		{"rule changed after full refund", FeeRefundProportional, "10", "1", false, "3", "1", "3", "0"},
+		{"no fee wallet", FeeRefundFull, "10", "1", true, "0", "0", "10", "0"},
+	}
+
+	for _, tt := range tests {
+		transfer := Transfer{
+			Amount:    mustDecimal(t, tt.transferAmount),
+			FeeAmount: mustDecimal(t, tt.fee),
+			FeeWallet: &feeWallet,
+		}
+		if tt.noFeeWallet {
+			transfer.FeeWallet = nil
+		}
+
+		got := tt.rule.refund(&transfer, mustDecimal(t, tt.reversed), mustDecimal(t, tt.refunded), mustDecimal(t, tt.amount), c)
+		if !got.Equal(mustDecimal(t, tt.want)) {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
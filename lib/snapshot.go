@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrBalanceAt            = errs.Class("balance at")
+	ErrTakeBalanceSnapshots = errs.Class("take balance snapshots")
+	ErrSnapshotTooRecent    = errs.Class("snapshot too recent")
+)
+
+// SnapshotDelay is how old a snapshot time must be. Ledger entries are stamped
+// with the start time of their transaction, so an entry stamped before a
+// recent time may not be committed yet.
+const SnapshotDelay = time.Hour
+
+// BalanceAt returns the balance of the wallet at the given time. It returns
+// nil if the wallet does not exist.
+func BalanceAt(ctx context.Context, q database.ContextRowQuerier, wallet WalletID, at time.Time) (*Decimal, error) {
+	b, err := database.FindBalanceAt(ctx, q, wallet.ToDB(), at)
+	if err != nil {
+		return nil, ErrBalanceAt.Wrap(err)
+	}
+	if b == nil {
+		return nil, nil
+	}
+
+	d, err := NewDecimalFromDB(*b)
+	if err != nil {
+		return nil, ErrBalanceAt.Wrap(err)
+	}
+
+	return &d, nil
+}
+
+// TakeBalanceSnapshots snapshots the balances of all wallets at the given
+// time, which must be at least SnapshotDelay ago. Taking the snapshots at the
+// same time again does nothing. It returns the number of snapshots taken.
+func TakeBalanceSnapshots(ctx context.Context, q database.ContextExecutor, at time.Time) (int64, error) {
+	if time.Since(at) < SnapshotDelay {
+		return 0, ErrTakeBalanceSnapshots.Wrap(ErrSnapshotTooRecent.New("%v", at))
+	}
+
+	n, err := database.CreateBalanceSnapshots(ctx, q, at)
+	if err != nil {
+		return 0, ErrTakeBalanceSnapshots.Wrap(err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// ledgerBalanceAt sums the ledger entries of the wallet, the definition of
+// the balance at a time that BalanceAt must match.
+func ledgerBalanceAt(t *testing.T, db *sql.DB, wallet WalletID, at time.Time) Decimal {
+	t.Helper()
+
+	var s string
+	err := db.QueryRow(`
+	select coalesce(sum(amount), 0)::text from ledger_entries where wallet = $1 and created_at <= $2`,
+		int64(wallet), at).Scan(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mustDecimal(t, s)
+}
+
+// backdateLedgerEntries moves the entries of the wallet with the given
+// amount back in time. Snapshots can only be taken SnapshotDelay ago.
+func backdateLedgerEntries(t *testing.T, db *sql.DB, wallet WalletID, amount string, at time.Time) {
+	t.Helper()
+
+	_, err := db.Exec(`update ledger_entries set created_at = $3 where wallet = $1 and amount = $2`,
+		int64(wallet), amount, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBalanceAt(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc := testCurrency(t, "BTC")
+	w := createTestWallet(t, db, btc, mustDecimal(t, "10"))
+	to := createTestWallet(t, db, btc, Decimal{})
+
+	_, err := transferInTx(ctx, db, &TransferFundsParams{
+		From:   w.ID,
+		To:     to.ID,
+		Amount: mustDecimal(t, "3"),
+		Fee:    NewFlatFee("test", Decimal{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Microsecond)
+	snapshotAt := now.Add(-4 * time.Hour)
+	backdateLedgerEntries(t, db, w.ID, "10", now.Add(-5*time.Hour))
+	backdateLedgerEntries(t, db, w.ID, "-3", now.Add(-3*time.Hour))
+
+	if _, err := TakeBalanceSnapshots(ctx, db, snapshotAt); err != nil {
+		t.Fatal(err)
+	}
+
+	times := []time.Time{
+		now.Add(-6 * time.Hour),
+		now.Add(-5 * time.Hour),
+		snapshotAt,
+		now.Add(-3*time.Hour - time.Minute),
+		now.Add(-3 * time.Hour),
+		now,
+	}
+	for _, at := range times {
+		got, err := BalanceAt(ctx, db, w.ID, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := ledgerBalanceAt(t, db, w.ID, at); got == nil || !got.Equal(want) {
+			t.Errorf("at %v: got %v, want %v", now.Sub(at), got, want)
+		}
+	}
+
+	// The lookup starts from the snapshot instead of summing the history, so
+	// a changed snapshot shows in the balances after it but not before it.
+	_, err = db.Exec(`update balance_snapshots set balance = balance + 100 where wallet = $1 and at = $2`, int64(w.ID), snapshotAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{now.Add(-5 * time.Hour), "10"},
+		{snapshotAt, "110"},
+		{now, "107"},
+	}
+	for _, tt := range tests {
+		got, err := BalanceAt(ctx, db, w.ID, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || !got.Equal(mustDecimal(t, tt.want)) {
+			t.Errorf("at %v: got %v, want %s", now.Sub(tt.at), got, tt.want)
+		}
+	}
+
+	if got, err := BalanceAt(ctx, db, 0, now); err != nil || got != nil {
+		t.Errorf("wallet that does not exist: got %v, %v", got, err)
+	}
+}
+
+func TestTakeBalanceSnapshotsTwice(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	w := createTestWallet(t, db, testCurrency(t, "BTC"), mustDecimal(t, "10"))
+
+	now := time.Now().Truncate(time.Microsecond)
+	backdateLedgerEntries(t, db, w.ID, "10", now.Add(-3*time.Hour))
+
+	// a time no other test uses, so the first run has to take the snapshot of w
+	at := now.Add(-2*time.Hour + time.Duration(w.ID)*time.Microsecond)
+
+	n, err := TakeBalanceSnapshots(ctx, db, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("the first run took no snapshots")
+	}
+
+	n, err = TakeBalanceSnapshots(ctx, db, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("the second run took %d snapshots, want 0", n)
+	}
+
+	var count int
+	err = db.QueryRow(`select count(*) from balance_snapshots where wallet = $1`, int64(w.ID)).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("wallet has %d snapshots, want 1", count)
+	}
+}
+
+func TestTakeBalanceSnapshotsTooRecent(t *testing.T) {
+	for _, at := range []time.Time{time.Now(), time.Now().Add(-SnapshotDelay + time.Minute), time.Now().Add(time.Hour)} {
+		// the time is checked before the database is used
+		_, err := TakeBalanceSnapshots(context.Background(), nil, at)
+		if !ErrSnapshotTooRecent.Has(err) {
+			t.Errorf("%v: want ErrSnapshotTooRecent, got %v", at, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var ErrWriteStatement = errs.Class("write statement")
+
+// StatementEntry is a change of the wallet balance. Type is "out", "in" or
+// "fee" for transfers and the journal kind otherwise, e.g. "deposit".
+type StatementEntry struct {
+	ID           database.LedgerEntryID
+	Time         time.Time
+	Type         string
+	Transfer     *TransferID
+	Counterparty *WalletID
+	Reference    string
+	// Amount excludes Fee, the balance changes by Amount - Fee.
+	Amount  Decimal
+	Fee     Decimal
+	Balance Decimal
+}
+
+// StatementWriter receives the opening balance, the entries from the oldest
+// and the closing balance.
+type StatementWriter interface {
+	Opening(balance Decimal) error
+	Entry(entry *StatementEntry) error
+	Closing(balance Decimal) error
+}
+
+// WriteStatement writes the statement of the wallet for (from, to], that is
+// the opening balance at from, the entries created after from and up to to
+// with the running balance and the closing balance at to. The entries are
+// passed to w as they are read. All of it is read from one snapshot of the
+// database.
+func WriteStatement(ctx context.Context, db *sql.DB, wallet WalletID, from, to time.Time, w StatementWriter) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return ErrWriteStatement.Wrap(err)
+	}
+
+	err = writeStatement(ctx, tx, wallet, from, to, w)
+	if err != nil {
+		return ErrWriteStatement.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ErrWriteStatement.Wrap(err)
+	}
+
+	return nil
+}
+
+func writeStatement(ctx context.Context, tx *sql.Tx, wallet WalletID, from, to time.Time, w StatementWriter) error {
+	opening, err := BalanceAt(ctx, tx, wallet, from)
+	if err != nil {
+		return err
+	}
+	if opening == nil {
+		return ErrWalletDoesNotExist.New("%v", wallet)
+	}
+
+	err = w.Opening(*opening)
+	if err != nil {
+		return err
+	}
+
+	balance := *opening
+	err = database.ForEachStatementEntry(ctx, tx, wallet.ToDB(), from, to, func(e database.StatementEntry) error {
+		entry, err := newStatementEntryFromDB(e)
+		if err != nil {
+			return err
+		}
+
+		balance = balance.Add(entry.Amount).Sub(entry.Fee)
+		entry.Balance = balance
+
+		return w.Entry(entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Closing(balance)
+}
+
+func newStatementEntryFromDB(e database.StatementEntry) (*StatementEntry, error) {
+	amount, err := NewDecimalFromDB(e.Amount())
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err := NewDecimalFromDB(e.Fee())
+	if err != nil {
+		return nil, err
+	}
+
+	entry := StatementEntry{
+		ID:        e.ID(),
+		Time:      e.CreatedAt(),
+		Type:      e.Type(),
+		Reference: e.Reference(),
+		Amount:    amount,
+		Fee:       fee,
+	}
+	if id := e.Transfer(); id != nil {
+		transfer := TransferIDFomDB(*id)
+		entry.Transfer = &transfer
+	}
+	if id := e.Counterparty(); id != nil {
+		counterparty := WalletIDFromDB(*id)
+		entry.Counterparty = &counterparty
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recordedStatement keeps what WriteStatement passes to its writer.
+type recordedStatement struct {
+	opening, closing *Decimal
+	entries          []*StatementEntry
+}
+
+func (s *recordedStatement) Opening(balance Decimal) error {
+	s.opening = &balance
+	return nil
+}
+
+func (s *recordedStatement) Entry(entry *StatementEntry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *recordedStatement) Closing(balance Decimal) error {
+	s.closing = &balance
+	return nil
+}
+
+func TestWriteStatement(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc := testCurrency(t, "BTC")
+	w := createTestWallet(t, db, btc, mustDecimal(t, "10"))
+	to := createTestWallet(t, db, btc, Decimal{})
+
+	_, err := transferInTx(ctx, db, &TransferFundsParams{
+		From:   w.ID,
+		To:     to.ID,
+		Amount: mustDecimal(t, "3"),
+		Fee:    NewFlatFee("test", Decimal{}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s recordedStatement
+	if err := WriteStatement(ctx, db, w.ID, time.Unix(0, 0), time.Now(), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.opening == nil || !s.opening.IsZero() {
+		t.Errorf("opening balance %v, want 0", s.opening)
+	}
+	if len(s.entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(s.entries))
+	}
+
+	balance := *s.opening
+	for _, e := range s.entries {
+		balance = balance.Add(e.Amount).Sub(e.Fee)
+		if !e.Balance.Equal(balance) {
+			t.Errorf("%s entry: balance %v, want %v", e.Type, e.Balance, balance)
+		}
+	}
+	if s.closing == nil || !s.closing.Equal(mustDecimal(t, "7")) {
+		t.Errorf("closing balance %v, want 7", s.closing)
+	}
+}
+
+func TestWriteStatementWalletDoesNotExist(t *testing.T) {
+	db := openTestDB(t)
+
+	var s recordedStatement
+	err := WriteStatement(context.Background(), db, 1<<31-1, time.Unix(0, 0), time.Now(), &s)
+	if !ErrWalletDoesNotExist.Has(err) {
+		t.Errorf("want ErrWalletDoesNotExist, got %v", err)
+	}
+	if s.opening != nil {
+		t.Error("the opening balance was written before the error")
+	}
+}
@@ -2,6 +2,9 @@ package lib
 
 import (
 	"context"
+	"database/sql"
+	"math/big"
+	"strconv"
 	"time"
 
 	"github.com/zeebo/errs"
@@ -12,8 +15,16 @@ import (
 var (
 	ErrNewTransferFromDB               = errs.Class("make transfer from db")
 	ErrTransferFunds                   = errs.Class("transfer funds")
+	ErrFindTransferByID                = errs.Class("find transfer by id")
+	ErrFindTransfers                   = errs.Class("find transfers")
+	ErrInvalidTransferFilter           = errs.Class("invalid transfer filter")
+	ErrFindWalletTransfers             = errs.Class("find wallet transfers")
 	ErrUnsupportedCurrencyConversation = ErrTransferFunds.New("unsupported currency conversation")
 	ErrInsufficientFunds               = ErrTransferFunds.New("insufficient funds")
+	ErrTransferToSameWallet            = ErrTransferFunds.New("cannot transfer to the same wallet")
+	ErrFeeWalletNotConfigured          = errs.Class("fee wallet not configured")
+	ErrFindFeeRevenue                  = errs.Class("find fee revenue")
+	ErrQuoteTransfer                   = errs.Class("quote transfer")
 	errCreateTransfer                  = errs.Class("create transfer")
 )
 
@@ -42,7 +53,23 @@ type Transfer struct {
 	To        WalletID
 	Amount    Decimal
 	FeeAmount Decimal
-	CreatedAt time.Time
+	FeeWallet *WalletID
+	FeePolicy string
+	// ReversalOf is the transfer this one reverses, nil for regular transfers.
+	ReversalOf *TransferID
+	// FeeRefund is the part of the fee of the reversed transfer returned to
+	// the receiver of the reversal.
+	FeeRefund Decimal
+	// DestinationAmount is what the receiver got in its currency. It equals
+	// Amount unless the transfer converted the currencies.
+	DestinationAmount Decimal
+	// ExchangeRate is nil unless the transfer converted the currencies.
+	ExchangeRate   *Decimal
+	ExchangeRateID string
+	// FXSpread is the part of the converted amount kept by SpreadWallet.
+	FXSpread     Decimal
+	SpreadWallet *WalletID
+	CreatedAt    time.Time
 }
 
 func NewTransferFromDB(transfer database.Transfer) (*Transfer, error) {
@@ -60,35 +87,93 @@ func NewTransferFromDB(transfer database.Transfer) (*Transfer, error) {
 		return nil, ErrNewTransferFromDB.Wrap(err)
 	}
 
+	feeRefund, err := NewDecimalFromDB(transfer.FeeRefund())
+	if err != nil {
+		return nil, ErrNewTransferFromDB.Wrap(err)
+	}
+
+	destAmount, err := NewDecimalFromDB(transfer.DestinationAmount())
+	if err != nil {
+		return nil, ErrNewTransferFromDB.Wrap(err)
+	}
+
+	fxSpread, err := NewDecimalFromDB(transfer.FXSpread())
+	if err != nil {
+		return nil, ErrNewTransferFromDB.Wrap(err)
+	}
+
 	t := Transfer{
-		ID:        TransferIDFomDB(transfer.ID()),
-		From:      WalletIDFromDB(transfer.From()),
-		To:        WalletIDFromDB(transfer.To()),
-		Amount:    amount,
-		FeeAmount: feeAmount,
-		CreatedAt: transfer.CreatedAt(),
+		ID:                TransferIDFomDB(transfer.ID()),
+		From:              WalletIDFromDB(transfer.From()),
+		To:                WalletIDFromDB(transfer.To()),
+		Amount:            amount,
+		FeeAmount:         feeAmount,
+		FeePolicy:         transfer.FeePolicy(),
+		FeeRefund:         feeRefund,
+		DestinationAmount: destAmount,
+		ExchangeRateID:    transfer.ExchangeRateID(),
+		FXSpread:          fxSpread,
+		CreatedAt:         transfer.CreatedAt(),
+	}
+
+	if id := transfer.FeeWallet(); id != nil {
+		feeWallet := WalletIDFromDB(*id)
+		t.FeeWallet = &feeWallet
+	}
+	if id := transfer.ReversalOf(); id != nil {
+		reversalOf := TransferIDFomDB(*id)
+		t.ReversalOf = &reversalOf
+	}
+	if v := transfer.ExchangeRate(); v != nil {
+		rate, err := NewDecimalFromDB(*v)
+		if err != nil {
+			return nil, ErrNewTransferFromDB.Wrap(err)
+		}
+
+		t.ExchangeRate = &rate
+	}
+	if id := transfer.SpreadWallet(); id != nil {
+		spreadWallet := WalletIDFromDB(*id)
+		t.SpreadWallet = &spreadWallet
 	}
 
 	return &t, nil
 }
 
 type TransferFundsParams struct {
-	From   WalletID
-	To     WalletID
+	From WalletID
+	To   WalletID
+	// Amount defaults to the amount of the quote if Quote is set.
 	Amount Decimal
-	Fee    Decimal
+	// Fee is resolved for the currency of the transfer with ResolveFeePolicy.
+	Fee FeePolicy
+	// Rates converts the amount if the currencies of the wallets differ.
+	// Transfers between currencies fail if it is nil.
+	Rates ExchangeRateProvider
+	// FXSpread is the percentage of the converted amount kept as revenue by
+	// the fee wallet of the currency of the receiver, e.g. 0.5 is 0.5%.
+	FXSpread Decimal
+	// Quote converts the amount with the rate and spread locked by
+	// CreateFXQuote instead. The quote must be for the same wallets and
+	// amount, it is used up by the transfer.
+	Quote *FXQuoteID
+	// Status decides whether frozen wallets can receive the funds.
+	Status WalletStatusRule
 }
 
 type TransferFundsResult interface {
 	From() *Wallet
 	To() *Wallet
+	// FeeWallet is nil if no fee was charged.
+	FeeWallet() *Wallet
 	Transfer() *Transfer
 }
 
 type transferFundsResultImpl struct {
-	from     *Wallet
-	to       *Wallet
-	transfer *Transfer
+	from      *Wallet
+	to        *Wallet
+	feeWallet *Wallet
+	transfer  *Transfer
 }
 
 func (t *transferFundsResultImpl) From() *Wallet {
@@ -99,6 +184,10 @@ func (t *transferFundsResultImpl) To() *Wallet {
 	return t.to
 }
 
+func (t *transferFundsResultImpl) FeeWallet() *Wallet {
+	return t.feeWallet
+}
+
 func (t *transferFundsResultImpl) Transfer() *Transfer {
 	return t.transfer
 }
@@ -120,97 +209,630 @@ func FindAllTransfers(ctx context.Context, q database.ContextQuerier) ([]*Transf
 	return rv, nil
 }
 
-func TransferFunds(ctx context.Context, q database.ContextQueryExecutor, params *TransferFundsParams) (TransferFundsResult, error) {
-	ids := []WalletID{params.From, params.To}
-	ws, err := FindManyWalletsByIDs(ctx, q, ids)
+const (
+	DefaultTransferPageSize = 50
+	MaxTransferPageSize     = 500
+)
+
+// TransferFilter selects transfers, nil fields are not checked.
+type TransferFilter struct {
+	Sender   *WalletID
+	Receiver *WalletID
+	// Wallet matches transfers where the wallet is either the sender or the receiver.
+	Wallet    *WalletID
+	MinAmount *Decimal
+	MaxAmount *Decimal
+	Currency  *Currency
+	// From is inclusive, To is exclusive.
+	From *time.Time
+	To   *time.Time
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	Cursor string
+	// Limit defaults to DefaultTransferPageSize and cannot exceed MaxTransferPageSize.
+	Limit int
+}
+
+// TransferPage holds transfers from the newest to the oldest.
+// NextCursor is empty on the last page.
+type TransferPage struct {
+	Transfers  []*Transfer
+	NextCursor string
+}
+
+// encodeTransferCursor makes an opaque cursor pointing at t.
+func encodeTransferCursor(t *Transfer) string {
+	return encodeCursor(strconv.FormatInt(t.CreatedAt.UnixNano(), 10), t.ID.String())
+}
+
+func decodeTransferCursor(cursor string) (*database.TransferCursor, error) {
+	parts, err := decodeCursor(cursor, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := parseCursorTime(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor.New("%q", cursor)
+	}
+
+	id, err := database.ParseID(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor.New("%q", cursor)
+	}
+
+	return &database.TransferCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+func (f *TransferFilter) toDB() (*database.TransferFilter, error) {
+	limit, err := pageLimit(f.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := database.TransferFilter{
+		Sender:      optionalWalletIDToDB(f.Sender),
+		Receiver:    optionalWalletIDToDB(f.Receiver),
+		Wallet:      optionalWalletIDToDB(f.Wallet),
+		CreatedFrom: f.From,
+		CreatedTo:   f.To,
+		// one more to know whether there is a next page
+		Limit: limit + 1,
+	}
+
+	if f.MinAmount != nil {
+		v := f.MinAmount.ToDB()
+		rv.MinAmount = &v
+	}
+	if f.MaxAmount != nil {
+		v := f.MaxAmount.ToDB()
+		rv.MaxAmount = &v
+	}
+	if f.Currency != nil {
+		v := f.Currency.ToDB()
+		rv.Currency = &v
+	}
+
+	if f.Cursor != "" {
+		c, err := decodeTransferCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		rv.After = c
+	}
+
+	return &rv, nil
+}
+
+// FindTransfers returns a page of the transfers matching the filter.
+func FindTransfers(ctx context.Context, q database.ContextQuerier, filter *TransferFilter) (*TransferPage, error) {
+	dbFilter, err := filter.toDB()
+	if err != nil {
+		return nil, ErrFindTransfers.Wrap(err)
+	}
+
+	ts, err := database.FindTransfers(ctx, q, dbFilter)
+	if err != nil {
+		return nil, ErrFindTransfers.Wrap(err)
+	}
+
+	limit := dbFilter.Limit - 1
+	hasMore := len(ts) > limit
+	if hasMore {
+		ts = ts[:limit]
+	}
+
+	page := TransferPage{Transfers: make([]*Transfer, len(ts))}
+	for i, t := range ts {
+		page.Transfers[i], err = NewTransferFromDB(t)
+		if err != nil {
+			return nil, ErrFindTransfers.Wrap(err)
+		}
+	}
+
+	if hasMore {
+		page.NextCursor = encodeTransferCursor(page.Transfers[limit-1])
+	}
+
+	return &page, nil
+}
+
+// WalletTransfer is a transfer as seen by one of the wallets involved.
+type WalletTransfer struct {
+	*Transfer
+	// entry is the ledger entry of the wallet, it orders entries of the same transfer.
+	entry database.LedgerEntryID
+	// Direction is "out", "in" or "fee" if the wallet collected the fee.
+	Direction string
+	// SignedAmount is negative for outgoing transfers and excludes the fee.
+	SignedAmount Decimal
+	// FeePaid is zero unless the transfer is outgoing or a reversal that
+	// refunds the fee, in which case it is negative.
+	FeePaid      Decimal
+	BalanceAfter Decimal
+}
+
+func NewWalletTransferFromDB(transfer database.WalletTransfer) (*WalletTransfer, error) {
+	t, err := NewTransferFromDB(transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	signedAmount, err := NewDecimalFromDB(transfer.SignedAmount())
+	if err != nil {
+		return nil, ErrNewTransferFromDB.Wrap(err)
+	}
+
+	feePaid, err := NewDecimalFromDB(transfer.FeePaid())
+	if err != nil {
+		return nil, ErrNewTransferFromDB.Wrap(err)
+	}
+
+	balanceAfter, err := NewDecimalFromDB(transfer.BalanceAfter())
+	if err != nil {
+		return nil, ErrNewTransferFromDB.Wrap(err)
+	}
+
+	rv := WalletTransfer{
+		Transfer:     t,
+		entry:        transfer.Entry(),
+		Direction:    transfer.Direction(),
+		SignedAmount: signedAmount,
+		FeePaid:      feePaid,
+		BalanceAfter: balanceAfter,
+	}
+
+	return &rv, nil
+}
+
+// Counterparty returns the other wallet of the transfer.
+func (t *WalletTransfer) Counterparty() WalletID {
+	if t.Direction == database.DirectionOut {
+		return t.To
+	}
+
+	return t.From
+}
+
+// WalletTransferFilter selects the transfers of Wallet, nil fields are not checked.
+type WalletTransferFilter struct {
+	Wallet WalletID
+	// From is inclusive, To is exclusive.
+	From *time.Time
+	To   *time.Time
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	Cursor string
+	// Limit defaults to DefaultTransferPageSize and cannot exceed MaxTransferPageSize.
+	Limit int
+}
+
+// WalletTransferPage holds transfers from the newest to the oldest.
+// NextCursor is empty on the last page.
+type WalletTransferPage struct {
+	Transfers  []*WalletTransfer
+	NextCursor string
+}
+
+func encodeWalletTransferCursor(t *WalletTransfer) string {
+	return encodeCursor(strconv.FormatInt(t.CreatedAt.UnixNano(), 10), t.ID.String(), t.entry.String())
+}
+
+func decodeWalletTransferCursor(cursor string) (*database.WalletTransferCursor, error) {
+	parts, err := decodeCursor(cursor, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt, err := parseCursorTime(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor.New("%q", cursor)
+	}
+
+	id, err := database.ParseID(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor.New("%q", cursor)
+	}
+
+	entry, err := database.ParseID(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCursor.New("%q", cursor)
+	}
+
+	return &database.WalletTransferCursor{CreatedAt: createdAt, ID: id, Entry: entry}, nil
+}
+
+func pageLimit(limit int) (int, error) {
+	switch {
+	case limit == 0:
+		return DefaultTransferPageSize, nil
+	case limit < 0 || limit > MaxTransferPageSize:
+		return 0, ErrInvalidTransferFilter.New("limit must be in [1, %d]", MaxTransferPageSize)
+	}
+
+	return limit, nil
+}
+
+// FindWalletTransfers returns a page of the transfers that changed the
+// balance of the wallet, with the balance after each of them.
+func FindWalletTransfers(ctx context.Context, q database.ContextQuerier, filter *WalletTransferFilter) (*WalletTransferPage, error) {
+	limit, err := pageLimit(filter.Limit)
+	if err != nil {
+		return nil, ErrFindWalletTransfers.Wrap(err)
+	}
+
+	dbFilter := database.WalletTransferFilter{
+		Wallet:      filter.Wallet.ToDB(),
+		CreatedFrom: filter.From,
+		CreatedTo:   filter.To,
+		// one more to know whether there is a next page
+		Limit: limit + 1,
+	}
+	if filter.Cursor != "" {
+		dbFilter.After, err = decodeWalletTransferCursor(filter.Cursor)
+		if err != nil {
+			return nil, ErrFindWalletTransfers.Wrap(err)
+		}
+	}
+
+	ts, err := database.FindWalletTransfers(ctx, q, &dbFilter)
+	if err != nil {
+		return nil, ErrFindWalletTransfers.Wrap(err)
+	}
+
+	hasMore := len(ts) > limit
+	if hasMore {
+		ts = ts[:limit]
+	}
+
+	page := WalletTransferPage{Transfers: make([]*WalletTransfer, len(ts))}
+	for i, t := range ts {
+		page.Transfers[i], err = NewWalletTransferFromDB(t)
+		if err != nil {
+			return nil, ErrFindWalletTransfers.Wrap(err)
+		}
+	}
+
+	if hasMore {
+		page.NextCursor = encodeWalletTransferCursor(page.Transfers[limit-1])
+	}
+
+	return &page, nil
+}
+
+func FindTransferByID(ctx context.Context, q database.ContextRowQuerier, id TransferID) (*Transfer, error) {
+	t, err := database.FindTransferByID(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindTransferByID.Wrap(err)
+	}
+
+	rv, err := NewTransferFromDB(t)
+	if err != nil {
+		return nil, ErrFindTransferByID.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// TransferFunds moves funds between two wallets within tx and credits the fee
+// to the fee wallet of the currency. All involved wallets are locked before
+// the balance check, so the check still holds when the funds are moved.
+// If the currencies differ, the amount is converted with the rate in effect
+// when the transfer executes; the fee is always in the currency of the sender.
+// The caller is responsible for committing or rolling back tx.
+func TransferFunds(ctx context.Context, tx *sql.Tx, params *TransferFundsParams) (TransferFundsResult, error) {
+	ws, err := lockTransferWallets(ctx, tx, params.From, params.To)
 	if err != nil {
 		return nil, ErrTransferFunds.Wrap(err)
 	}
+	if err := params.Status.checkTransfer(ws.from, ws.to); err != nil {
+		return nil, ErrTransferFunds.Wrap(err)
+	}
+
+	amount := params.Amount
+
+	var quote *FXQuote
+	if params.Quote != nil {
+		quote, err = lockFXQuote(ctx, tx, *params.Quote, ws, amount)
+		if err != nil {
+			return nil, ErrTransferFunds.Wrap(err)
+		}
 
-	from := ws[params.From]
-	if from == nil {
-		return nil, ErrTransferFunds.Wrap(ErrWalletDoesNotExist.New("%v", params.From))
+		amount = quote.Amount
 	}
-	to := ws[params.To]
-	if to == nil {
-		return nil, ErrTransferFunds.Wrap(ErrWalletDoesNotExist.New("%v", params.To))
+
+	policy := ResolveFeePolicy(params.Fee, ws.from.Currency)
+	feeAmount := calcFeeAmount(amount, policy, ws.from.Currency)
+
+	var conv *conversion
+	switch {
+	case quote != nil:
+		conv = quote.conversion()
+	case !ws.from.Currency.Equals(ws.to.Currency):
+		conv, err = convertTransfer(ctx, params.Rates, params.FXSpread, ws, amount, time.Now())
+		if err != nil {
+			return nil, ErrTransferFunds.Wrap(err)
+		}
 	}
 
-	if err := verifyWalletsBeforeTransfer(from, to, params.Amount, params.Fee); err != nil {
+	plan, err := planTransfer(ws, amount, feeAmount, policy, conv)
+	if err != nil {
 		return nil, ErrTransferFunds.Wrap(err)
 	}
 
-	transfer, err := doTransfer(ctx, q, from, to, params.Amount, params.Fee)
+	transfer, err := doTransfer(ctx, tx, plan)
 	if err != nil {
 		return nil, ErrTransferFunds.Wrap(err)
 	}
 
+	if quote != nil {
+		err = useFXQuote(ctx, tx, quote.ID, transfer.Transfer().ID)
+		if err != nil {
+			return nil, ErrTransferFunds.Wrap(err)
+		}
+	}
+
 	return transfer, nil
 }
 
-func verifyWalletsBeforeTransfer(from, to *Wallet, amount, fee Decimal) error {
-	if amount.Equal(NewDecimal(0)) || amount.Less(NewDecimal(0)) {
+// transferWallets are the locked wallets of a transfer. feeWallet is nil if
+// the currency of the sender has no fee wallet. spreadWallet is the fee
+// wallet of the currency of the receiver if the currencies differ.
+type transferWallets struct {
+	from         *Wallet
+	to           *Wallet
+	feeWallet    *Wallet
+	spreadWallet *Wallet
+}
+
+// lockTransferWallets locks the sender, the receiver and the fee wallets of
+// their currencies.
+func lockTransferWallets(ctx context.Context, tx *sql.Tx, fromID, toID WalletID) (*transferWallets, error) {
+	return loadTransferWallets(ctx, tx, fromID, toID, func(ids []WalletID) (map[WalletID]*Wallet, error) {
+		return LockManyWalletsByIDs(ctx, tx, ids)
+	})
+}
+
+// findTransferWallets finds the same wallets as lockTransferWallets without
+// locking them.
+func findTransferWallets(ctx context.Context, q database.ContextQuerier, fromID, toID WalletID) (*transferWallets, error) {
+	return loadTransferWallets(ctx, q, fromID, toID, func(ids []WalletID) (map[WalletID]*Wallet, error) {
+		return FindManyWalletsByIDs(ctx, q, ids)
+	})
+}
+
+func loadTransferWallets(
+	ctx context.Context,
+	q database.ContextQuerier,
+	fromID, toID WalletID,
+	load func(ids []WalletID) (map[WalletID]*Wallet, error),
+) (*transferWallets, error) {
+	// The currency of a wallet never changes, so it is safe to look up the
+	// fee wallets before taking the locks. This lets us lock all the wallets
+	// at once and in the same order as any other transfer does.
+	found, err := FindManyWalletsByIDs(ctx, q, []WalletID{fromID, toID})
+	if err != nil {
+		return nil, err
+	}
+
+	sender, receiver := found[fromID], found[toID]
+	if sender == nil {
+		return nil, ErrWalletDoesNotExist.New("%v", fromID)
+	}
+	if receiver == nil {
+		return nil, ErrWalletDoesNotExist.New("%v", toID)
+	}
+
+	ids := []WalletID{fromID, toID}
+	feeWalletID, hasFeeWallet := sender.Currency.FeeWallet()
+	if hasFeeWallet {
+		ids = append(ids, feeWalletID)
+	}
+
+	var spreadWalletID WalletID
+	hasSpreadWallet := false
+	if !sender.Currency.Equals(receiver.Currency) {
+		spreadWalletID, hasSpreadWallet = receiver.Currency.FeeWallet()
+		if hasSpreadWallet {
+			ids = append(ids, spreadWalletID)
+		}
+	}
+
+	ws, err := load(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := transferWallets{from: ws[fromID], to: ws[toID]}
+	if rv.from == nil {
+		return nil, ErrWalletDoesNotExist.New("%v", fromID)
+	}
+	if rv.to == nil {
+		return nil, ErrWalletDoesNotExist.New("%v", toID)
+	}
+	if hasFeeWallet {
+		rv.feeWallet = ws[feeWalletID]
+	}
+	if hasSpreadWallet {
+		rv.spreadWallet = ws[spreadWalletID]
+	}
+
+	return &rv, nil
+}
+
+// planTransfer verifies the transfer of amount plus feeAmount between the
+// locked wallets. conv converts the amount if the currencies differ, a
+// transfer between currencies without it fails.
+func planTransfer(ws *transferWallets, amount, feeAmount Decimal, policy FeePolicy, conv *conversion) (*transferPlan, error) {
+	if err := verifyWalletsBeforeTransfer(ws.from, ws.to, amount, feeAmount); err != nil {
+		return nil, err
+	}
+
+	plan := transferPlan{
+		from:       ws.from,
+		to:         ws.to,
+		amount:     amount,
+		feeAmount:  feeAmount,
+		feePolicy:  policy,
+		conversion: conv,
+	}
+	if feeAmount.Sign() > 0 {
+		if plan.feeWallet = ws.feeWallet; plan.feeWallet == nil {
+			return nil, ErrFeeWalletNotConfigured.New("%v", ws.from.Currency)
+		}
+	}
+
+	if ws.from.Currency.Equals(ws.to.Currency) {
+		plan.conversion = nil
+		return &plan, nil
+	}
+	if conv == nil {
+		return nil, ErrUnsupportedCurrencyConversation
+	}
+	if err := ws.to.Currency.CheckEnabled(); err != nil {
+		return nil, err
+	}
+	if conv.spread.Sign() > 0 {
+		if conv.spreadWallet = ws.spreadWallet; conv.spreadWallet == nil {
+			return nil, ErrFeeWalletNotConfigured.New("%v", ws.to.Currency)
+		}
+	}
+
+	return &plan, nil
+}
+
+// verifyWalletsBeforeTransfer checks the wallets differ and the sender can
+// pay amount plus feeAmount. The currencies of the wallets are checked by the callers.
+func verifyWalletsBeforeTransfer(from, to *Wallet, amount, feeAmount Decimal) error {
+	if amount.Sign() <= 0 {
 		return ErrTransferFunds.New("cannot transfer: %v", amount)
 	}
-	if !from.Currency.Equals(to.Currency) {
-		return ErrUnsupportedCurrencyConversation
+	if from.ID == to.ID {
+		return ErrTransferToSameWallet
+	}
+	if err := from.Currency.CheckEnabled(); err != nil {
+		return err
+	}
+	if err := from.Currency.ValidateAmount(amount); err != nil {
+		return err
 	}
-	if from.Balance.Equal(NewDecimal(0)) {
+
+	// funds reserved by holds cannot be spent
+	available := from.Available()
+	if available.Sign() <= 0 {
 		return ErrInsufficientFunds
 	}
 
-	feeAmount := calcFeeAmount(amount, fee)
 	totalAmount := amount.Add(feeAmount)
-	if from.Balance.Less(totalAmount) {
+	if available.Less(totalAmount) {
 		return ErrInsufficientFunds
 	}
 
 	return nil
 }
 
-func doTransfer(ctx context.Context, q database.ContextRowQueryExecutor, from, to *Wallet, amount, fee Decimal) (TransferFundsResult, error) {
-	feeAmount := calcFeeAmount(amount, fee)
-	totalAmount := amount.Add(feeAmount)
+// transferPlan is a verified transfer. feeWallet is nil if feeAmount is zero.
+type transferPlan struct {
+	from      *Wallet
+	to        *Wallet
+	feeWallet *Wallet
+	amount    Decimal
+	feeAmount Decimal
+	feePolicy FeePolicy
+	// batch is set for the legs of a batch.
+	batch *BatchID
+	// conversion is set if the currencies of the wallets differ.
+	conversion *conversion
+}
 
-	from, err := removeFunds(ctx, q, from, totalAmount)
+func doTransfer(ctx context.Context, q database.ContextRowQueryExecutor, plan *transferPlan) (TransferFundsResult, error) {
+	transfer, err := createTransaction(ctx, q, plan)
 	if err != nil {
 		return nil, ErrTransferFunds.Wrap(err)
 	}
 
-	to, err = addFunds(ctx, q, to, amount)
-	if err != nil {
-		return nil, ErrTransferFunds.Wrap(err)
+	currency, destCurrency := plan.from.Currency, plan.to.Currency
+	destAmount := plan.amount
+	if plan.conversion != nil {
+		destAmount = plan.conversion.destAmount
+	}
+
+	journal := Journal{
+		Kind:     JournalTransfer,
+		Transfer: &transfer.ID,
+		Postings: []Posting{
+			{Account: WalletAccount(plan.from.ID), Currency: currency, Amount: plan.amount.Add(plan.feeAmount).Neg()},
+			{Account: WalletAccount(plan.to.ID), Currency: destCurrency, Amount: destAmount},
+		},
+	}
+	if plan.feeWallet != nil {
+		journal.Postings = append(journal.Postings,
+			Posting{Account: WalletAccount(plan.feeWallet.ID), Currency: currency, Amount: plan.feeAmount})
+	}
+	if conv := plan.conversion; conv != nil {
+		// the FX accounts balance every currency on its own: the amount is
+		// sold into the account of its currency and the converted amount is
+		// bought from the account of the other one
+		journal.Postings = append(journal.Postings,
+			Posting{Account: FXAccount(currency), Currency: currency, Amount: plan.amount},
+			Posting{Account: FXAccount(destCurrency), Currency: destCurrency, Amount: conv.gross.Neg()})
+		if conv.spreadWallet != nil {
+			journal.Postings = append(journal.Postings,
+				Posting{Account: WalletAccount(conv.spreadWallet.ID), Currency: destCurrency, Amount: conv.spread})
+		}
 	}
 
-	transfer, err := createTransaction(ctx, q, from, to, amount, feeAmount)
+	ws, err := postJournal(ctx, q, &journal)
 	if err != nil {
 		return nil, ErrTransferFunds.Wrap(err)
 	}
 
 	rv := transferFundsResultImpl{
-		from:     from,
-		to:       to,
+		from:     ws[plan.from.ID],
+		to:       ws[plan.to.ID],
 		transfer: transfer,
 	}
+	if plan.feeWallet != nil {
+		rv.feeWallet = ws[plan.feeWallet.ID]
+	}
 
 	return &rv, nil
 }
 
-func calcFeeAmount(amount, fee Decimal) Decimal {
-	return amount.Div(NewDecimalFromFloat(100)).Mul(fee)
-}
+func createTransaction(ctx context.Context, q database.ContextRowQueryExecutor, plan *transferPlan) (*Transfer, error) {
+	params := database.CreateTransactionParams{
+		From:      plan.from.ID.ToDB(),
+		To:        plan.to.ID.ToDB(),
+		Amount:    plan.amount.ToDB(),
+		FeeAmount: plan.feeAmount.ToDB(),
+		FeeRefund: Decimal{}.ToDB(),
+		FXSpread:  Decimal{}.ToDB(),
+	}
+	if plan.feeWallet != nil {
+		id := plan.feeWallet.ID.ToDB()
+		params.FeeWallet = &id
+	}
+	if plan.feePolicy != nil {
+		params.FeePolicy = plan.feePolicy.ID()
+	}
+	if plan.batch != nil {
+		id := plan.batch.ToDB()
+		params.Batch = &id
+	}
+	if conv := plan.conversion; conv != nil {
+		destAmount, rate := conv.destAmount.ToDB(), conv.rate.Rate.ToDB()
+		params.DestinationAmount = &destAmount
+		params.ExchangeRate = &rate
+		params.ExchangeRateID = conv.rate.ID
+		params.FXSpread = conv.spread.ToDB()
+		if conv.spreadWallet != nil {
+			id := conv.spreadWallet.ID.ToDB()
+			params.SpreadWallet = &id
+		}
+	}
 
-func createTransaction(ctx context.Context, q database.ContextRowQueryExecutor, from, to *Wallet, amount, feeAmount Decimal) (*Transfer, error) {
-	c, err := database.CreateTransaction(
-		ctx,
-		q,
-		from.ID.ToDB(),
-		to.ID.ToDB(),
-		amount.ToDB(),
-		feeAmount.ToDB(),
-	)
+	c, err := database.CreateTransaction(ctx, q, &params)
 	if err != nil {
-		return nil, errCreateTransfer.Wrap(err)
+		return nil, errCreateTransfer.Wrap(constraintError(err))
 	}
 
 	t, err := NewTransferFromDB(c)
@@ -220,3 +842,190 @@ func createTransaction(ctx context.Context, q database.ContextRowQueryExecutor,
 
 	return t, nil
 }
+
+type FeeRevenue struct {
+	Currency Currency
+	Count    int64
+	Total    Decimal
+	// FXSpread is collected from transfers into the currency.
+	FXSpread Decimal
+}
+
+// FindFeeRevenue sums the fees and FX spreads collected in [from, to) per currency.
+func FindFeeRevenue(ctx context.Context, q database.ContextQuerier, from, to time.Time) ([]*FeeRevenue, error) {
+	fs, err := database.SumFeeRevenue(ctx, q, from, to)
+	if err != nil {
+		return nil, ErrFindFeeRevenue.Wrap(err)
+	}
+
+	rv := make([]*FeeRevenue, len(fs))
+	for i, f := range fs {
+		c, err := NewCurrency(f.Currency())
+		if err != nil {
+			return nil, ErrFindFeeRevenue.Wrap(err)
+		}
+
+		total, err := NewDecimalFromDB(f.Total())
+		if err != nil {
+			return nil, ErrFindFeeRevenue.Wrap(err)
+		}
+
+		fxSpread, err := NewDecimalFromDB(f.FXSpread())
+		if err != nil {
+			return nil, ErrFindFeeRevenue.Wrap(err)
+		}
+
+		rv[i] = &FeeRevenue{
+			Currency: c,
+			Count:    f.Count(),
+			Total:    total,
+			FXSpread: fxSpread,
+		}
+	}
+
+	return rv, nil
+}
+
+type QuoteMode int
+
+const (
+	// QuoteReceive treats the amount as what the receiver gets, the fee is
+	// charged on top of it. This is how TransferFunds treats the amount.
+	QuoteReceive QuoteMode = iota
+	// QuoteDebit treats the amount as the total debited from the sender and
+	// finds the largest transfer amount that, with its fee, fits into it.
+	QuoteDebit
+)
+
+func ParseQuoteMode(s string) (QuoteMode, error) {
+	switch s {
+	case "", "receive":
+		return QuoteReceive, nil
+	case "debit":
+		return QuoteDebit, nil
+	}
+
+	return 0, ErrQuoteTransfer.New("unknown mode %q", s)
+}
+
+type QuoteTransferParams struct {
+	From   WalletID
+	To     WalletID
+	Amount Decimal
+	Mode   QuoteMode
+	Fee    FeePolicy
+	// Rates and FXSpread convert the amount as TransferFunds does if the
+	// currencies of the wallets differ.
+	Rates    ExchangeRateProvider
+	FXSpread Decimal
+	// Status decides whether frozen wallets can receive the funds.
+	Status WalletStatusRule
+}
+
+// TransferQuote is the outcome TransferFunds would have with the current
+// balances. Amount is what the sender transfers, DestinationAmount is what
+// the receiver gets in its currency. BalanceAfter and AvailableAfter are the
+// balance and the available balance of the sender after the transfer.
+type TransferQuote struct {
+	From              *Wallet
+	To                *Wallet
+	Amount            Decimal
+	FeeAmount         Decimal
+	FeePolicy         string
+	TotalDebit        Decimal
+	BalanceAfter      Decimal
+	AvailableAfter    Decimal
+	DestinationAmount Decimal
+	// ExchangeRate is nil unless the currencies differ. FXSpread is the part
+	// of the converted amount kept by the fee wallet of the receiver's currency.
+	ExchangeRate *ExchangeRate
+	FXSpread     Decimal
+	// Err is the reason the transfer would fail, nil if it would succeed.
+	Err error
+}
+
+// QuoteTransfer runs the checks, the fee calculation and the conversion of
+// TransferFunds without locking or changing anything. The rate is the one in
+// effect now, it is not locked as with CreateFXQuote.
+func QuoteTransfer(ctx context.Context, q database.ContextQuerier, params *QuoteTransferParams) (*TransferQuote, error) {
+	ws, err := findTransferWallets(ctx, q, params.From, params.To)
+	if err != nil {
+		return nil, ErrQuoteTransfer.Wrap(err)
+	}
+
+	from, to := ws.from, ws.to
+	currency := from.Currency
+	policy := ResolveFeePolicy(params.Fee, currency)
+
+	amount := params.Amount
+	if params.Mode == QuoteDebit {
+		if err := currency.ValidateAmount(params.Amount); err != nil {
+			return nil, ErrQuoteTransfer.Wrap(err)
+		}
+
+		amount = amountForTotalDebit(params.Amount, policy, currency)
+	}
+
+	feeAmount := calcFeeAmount(amount, policy, currency)
+	totalDebit := amount.Add(feeAmount)
+
+	quote := TransferQuote{
+		From:              from,
+		To:                to,
+		Amount:            amount,
+		FeeAmount:         feeAmount,
+		FeePolicy:         policy.ID(),
+		TotalDebit:        totalDebit,
+		BalanceAfter:      from.Balance.Sub(totalDebit),
+		AvailableAfter:    from.Available().Sub(totalDebit),
+		DestinationAmount: amount,
+	}
+
+	quote.Err = params.Status.checkTransfer(from, to)
+
+	var conv *conversion
+	if quote.Err == nil && !currency.Equals(to.Currency) {
+		conv, err = convertTransfer(ctx, params.Rates, params.FXSpread, ws, amount, time.Now())
+		switch {
+		case err == nil:
+			quote.DestinationAmount = conv.destAmount
+			quote.ExchangeRate = conv.rate
+			quote.FXSpread = conv.spread
+		case ErrTransferFunds.Has(err) || ErrExchangeRateNotFound.Has(err) || ErrInvalidAmount.Has(err):
+			quote.Err = err
+		default:
+			return nil, ErrQuoteTransfer.Wrap(err)
+		}
+	}
+
+	if quote.Err == nil {
+		_, quote.Err = planTransfer(ws, amount, feeAmount, policy, conv)
+	}
+
+	return &quote, nil
+}
+
+// amountForTotalDebit finds the largest amount for which amount plus its fee
+// does not exceed total, assuming the fee does not decrease as the amount grows.
+func amountForTotalDebit(total Decimal, policy FeePolicy, currency Currency) Decimal {
+	unit := NewDecimal(1, currency.Scale())
+	fits := func(units *big.Int) bool {
+		amount := Decimal{v: units, scale: currency.Scale()}
+		return !total.Less(amount.Add(calcFeeAmount(amount, policy, currency)))
+	}
+
+	// binary search over the amount in the smallest units of the currency
+	lo, hi := new(big.Int), total.Div(unit, 0, RoundDown).unscaled()
+	for lo.Cmp(hi) < 0 {
+		mid := new(big.Int).Add(lo, hi)
+		mid.Add(mid, big.NewInt(1)).Rsh(mid, 1)
+
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid.Sub(mid, big.NewInt(1))
+		}
+	}
+
+	return Decimal{v: lo, scale: currency.Scale()}
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/zeebo/errs"
+)
+
+// TestTransferFundsConcurrent fires transfers between overlapping wallets in
+// parallel. Without the row locks, two transfers could both pass the balance
+// check and overdraw the sender, or lose an update of a balance.
+func TestTransferFundsConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	db.SetMaxOpenConns(32)
+
+	ctx := context.Background()
+	btc := testCurrency(t, "BTC")
+
+	const wallets, transfers = 8, 400
+	initial := NewDecimalFromInt(100)
+
+	ids := make([]WalletID, wallets)
+	for i := range ids {
+		ids[i] = createTestWallet(t, db, btc, initial).ID
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+
+	for i := 0; i < transfers; i++ {
+		// every transfer goes to another wallet, all the pairs are used
+		from := i % wallets
+		to := (from + 1 + (i/wallets)%(wallets-1)) % wallets
+		params := TransferFundsParams{
+			From:   ids[from],
+			To:     ids[to],
+			Amount: NewDecimalFromInt(int64(1 + i%40)),
+			Fee:    NewFlatFee("test", Decimal{}),
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := transferInTx(ctx, db, &params)
+			switch {
+			case err == nil:
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			case !errs.Is(err, ErrInsufficientFunds):
+				t.Errorf("transfer %v -> %v: %v", params.From, params.To, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if succeeded == 0 {
+		t.Fatal("no transfer succeeded")
+	}
+
+	var total Decimal
+	for _, id := range ids {
+		w := findTestWallet(t, db, id)
+		if w.Balance.Sign() < 0 {
+			t.Errorf("wallet %v has a negative balance %v", id, w.Balance)
+		}
+
+		total = total.Add(w.Balance)
+	}
+
+	if want := initial.Mul(NewDecimalFromInt(wallets)); !total.Equal(want) {
+		t.Errorf("total balance is %v, want %v", total, want)
+	}
+
+	ds, err := Reconcile(ctx, db, ids...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range ds {
+		t.Errorf("wallet %v drifted from the ledger by %v", d.Wallet, d.Difference())
+	}
+}
+
+func newTestWallet(t *testing.T, id int64, currency Currency, balance string) *Wallet {
+	t.Helper()
+
+	return &Wallet{
+		ID:       WalletID(id),
+		Balance:  mustDecimal(t, balance),
+		Currency: currency,
+		Status:   WalletActive,
+	}
+}
+
+func TestPlanTransferFeeWallet(t *testing.T) {
+	usd := newTestCurrency("USD", 2, RoundHalfEven)
+	policy := NewFlatFee("test", NewDecimal(1, 0))
+	feeWallet := newTestWallet(t, 3, usd, "0")
+
+	tests := []struct {
+		name      string
+		fee       string
+		feeWallet *Wallet
+		wantErr   bool
+	}{
+		{"no fee without fee wallet", "0", nil, false},
+		{"fee without fee wallet", "1", nil, true},
+		{"fee with fee wallet", "1", feeWallet, false},
+	}
+
+	for _, tt := range tests {
+		ws := transferWallets{
+			from:      newTestWallet(t, 1, usd, "10"),
+			to:        newTestWallet(t, 2, usd, "0"),
+			feeWallet: tt.feeWallet,
+		}
+
+		plan, err := planTransfer(&ws, NewDecimalFromInt(5), mustDecimal(t, tt.fee), policy, nil)
+		if tt.wantErr {
+			if !ErrFeeWalletNotConfigured.Has(err) {
+				t.Errorf("%s: error = %v, want ErrFeeWalletNotConfigured", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if plan.feeWallet != tt.feeWallet {
+			t.Errorf("%s: fee wallet = %v, want %v", tt.name, plan.feeWallet, tt.feeWallet)
+		}
+	}
+}
+
+func TestAmountForTotalDebit(t *testing.T) {
+	usd := newTestCurrency("USD", 2, RoundHalfEven)
+	up := newTestCurrency("USD", 2, RoundUp)
+
+	tests := []struct {
+		name     string
+		total    string
+		policy   FeePolicy
+		currency Currency
+		want     string
+	}{
+		{"no fee", "100", NewFlatFee("f", Decimal{}), usd, "100.00"},
+		{"flat fee", "100", NewFlatFee("f", NewDecimalFromInt(1)), usd, "99.00"},
+		{"flat fee above total", "0.50", NewFlatFee("f", NewDecimalFromInt(1)), usd, "0.00"},
+		{"percentage", "101", NewPercentageFee("p", NewDecimalFromInt(1)), usd, "100.00"},
+		{"percentage not exact", "100", NewPercentageFee("p", NewDecimalFromInt(1)), usd, "99.01"},
+		{"percentage rounded up", "100", NewPercentageFee("p", NewDecimalFromInt(1)), up, "99.00"},
+		{"percentage plus flat", "10.10", NewPercentagePlusFlatFee("pf", NewDecimalFromInt(1), NewDecimal(10, 2)), usd, "9.90"},
+	}
+
+	for _, tt := range tests {
+		total := mustDecimal(t, tt.total)
+		got := amountForTotalDebit(total, tt.policy, tt.currency)
+		if got.String() != tt.want {
+			t.Errorf("%s: amountForTotalDebit(%s) = %v, want %s", tt.name, tt.total, got, tt.want)
+		}
+
+		// the amount is the largest one that fits, zero if none does
+		debit := got.Add(calcFeeAmount(got, tt.policy, tt.currency))
+		if got.Sign() > 0 && total.Less(debit) {
+			t.Errorf("%s: %v with its fee debits %v, more than %s", tt.name, got, debit, tt.total)
+		}
+
+		next := got.Add(NewDecimal(1, tt.currency.Scale()))
+		if !total.Less(next.Add(calcFeeAmount(next, tt.policy, tt.currency))) {
+			t.Errorf("%s: %v also fits into %s", tt.name, next, tt.total)
+		}
+	}
+}
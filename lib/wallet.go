@@ -2,6 +2,9 @@ package lib
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"unicode/utf8"
 
 	"github.com/zeebo/errs"
 
@@ -13,10 +16,21 @@ var (
 	ErrCreateWallet         = errs.Class("create wallet")
 	ErrFindAllWallets       = errs.Class("find all wallets")
 	ErrFindManyWalletsByIDs = errs.Class("find many wallets by ids")
+	ErrLockManyWalletsByIDs = errs.Class("lock many wallets by ids")
 	ErrFindWalletByID       = errs.Class("find wallet by id")
 	ErrAddFunds             = errs.Class("add funds")
 	ErrRemoveFunds          = errs.Class("remove funds")
+	ErrHoldFunds            = errs.Class("hold funds")
+	ErrReleaseFunds         = errs.Class("release funds")
 	ErrWalletDoesNotExist   = errs.Class("wallet does not exist")
+	ErrInvalidMetadata      = errs.Class("invalid metadata")
+)
+
+// Limits of the wallet metadata.
+const (
+	maxMetadataKeys        = 16
+	maxMetadataKeyLength   = 40
+	maxMetadataValueLength = 500
 )
 
 type WalletID database.WalletID
@@ -38,10 +52,34 @@ func WalletIDFromDB(id database.WalletID) WalletID {
 	return WalletID(id)
 }
 
+func optionalWalletIDToDB(id *WalletID) *database.WalletID {
+	if id == nil {
+		return nil
+	}
+
+	v := id.ToDB()
+	return &v
+}
+
 type Wallet struct {
-	ID       WalletID
-	Balance  Decimal
-	Currency Currency
+	ID      WalletID
+	Balance Decimal
+	// Held is the part of Balance reserved by pending holds.
+	Held Decimal
+	// OverdraftLimit is how far below zero Balance can go, zero by default.
+	OverdraftLimit Decimal
+	Currency       Currency
+	Metadata       map[string]string
+	// Owner is nil if the wallet has no owner.
+	Owner *OwnerID
+	// Status is WalletActive, WalletFrozen or WalletClosed.
+	Status string
+}
+
+// Available is what can be spent: the balance that is not held, plus the
+// overdraft limit.
+func (w *Wallet) Available() Decimal {
+	return w.Balance.Sub(w.Held).Add(w.OverdraftLimit)
 }
 
 func NewWalletFromDB(wallet database.Wallet) (*Wallet, error) {
@@ -54,32 +92,126 @@ func NewWalletFromDB(wallet database.Wallet) (*Wallet, error) {
 		return nil, ErrNewWalletFromDB.Wrap(err)
 	}
 
+	held, err := NewDecimalFromDB(wallet.Held())
+	if err != nil {
+		return nil, ErrNewWalletFromDB.Wrap(err)
+	}
+
+	overdraftLimit, err := NewDecimalFromDB(wallet.OverdraftLimit())
+	if err != nil {
+		return nil, ErrNewWalletFromDB.Wrap(err)
+	}
+
 	c, err := NewCurrency(wallet.Currency())
 	if err != nil {
 		return nil, ErrNewWalletFromDB.Wrap(err)
 	}
 
+	var m map[string]string
+	if len(wallet.Metadata()) > 0 {
+		err = json.Unmarshal(wallet.Metadata(), &m)
+		if err != nil {
+			return nil, ErrNewWalletFromDB.Wrap(err)
+		}
+	}
+
 	w := Wallet{
-		ID:       WalletIDFromDB(wallet.ID()),
-		Balance:  d,
-		Currency: c,
+		ID:             WalletIDFromDB(wallet.ID()),
+		Balance:        d,
+		Held:           held,
+		OverdraftLimit: overdraftLimit,
+		Currency:       c,
+		Metadata:       m,
+		Status:         wallet.Status(),
+	}
+
+	if id := wallet.Owner(); id != nil {
+		owner := OwnerID(*id)
+		w.Owner = &owner
 	}
 
 	return &w, nil
 }
 
-func CreateWallet(ctx context.Context, q database.ContextRowQueryExecutor, balance Decimal, currency Currency) (*Wallet, error) {
-	w, err := database.CreateWallet(ctx, q, balance.ToDB(), currency.ToDB())
+type CreateWalletParams struct {
+	Currency Currency
+	Metadata map[string]string
+	// Owner may be nil.
+	Owner *OwnerID
+}
+
+// CreateWallet creates an empty wallet. Funds can only be added by transfers
+// and fundings. An owner gets a second wallet in the same currency only if
+// it allows multiple wallets.
+func CreateWallet(ctx context.Context, db *sql.DB, params *CreateWalletParams) (*Wallet, error) {
+	if err := params.Currency.CheckEnabled(); err != nil {
+		return nil, ErrCreateWallet.Wrap(err)
+	}
+	if err := validateMetadata(params.Metadata); err != nil {
+		return nil, ErrCreateWallet.Wrap(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, ErrCreateWallet.Wrap(err)
 	}
 
-	rv, err := NewWalletFromDB(w)
+	w, err := createWallet(ctx, tx, params)
 	if err != nil {
+		return nil, ErrCreateWallet.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
 		return nil, ErrCreateWallet.Wrap(err)
 	}
 
-	return rv, nil
+	return w, nil
+}
+
+func createWallet(ctx context.Context, tx *sql.Tx, params *CreateWalletParams) (*Wallet, error) {
+	metadata := params.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	m, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var owner *database.OwnerID
+	if params.Owner != nil {
+		if err := checkOwnerCanHoldWallet(ctx, tx, *params.Owner, params.Currency); err != nil {
+			return nil, err
+		}
+
+		id := params.Owner.ToDB()
+		owner = &id
+	}
+
+	w, err := database.CreateWallet(ctx, tx, params.Currency.ToDB(), m, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWalletFromDB(w)
+}
+
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataKeys {
+		return ErrInvalidMetadata.New("more than %d keys", maxMetadataKeys)
+	}
+
+	for k, v := range metadata {
+		if k == "" || utf8.RuneCountInString(k) > maxMetadataKeyLength {
+			return ErrInvalidMetadata.New("key %q: length must be 1 to %d", k, maxMetadataKeyLength)
+		}
+		if utf8.RuneCountInString(v) > maxMetadataValueLength {
+			return ErrInvalidMetadata.New("value of %q: longer than %d", k, maxMetadataValueLength)
+		}
+	}
+
+	return nil
 }
 
 func FindAllWallets(ctx context.Context, q database.ContextQuerier) ([]*Wallet, error) {
@@ -110,11 +242,41 @@ func FindManyWalletsByIDs(ctx context.Context, q database.ContextQuerier, ids []
 		return nil, ErrFindManyWalletsByIDs.Wrap(err)
 	}
 
+	rv, err := newWalletsMapFromDB(ws)
+	if err != nil {
+		return nil, ErrFindManyWalletsByIDs.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+// LockManyWalletsByIDs loads the wallets and holds row locks on them until
+// the transaction ends.
+func LockManyWalletsByIDs(ctx context.Context, tx *sql.Tx, ids []WalletID) (map[WalletID]*Wallet, error) {
+	dbIDs := make([]database.WalletID, len(ids))
+	for i := range ids {
+		dbIDs[i] = ids[i].ToDB()
+	}
+
+	ws, err := database.LockManyWalletsByIDs(ctx, tx, dbIDs)
+	if err != nil {
+		return nil, ErrLockManyWalletsByIDs.Wrap(err)
+	}
+
+	rv, err := newWalletsMapFromDB(ws)
+	if err != nil {
+		return nil, ErrLockManyWalletsByIDs.Wrap(err)
+	}
+
+	return rv, nil
+}
+
+func newWalletsMapFromDB(ws []database.Wallet) (map[WalletID]*Wallet, error) {
 	rv := make(map[WalletID]*Wallet)
 	for i := range ws {
 		w, err := NewWalletFromDB(ws[i])
 		if err != nil {
-			return nil, ErrFindManyWalletsByIDs.Wrap(err)
+			return nil, err
 		}
 
 		rv[w.ID] = w
@@ -137,13 +299,16 @@ func FindWalletByID(ctx context.Context, q database.ContextRowQueryExecutor, id
 	return rv, nil
 }
 
-func addFunds(ctx context.Context, q database.ContextRowQueryExecutor, wallet *Wallet, amount Decimal) (*Wallet, error) {
-	w, err := database.AddFunds(ctx, q, wallet.ID.ToDB(), amount.ToDB())
+// addFunds and removeFunds only update the cached balance of the wallet, use
+// postJournal to move funds.
+
+func addFunds(ctx context.Context, q database.ContextRowQueryExecutor, id WalletID, amount Decimal) (*Wallet, error) {
+	w, err := database.AddFunds(ctx, q, id.ToDB(), amount.ToDB())
 	if err != nil {
-		return nil, ErrAddFunds.Wrap(err)
+		return nil, ErrAddFunds.Wrap(constraintError(err))
 	}
 
-	wallet, err = NewWalletFromDB(w)
+	wallet, err := NewWalletFromDB(w)
 	if err != nil {
 		return nil, ErrAddFunds.Wrap(err)
 	}
@@ -151,16 +316,56 @@ func addFunds(ctx context.Context, q database.ContextRowQueryExecutor, wallet *W
 	return wallet, nil
 }
 
-func removeFunds(ctx context.Context, q database.ContextRowQueryExecutor, wallet *Wallet, amount Decimal) (*Wallet, error) {
-	w, err := database.RemoveFunds(ctx, q, wallet.ID.ToDB(), amount.ToDB())
+func removeFunds(ctx context.Context, q database.ContextRowQueryExecutor, id WalletID, amount Decimal) (*Wallet, error) {
+	w, err := database.RemoveFunds(ctx, q, id.ToDB(), amount.ToDB())
 	if err != nil {
-		return nil, ErrRemoveFunds.Wrap(err)
+		return nil, ErrRemoveFunds.Wrap(constraintError(err))
+	}
+	if w == nil {
+		return nil, ErrRemoveFunds.Wrap(ErrInsufficientFunds)
 	}
 
-	wallet, err = NewWalletFromDB(w)
+	wallet, err := NewWalletFromDB(w)
 	if err != nil {
 		return nil, ErrRemoveFunds.Wrap(err)
 	}
 
 	return wallet, nil
 }
+
+// holdFunds and releaseFunds move funds between the available and the held
+// part of the balance, the balance itself does not change.
+
+func holdFunds(ctx context.Context, q database.ContextRowQueryExecutor, id WalletID, amount Decimal) (*Wallet, error) {
+	w, err := database.HoldFunds(ctx, q, id.ToDB(), amount.ToDB())
+	if err != nil {
+		return nil, ErrHoldFunds.Wrap(constraintError(err))
+	}
+	if w == nil {
+		return nil, ErrHoldFunds.Wrap(ErrInsufficientFunds)
+	}
+
+	wallet, err := NewWalletFromDB(w)
+	if err != nil {
+		return nil, ErrHoldFunds.Wrap(err)
+	}
+
+	return wallet, nil
+}
+
+func releaseFunds(ctx context.Context, q database.ContextRowQueryExecutor, id WalletID, amount Decimal) (*Wallet, error) {
+	w, err := database.ReleaseFunds(ctx, q, id.ToDB(), amount.ToDB())
+	if err != nil {
+		return nil, ErrReleaseFunds.Wrap(constraintError(err))
+	}
+	if w == nil {
+		return nil, ErrReleaseFunds.New("wallet %v holds less than %v", id, amount)
+	}
+
+	wallet, err := NewWalletFromDB(w)
+	if err != nil {
+		return nil, ErrReleaseFunds.Wrap(err)
+	}
+
+	return wallet, nil
+}
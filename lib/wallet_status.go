@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/database"
+)
+
+var (
+	ErrChangeWalletStatus      = errs.Class("change wallet status")
+	ErrFindWalletStatusChanges = errs.Class("find wallet status changes")
+	ErrInvalidWalletStatus     = errs.Class("invalid wallet status")
+	ErrWalletStatusTransition  = errs.Class("wallet status cannot change")
+	ErrWalletFrozen            = errs.Class("wallet is frozen")
+	ErrWalletClosed            = errs.Class("wallet is closed")
+)
+
+// maxStatusReasonLength limits the reason of a status change.
+const maxStatusReasonLength = 500
+
+// Statuses of wallets. A frozen wallet cannot send funds and receives them
+// only if WalletStatusRule allows it. A closed wallet can neither send nor
+// receive and never reopens.
+const (
+	WalletActive = database.WalletActive
+	WalletFrozen = database.WalletFrozen
+	WalletClosed = database.WalletClosed
+)
+
+// walletStatusTransitions lists the statuses a wallet can move to from each status.
+var walletStatusTransitions = map[string][]string{
+	WalletActive: {WalletFrozen, WalletClosed},
+	WalletFrozen: {WalletActive},
+}
+
+func canChangeWalletStatus(from, to string) bool {
+	for _, s := range walletStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WalletStatusRule decides which wallets can take part in moving funds.
+type WalletStatusRule struct {
+	// FrozenCanReceive lets frozen wallets receive funds.
+	FrozenCanReceive bool
+}
+
+// checkSend fails unless the wallet is active.
+func (WalletStatusRule) checkSend(w *Wallet) error {
+	switch w.Status {
+	case WalletFrozen:
+		return ErrWalletFrozen.New("%v", w.ID)
+	case WalletClosed:
+		return ErrWalletClosed.New("%v", w.ID)
+	}
+
+	return nil
+}
+
+// checkReceive fails if the wallet is closed, or frozen and the rule does
+// not let frozen wallets receive.
+func (r WalletStatusRule) checkReceive(w *Wallet) error {
+	switch {
+	case w.Status == WalletFrozen && !r.FrozenCanReceive:
+		return ErrWalletFrozen.New("%v", w.ID)
+	case w.Status == WalletClosed:
+		return ErrWalletClosed.New("%v", w.ID)
+	}
+
+	return nil
+}
+
+// checkTransfer checks the sender and the receiver of a transfer.
+func (r WalletStatusRule) checkTransfer(from, to *Wallet) error {
+	if err := r.checkSend(from); err != nil {
+		return err
+	}
+
+	return r.checkReceive(to)
+}
+
+// WalletStatusChange records a change of the status of a wallet.
+type WalletStatusChange struct {
+	Wallet    WalletID
+	From      string
+	To        string
+	Reason    string
+	CreatedAt time.Time
+}
+
+func NewWalletStatusChangeFromDB(c database.WalletStatusChange) *WalletStatusChange {
+	return &WalletStatusChange{
+		Wallet:    WalletIDFromDB(c.Wallet()),
+		From:      c.From(),
+		To:        c.To(),
+		Reason:    c.Reason(),
+		CreatedAt: c.CreatedAt(),
+	}
+}
+
+type ChangeWalletStatusParams struct {
+	Wallet WalletID
+	Status string
+	// Reason is mandatory, it is kept with the change.
+	Reason string
+}
+
+// ChangeWalletStatus moves the wallet to the status. Frozen wallets can
+// only be reactivated, and only an active wallet with no funds, held or not,
+// no pending withdrawals and that does not collect fees can be closed.
+func ChangeWalletStatus(ctx context.Context, db *sql.DB, params *ChangeWalletStatusParams) (*Wallet, error) {
+	switch params.Status {
+	case WalletActive, WalletFrozen, WalletClosed:
+	default:
+		return nil, ErrChangeWalletStatus.Wrap(ErrInvalidWalletStatus.New("unknown status %q", params.Status))
+	}
+
+	reason := strings.TrimSpace(params.Reason)
+	if reason == "" {
+		return nil, ErrChangeWalletStatus.Wrap(ErrInvalidWalletStatus.New("missing reason"))
+	}
+	if len(reason) > maxStatusReasonLength {
+		return nil, ErrChangeWalletStatus.Wrap(ErrInvalidWalletStatus.New("reason is longer than %d", maxStatusReasonLength))
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, ErrChangeWalletStatus.Wrap(err)
+	}
+
+	w, err := changeWalletStatus(ctx, tx, params.Wallet, params.Status, reason)
+	if err != nil {
+		return nil, ErrChangeWalletStatus.Wrap(errs.Combine(err, tx.Rollback()))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, ErrChangeWalletStatus.Wrap(err)
+	}
+
+	return w, nil
+}
+
+func changeWalletStatus(ctx context.Context, tx *sql.Tx, id WalletID, status, reason string) (*Wallet, error) {
+	ws, err := LockManyWalletsByIDs(ctx, tx, []WalletID{id})
+	if err != nil {
+		return nil, err
+	}
+
+	w := ws[id]
+	if w == nil {
+		return nil, ErrWalletDoesNotExist.New("%v", id)
+	}
+	if !canChangeWalletStatus(w.Status, status) {
+		return nil, ErrWalletStatusTransition.New("%v cannot change from %q to %q", id, w.Status, status)
+	}
+
+	if status == WalletClosed {
+		if !w.Balance.IsZero() || !w.Held.IsZero() {
+			return nil, ErrWalletStatusTransition.New("%v has a balance of %v", id, w.Balance)
+		}
+		if feeWallet, ok := w.Currency.FeeWallet(); ok && feeWallet == id {
+			return nil, ErrWalletStatusTransition.New("%v is the fee wallet of %v", id, w.Currency)
+		}
+
+		// a failed withdrawal returns its funds to the wallet
+		n, err := database.CountPendingWithdrawals(ctx, tx, id.ToDB())
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, ErrWalletStatusTransition.New("%v has %d pending withdrawals", id, n)
+		}
+	}
+
+	dw, err := database.SetWalletStatus(ctx, tx, id.ToDB(), status)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = database.CreateWalletStatusChange(ctx, tx, &database.CreateWalletStatusChangeParams{
+		Wallet: id.ToDB(),
+		From:   w.Status,
+		To:     status,
+		Reason: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWalletFromDB(dw)
+}
+
+// FindWalletStatusChanges returns the status changes of the wallet from the oldest.
+func FindWalletStatusChanges(ctx context.Context, q database.ContextQuerier, id WalletID) ([]*WalletStatusChange, error) {
+	cs, err := database.FindWalletStatusChanges(ctx, q, id.ToDB())
+	if err != nil {
+		return nil, ErrFindWalletStatusChanges.Wrap(err)
+	}
+
+	rv := make([]*WalletStatusChange, len(cs))
+	for i, c := range cs {
+		rv[i] = NewWalletStatusChangeFromDB(c)
+	}
+
+	return rv, nil
+}
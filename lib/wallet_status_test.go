@@ -0,0 +1,190 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/errs"
+)
+
+func TestCanChangeWalletStatus(t *testing.T) {
+	tests := []struct {
+		from, to string
+		ok       bool
+	}{
+		{WalletActive, WalletActive, false},
+		{WalletActive, WalletFrozen, true},
+		{WalletActive, WalletClosed, true},
+		{WalletFrozen, WalletActive, true},
+		{WalletFrozen, WalletFrozen, false},
+		{WalletFrozen, WalletClosed, false},
+		{WalletClosed, WalletActive, false},
+		{WalletClosed, WalletFrozen, false},
+		{WalletClosed, WalletClosed, false},
+	}
+
+	for _, tt := range tests {
+		if got := canChangeWalletStatus(tt.from, tt.to); got != tt.ok {
+			t.Errorf("%s to %s: got %v, want %v", tt.from, tt.to, got, tt.ok)
+		}
+	}
+}
+
+func TestWalletStatusRule(t *testing.T) {
+	frozen, closed := &ErrWalletFrozen, &ErrWalletClosed
+
+	tests := []struct {
+		status           string
+		frozenCanReceive bool
+		send, receive    *errs.Class
+	}{
+		{WalletActive, false, nil, nil},
+		{WalletActive, true, nil, nil},
+		{WalletFrozen, false, frozen, frozen},
+		{WalletFrozen, true, frozen, nil},
+		{WalletClosed, false, closed, closed},
+		{WalletClosed, true, closed, closed},
+	}
+
+	check := func(err error, want *errs.Class) bool {
+		if want == nil {
+			return err == nil
+		}
+		return want.Has(err)
+	}
+
+	for _, tt := range tests {
+		r := WalletStatusRule{FrozenCanReceive: tt.frozenCanReceive}
+		w := Wallet{ID: 1, Status: tt.status}
+
+		if err := r.checkSend(&w); !check(err, tt.send) {
+			t.Errorf("%s, %v: unexpected send error %v", tt.status, tt.frozenCanReceive, err)
+		}
+		if err := r.checkReceive(&w); !check(err, tt.receive) {
+			t.Errorf("%s, %v: unexpected receive error %v", tt.status, tt.frozenCanReceive, err)
+		}
+	}
+}
+
+func TestChangeWalletStatusInvalid(t *testing.T) {
+	tests := []ChangeWalletStatusParams{
+		{Wallet: 1, Status: "deleted", Reason: "test"},
+		{Wallet: 1, Status: WalletFrozen, Reason: ""},
+		{Wallet: 1, Status: WalletFrozen, Reason: " "},
+		{Wallet: 1, Status: WalletFrozen, Reason: strings.Repeat("r", maxStatusReasonLength+1)},
+	}
+
+	for _, params := range tests {
+		params := params
+		// the params are checked before the database is used
+		_, err := ChangeWalletStatus(context.Background(), nil, &params)
+		if !ErrInvalidWalletStatus.Has(err) {
+			t.Errorf("%q, %.10q: want ErrInvalidWalletStatus, got %v", params.Status, params.Reason, err)
+		}
+	}
+}
+
+func TestCloseWalletWithPendingWithdrawal(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	w := createTestWallet(t, db, testCurrency(t, "BTC"), mustDecimal(t, "10"))
+
+	_, withdrawal, err := Withdraw(ctx, db, &ExternalTransactionParams{
+		Wallet:    w.ID,
+		Amount:    mustDecimal(t, "10"),
+		Reference: fmt.Sprintf("test-close-%v", w.ID),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeWallet := func() error {
+		_, err := ChangeWalletStatus(ctx, db, &ChangeWalletStatusParams{Wallet: w.ID, Status: WalletClosed, Reason: "test"})
+		return err
+	}
+
+	if err := closeWallet(); !ErrWalletStatusTransition.Has(err) {
+		t.Fatalf("want ErrWalletStatusTransition, got %v", err)
+	}
+
+	if _, _, err := FinishWithdrawal(ctx, db, withdrawal.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeWallet(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestFailWithdrawalOfClosedWallet(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	w := createTestWallet(t, db, testCurrency(t, "BTC"), mustDecimal(t, "10"))
+
+	_, withdrawal, err := Withdraw(ctx, db, &ExternalTransactionParams{
+		Wallet:    w.ID,
+		Amount:    mustDecimal(t, "10"),
+		Reference: fmt.Sprintf("test-closed-%v", w.ID),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a wallet closed before closing checked for pending withdrawals
+	_, err = db.ExecContext(ctx, `update wallets set status = 'closed' where id = $1`, int64(w.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := FinishWithdrawal(ctx, db, withdrawal.ID, false); !ErrWalletClosed.Has(err) {
+		t.Fatalf("want ErrWalletClosed, got %v", err)
+	}
+
+	found, err := FindExternalTransactionByID(ctx, db, withdrawal.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Status != StatusPending {
+		t.Errorf("status %q, want the withdrawal to stay pending", found.Status)
+	}
+	if got := findTestWallet(t, db, w.ID); !got.Balance.IsZero() {
+		t.Errorf("balance %v, want 0", got.Balance)
+	}
+}
+
+func TestQuoteTransferWalletStatus(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	btc := testCurrency(t, "BTC")
+	from := createTestWallet(t, db, btc, mustDecimal(t, "10"))
+	to := createTestWallet(t, db, btc, Decimal{})
+
+	_, err := ChangeWalletStatus(ctx, db, &ChangeWalletStatusParams{Wallet: to.ID, Status: WalletFrozen, Reason: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, frozenCanReceive := range []bool{false, true} {
+		quote, err := QuoteTransfer(ctx, db, &QuoteTransferParams{
+			From:   from.ID,
+			To:     to.ID,
+			Amount: mustDecimal(t, "1"),
+			Fee:    NewFlatFee("test", Decimal{}),
+			Status: WalletStatusRule{FrozenCanReceive: frozenCanReceive},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if frozenCanReceive && quote.Err != nil {
+			t.Errorf("frozen can receive: unexpected error: %v", quote.Err)
+		}
+		if !frozenCanReceive && !ErrWalletFrozen.Has(quote.Err) {
+			t.Errorf("want ErrWalletFrozen, got %v", quote.Err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateMetadata(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := 0; i <= maxMetadataKeys; i++ {
+		tooMany["k"+strconv.Itoa(i)] = "v"
+	}
+	full := map[string]string{}
+	for i := 0; i < maxMetadataKeys; i++ {
+		full["k"+strconv.Itoa(i)] = "v"
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		valid    bool
+	}{
+		{"nil", nil, true},
+		{"empty", map[string]string{}, true},
+		{"max keys", full, true},
+		{"too many keys", tooMany, false},
+		{"empty key", map[string]string{"": "v"}, false},
+		{"max key length", map[string]string{strings.Repeat("k", maxMetadataKeyLength): "v"}, true},
+		{"key too long", map[string]string{strings.Repeat("k", maxMetadataKeyLength+1): "v"}, false},
+		{"multibyte key", map[string]string{strings.Repeat("ключ", maxMetadataKeyLength/4): "v"}, true},
+		{"empty value", map[string]string{"k": ""}, true},
+		{"max value length", map[string]string{"k": strings.Repeat("v", maxMetadataValueLength)}, true},
+		{"value too long", map[string]string{"k": strings.Repeat("v", maxMetadataValueLength+1)}, false},
+	}
+
+	for _, tt := range tests {
+		err := validateMetadata(tt.metadata)
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.valid && !ErrInvalidMetadata.Has(err) {
+			t.Errorf("%s: want ErrInvalidMetadata, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestFundWalletInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount string
+		reason string
+	}{
+		{"missing reason", "1", ""},
+		{"blank reason", "1", "  "},
+		{"zero amount", "0", "opening balance"},
+		{"negative amount", "-1", "opening balance"},
+	}
+
+	for _, tt := range tests {
+		params := FundWalletParams{Wallet: 1, Amount: mustDecimal(t, tt.amount), Reason: tt.reason}
+		// the params are checked before the database is used
+		_, _, err := FundWallet(context.Background(), nil, &params)
+		if !ErrInvalidFunding.Has(err) {
+			t.Errorf("%s: want ErrInvalidFunding, got %v", tt.name, err)
+		}
+	}
+}
@@ -7,16 +7,26 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	_ "github.com/lib/pq"
 
 	"github.com/defbin/walletdb/database"
+	"github.com/defbin/walletdb/lib"
 	"github.com/defbin/walletdb/web"
 )
 
 // todo: make configurable
 const ServerAddr = ":8080"
 
+const (
+	// DefaultIdempotencyRetention is used if IDEMPOTENCY_RETENTION is not set.
+	DefaultIdempotencyRetention = 24 * time.Hour
+	IdempotencyCleanupInterval  = time.Hour
+	HoldExpiryInterval          = time.Minute
+)
+
 func main() {
 	databaseURL := os.Getenv("DATABASE_URL")
 	if len(databaseURL) == 0 {
@@ -29,7 +39,52 @@ func main() {
 	}
 	defer db.Close()
 
-	handler := withDB(db, web.MakeRouter())
+	err = lib.LoadCurrencies(context.Background(), db)
+	if err != nil {
+		log.Fatalf("walletdb: %v\n", err)
+	}
+
+	feePolicy, err := loadFeePolicy(db)
+	if err != nil {
+		log.Fatalf("walletdb: %v\n", err)
+	}
+
+	retention, err := idempotencyRetention()
+	if err != nil {
+		log.Fatalf("walletdb: %v\n", err)
+	}
+
+	feeRefundRule, err := feeRefundRule()
+	if err != nil {
+		log.Fatalf("walletdb: %v\n", err)
+	}
+
+	exchangeRates, err := loadExchangeRates(db)
+	if err != nil {
+		log.Fatalf("walletdb: %v\n", err)
+	}
+
+	fxSpread, err := fxSpread()
+	if err != nil {
+		log.Fatalf("walletdb: %v\n", err)
+	}
+
+	walletStatusRule, err := walletStatusRule()
+	if err != nil {
+		log.Fatalf("walletdb: %v\n", err)
+	}
+
+	go cleanupIdempotencyKeys(db)
+	go expireHolds(db)
+
+	var handler http.Handler = web.MakeRouter()
+	handler = withValue("walletdb:idempotency-retention", retention, handler)
+	handler = withValue("walletdb:fee-policy", feePolicy, handler)
+	handler = withValue("walletdb:fee-refund-rule", feeRefundRule, handler)
+	handler = withValue("walletdb:exchange-rates", exchangeRates, handler)
+	handler = withValue("walletdb:fx-spread", fxSpread, handler)
+	handler = withValue("walletdb:wallet-status-rule", walletStatusRule, handler)
+	handler = withDB(db, handler)
 
 	fmt.Println("walletdb: starting")
 	log.Fatalf("walletdb: %v\n", http.ListenAndServe(ServerAddr, handler))
@@ -41,3 +96,114 @@ func withDB(db *sql.DB, next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+func withValue(key string, value interface{}, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), key, value)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// loadFeePolicy reads the policy from FEE_POLICY_FILE if it is set and
+// falls back to the active policy in the database.
+func loadFeePolicy(db *sql.DB) (lib.FeePolicy, error) {
+	if path := os.Getenv("FEE_POLICY_FILE"); len(path) != 0 {
+		return lib.LoadFeePolicyFile(path)
+	}
+
+	return lib.LoadActiveFeePolicy(context.Background(), db)
+}
+
+// idempotencyRetention reads how long idempotency keys are kept from
+// IDEMPOTENCY_RETENTION, e.g. "48h".
+func idempotencyRetention() (time.Duration, error) {
+	s := os.Getenv("IDEMPOTENCY_RETENTION")
+	if len(s) == 0 {
+		return DefaultIdempotencyRetention, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// feeRefundRule reads the rule for refunding fees of reversed transfers from
+// FEE_REFUND_RULE: "none" (default), "proportional" or "full".
+func feeRefundRule() (lib.FeeRefundRule, error) {
+	s := os.Getenv("FEE_REFUND_RULE")
+	if len(s) == 0 {
+		return lib.FeeRefundNone, nil
+	}
+
+	return lib.ParseFeeRefundRule(s)
+}
+
+// loadExchangeRates reads static rates from EXCHANGE_RATES_FILE if it is set
+// and falls back to the rates in the database.
+func loadExchangeRates(db *sql.DB) (lib.ExchangeRateProvider, error) {
+	if path := os.Getenv("EXCHANGE_RATES_FILE"); len(path) != 0 {
+		return lib.LoadExchangeRatesFile(path)
+	}
+
+	return lib.NewDBExchangeRates(db), nil
+}
+
+// fxSpread reads the percentage of converted amounts kept as revenue from
+// FX_SPREAD, e.g. "0.5" for 0.5%. There is no spread by default.
+func fxSpread() (lib.Decimal, error) {
+	s := os.Getenv("FX_SPREAD")
+	if len(s) == 0 {
+		return lib.Decimal{}, nil
+	}
+
+	v, err := lib.NewDecimalFromString(s)
+	if err != nil {
+		return lib.Decimal{}, err
+	}
+	if v.Sign() < 0 || lib.NewDecimal(100, 0).Less(v) {
+		return lib.Decimal{}, fmt.Errorf("FX_SPREAD must be in [0, 100]: %v", v)
+	}
+
+	return v, nil
+}
+
+// walletStatusRule reads from FROZEN_WALLETS_CAN_RECEIVE whether frozen
+// wallets can receive funds. They cannot by default.
+func walletStatusRule() (lib.WalletStatusRule, error) {
+	s := os.Getenv("FROZEN_WALLETS_CAN_RECEIVE")
+	if len(s) == 0 {
+		return lib.WalletStatusRule{}, nil
+	}
+
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return lib.WalletStatusRule{}, fmt.Errorf("FROZEN_WALLETS_CAN_RECEIVE: %v", err)
+	}
+
+	return lib.WalletStatusRule{FrozenCanReceive: v}, nil
+}
+
+func cleanupIdempotencyKeys(db *sql.DB) {
+	for range time.Tick(IdempotencyCleanupInterval) {
+		n, err := lib.DeleteExpiredIdempotencyKeys(context.Background(), db)
+		if err != nil {
+			log.Printf("walletdb: %v\n", err)
+			continue
+		}
+
+		if n != 0 {
+			log.Printf("walletdb: deleted %d expired idempotency keys\n", n)
+		}
+	}
+}
+
+func expireHolds(db *sql.DB) {
+	for range time.Tick(HoldExpiryInterval) {
+		n, err := lib.ExpireHolds(context.Background(), db)
+		if err != nil {
+			log.Printf("walletdb: %v\n", err)
+		}
+
+		if n != 0 {
+			log.Printf("walletdb: expired %d holds\n", n)
+		}
+	}
+}
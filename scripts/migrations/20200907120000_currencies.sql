@@ -0,0 +1,23 @@
+-- migrate:up
+create table currencies (
+    code        text primary key check (code ~ '^[A-Z0-9]{2,10}$'),
+    name        text                                                    not null,
+    scale       integer                                                 not null check (scale >= 0),
+    min_unit    decimal                                                 not null check (min_unit > 0),
+    rounding    text    default 'half_even'                             not null check (rounding in ('half_even', 'down', 'up')),
+    enabled     boolean default true                                    not null
+);
+
+insert into currencies (code, name, scale, min_unit)
+values ('BTC', 'Bitcoin', 8, 0.00000001),
+       ('ETH', 'Ether', 18, 0.000000000000000001);
+
+alter table wallets alter column currency type text using currency::text;
+alter table wallets add constraint wallets_currency_fkey foreign key (currency) references currencies(code);
+drop type currency;
+
+-- migrate:down
+create type currency as enum ('BTC', 'ETH');
+alter table wallets drop constraint wallets_currency_fkey;
+alter table wallets alter column currency type currency using currency::currency;
+drop table currencies;
@@ -0,0 +1,16 @@
+-- migrate:up
+alter table currencies add column fee_wallet integer references wallets(id);
+
+-- every existing currency collects its fees into a new empty wallet
+with fee_wallets as (
+    insert into wallets (balance, currency)
+    select 0, code from currencies
+    returning id, currency
+)
+update currencies c set fee_wallet = f.id from fee_wallets f where f.currency = c.code;
+
+alter table transactions add column fee_wallet integer references wallets(id);
+
+-- migrate:down
+alter table transactions drop column fee_wallet;
+alter table currencies drop column fee_wallet;
@@ -0,0 +1,19 @@
+-- migrate:up
+create table fee_policies (
+    id          text primary key,
+    spec        jsonb                           not null,
+    active      boolean     default false       not null,
+    created_at  timestamptz default now()       not null
+);
+
+-- at most one policy is active at a time
+create unique index fee_policies_active_idx on fee_policies (active) where active;
+
+insert into fee_policies (id, spec, active)
+values ('default', '{"type": "percentage", "percent": "1.5"}', true);
+
+alter table transactions add column fee_policy text;
+
+-- migrate:down
+alter table transactions drop column fee_policy;
+drop table fee_policies;
@@ -0,0 +1,15 @@
+-- migrate:up
+create table idempotency_keys (
+    key             text primary key,
+    request_hash    text                                    not null,
+    status          integer,
+    response        bytea,
+    transfer_id     integer references transactions(id),
+    created_at      timestamptz default now()               not null,
+    expires_at      timestamptz                             not null
+);
+
+create index idempotency_keys_expires_at_idx on idempotency_keys (expires_at);
+
+-- migrate:down
+drop table idempotency_keys;
@@ -0,0 +1,9 @@
+-- migrate:up
+create index transactions_created_at_id_idx on transactions (created_at, id);
+create index transactions_sender_created_at_id_idx on transactions (sender, created_at, id);
+create index transactions_receiver_created_at_id_idx on transactions (receiver, created_at, id);
+
+-- migrate:down
+drop index transactions_receiver_created_at_id_idx;
+drop index transactions_sender_created_at_id_idx;
+drop index transactions_created_at_id_idx;
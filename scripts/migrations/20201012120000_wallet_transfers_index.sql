@@ -0,0 +1,6 @@
+-- migrate:up
+-- sender and receiver are covered by the indexes of the transfer listing
+create index transactions_fee_wallet_created_at_id_idx on transactions (fee_wallet, created_at, id);
+
+-- migrate:down
+drop index transactions_fee_wallet_created_at_id_idx;
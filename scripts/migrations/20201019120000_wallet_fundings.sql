@@ -0,0 +1,28 @@
+-- migrate:up
+alter table wallets add column metadata jsonb default '{}' not null;
+
+create table fundings (
+    id          serial primary key,
+    wallet      integer references wallets(id)  not null,
+    amount      decimal                         not null check (amount > 0),
+    reason      text                            not null,
+    created_at  timestamptz default now()       not null
+);
+
+create index fundings_wallet_created_at_id_idx on fundings (wallet, created_at, id);
+
+-- balances that are not explained by transfers become opening fundings
+insert into fundings (wallet, amount, reason, created_at)
+select id, opening, 'opening balance (backfill)', 'epoch'
+from (
+    select w.id, w.balance
+        - coalesce((select sum(amount) from transactions where receiver = w.id), 0)
+        + coalesce((select sum(amount + fee_amount) from transactions where sender = w.id), 0)
+        - coalesce((select sum(fee_amount) from transactions where fee_wallet = w.id), 0) as opening
+    from wallets w
+) o
+where opening > 0;
+
+-- migrate:down
+drop table fundings;
+alter table wallets drop column metadata;
@@ -0,0 +1,18 @@
+-- migrate:up
+create table external_transactions (
+    id          serial primary key,
+    wallet      integer references wallets(id)  not null,
+    kind        text                            not null check (kind in ('deposit', 'withdrawal')),
+    amount      decimal                         not null check (amount > 0),
+    reference   text                            not null,
+    status      text                            not null check (status in ('pending', 'completed', 'failed')),
+    created_at  timestamptz default now()       not null,
+    finished_at timestamptz,
+    unique (kind, reference)
+);
+
+create index external_transactions_wallet_created_at_id_idx on external_transactions (wallet, created_at, id);
+create index external_transactions_pending_idx on external_transactions (created_at) where status = 'pending';
+
+-- migrate:down
+drop table external_transactions;
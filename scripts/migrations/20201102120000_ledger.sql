@@ -0,0 +1,133 @@
+-- migrate:up
+create table journals (
+    id                      serial primary key,
+    kind                    text                                        not null,
+    transfer                integer references transactions(id),
+    funding                 integer references fundings(id),
+    external_transaction    integer references external_transactions(id),
+    created_at              timestamptz default now()                   not null
+);
+
+-- amounts are signed: a positive amount increases the balance of the account
+create table ledger_entries (
+    id              serial primary key,
+    journal         integer references journals(id)     not null,
+    account         text                                not null,
+    wallet          integer references wallets(id),
+    currency        text references currencies(code)    not null,
+    amount          decimal                             not null check (amount <> 0),
+    -- only wallet accounts keep a running balance, in the order of ids
+    balance_after   decimal,
+    check (wallet is null or account = 'wallet:' || wallet)
+);
+
+create index journals_transfer_idx on journals (transfer);
+create index ledger_entries_journal_idx on ledger_entries (journal);
+create index ledger_entries_wallet_id_idx on ledger_entries (wallet, id);
+create index ledger_entries_account_idx on ledger_entries (account);
+
+-- backfill the history: one journal per transfer, funding and step of an external transaction
+insert into journals (kind, transfer, funding, external_transaction, created_at)
+select kind, transfer, funding, external_transaction, created_at
+from (
+    select 'transfer' as kind, id as transfer, null::integer as funding, null::integer as external_transaction, created_at
+    from transactions
+    union all
+    select 'funding', null, id, null, created_at from fundings
+    union all
+    select kind, null, null, id, created_at from external_transactions
+    union all
+    select 'withdrawal_' || status, null, null, id, finished_at
+    from external_transactions where kind = 'withdrawal' and status <> 'pending'
+) h
+order by created_at, kind, coalesce(transfer, funding, external_transaction);
+
+create temporary table backfill_entries as
+select j.id as journal, j.created_at, p.ord, p.account, p.wallet, p.currency, p.amount
+from journals j
+left join transactions t on t.id = j.transfer
+left join wallets s on s.id = t.sender
+left join fundings f on f.id = j.funding
+left join external_transactions x on x.id = j.external_transaction
+left join wallets w on w.id = coalesce(f.wallet, x.wallet)
+cross join lateral (
+    select 1 as ord, 'wallet:' || t.sender as account, t.sender as wallet, s.currency, -(t.amount + t.fee_amount) as amount
+    where j.kind = 'transfer'
+    union all
+    select 2, 'wallet:' || t.receiver, t.receiver, s.currency, t.amount
+    where j.kind = 'transfer'
+    union all
+    select 3, coalesce('wallet:' || t.fee_wallet, 'revenue:fees:' || s.currency), t.fee_wallet, s.currency, t.fee_amount
+    where j.kind = 'transfer' and t.fee_amount > 0
+    union all
+    select 1, 'wallet:' || f.wallet, f.wallet, w.currency, f.amount
+    where j.kind = 'funding'
+    union all
+    select 2, 'equity:funding:' || w.currency, null, w.currency, -f.amount
+    where j.kind = 'funding'
+    union all
+    select 1, 'wallet:' || x.wallet, x.wallet, w.currency, x.amount
+    where j.kind = 'deposit'
+    union all
+    select 2, 'external:' || w.currency, null, w.currency, -x.amount
+    where j.kind = 'deposit'
+    union all
+    select 1, 'wallet:' || x.wallet, x.wallet, w.currency, -x.amount
+    where j.kind = 'withdrawal'
+    union all
+    select 2, 'clearing:withdrawals:' || w.currency, null, w.currency, x.amount
+    where j.kind = 'withdrawal'
+    union all
+    select 1, 'clearing:withdrawals:' || w.currency, null, w.currency, -x.amount
+    where j.kind in ('withdrawal_completed', 'withdrawal_failed')
+    union all
+    select 2, 'external:' || w.currency, null, w.currency, x.amount
+    where j.kind = 'withdrawal_completed'
+    union all
+    select 2, 'wallet:' || x.wallet, x.wallet, w.currency, x.amount
+    where j.kind = 'withdrawal_failed'
+) p;
+
+-- balances that the history does not explain are booked against equity
+create temporary table backfill_diffs as
+select w.id, w.currency, w.balance - coalesce(sum(b.amount), 0) as diff
+from wallets w left join backfill_entries b on b.wallet = w.id
+group by w.id
+having w.balance <> coalesce(sum(b.amount), 0);
+
+with opening as (
+    insert into journals (kind, created_at)
+    select distinct 'opening:' || currency, 'epoch'::timestamptz from backfill_diffs
+    returning id, kind
+)
+insert into backfill_entries (journal, created_at, ord, account, wallet, currency, amount)
+select o.id, 'epoch', 1, 'wallet:' || d.id, d.id, d.currency, d.diff
+from backfill_diffs d join opening o on o.kind = 'opening:' || d.currency
+union all
+select o.id, 'epoch', 2, 'equity:opening:' || d.currency, null, d.currency, -sum(d.diff)
+from backfill_diffs d join opening o on o.kind = 'opening:' || d.currency
+group by o.id, d.currency;
+
+update journals set kind = 'opening' where kind like 'opening:%';
+
+insert into ledger_entries (journal, account, wallet, currency, amount)
+select journal, account, wallet, currency, amount
+from backfill_entries
+where amount <> 0
+order by created_at, journal, ord, wallet;
+
+update ledger_entries e set balance_after = b.balance_after
+from (
+    select id, sum(amount) over (partition by wallet order by id) as balance_after
+    from ledger_entries where wallet is not null
+) b
+where b.id = e.id;
+
+alter table ledger_entries add check ((wallet is null) = (balance_after is null));
+
+drop table backfill_diffs;
+drop table backfill_entries;
+
+-- migrate:down
+drop table ledger_entries;
+drop table journals;
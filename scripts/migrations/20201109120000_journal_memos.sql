@@ -0,0 +1,6 @@
+-- migrate:up
+-- adjustments explain why they were posted
+alter table journals add column memo text;
+
+-- migrate:down
+alter table journals drop column memo;
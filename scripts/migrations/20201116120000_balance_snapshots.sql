@@ -0,0 +1,17 @@
+-- migrate:up
+-- entries are stamped with the time of their journal
+alter table ledger_entries add column created_at timestamptz default now() not null;
+update ledger_entries e set created_at = j.created_at from journals j where j.id = e.journal;
+create index ledger_entries_wallet_created_at_idx on ledger_entries (wallet, created_at);
+
+-- balance_snapshots.balance is the sum of the ledger entries of the wallet created at or before at
+create table balance_snapshots (
+    wallet      integer references wallets(id)  not null,
+    at          timestamptz                     not null,
+    balance     decimal                         not null,
+    primary key (wallet, at)
+);
+
+-- migrate:down
+drop table balance_snapshots;
+alter table ledger_entries drop column created_at;
@@ -0,0 +1,23 @@
+-- migrate:up
+-- held is the sum of the pending holds of the wallet, the available balance is balance - held
+alter table wallets add column held decimal default 0 not null check (held >= 0);
+
+create table holds (
+    id          serial primary key,
+    sender      integer references wallets(id)      not null,
+    receiver    integer references wallets(id)      not null,
+    amount      decimal                             not null check (amount > 0),
+    fee_amount  decimal                             not null check (fee_amount >= 0),
+    fee_policy  text,
+    status      text                                not null check (status in ('pending', 'captured', 'voided', 'expired')),
+    transfer    integer references transactions(id),
+    expires_at  timestamptz                         not null,
+    created_at  timestamptz default now()           not null,
+    finished_at timestamptz
+);
+
+create index holds_pending_expires_at_idx on holds (expires_at) where status = 'pending';
+
+-- migrate:down
+drop table holds;
+alter table wallets drop column held;
@@ -0,0 +1,11 @@
+-- migrate:up
+-- a reversal moves funds of the transfer reversal_of back from its receiver to its sender,
+-- fee_refund is the part of the original fee returned from fee_wallet to the receiver of the reversal
+alter table transactions
+    add column reversal_of integer references transactions(id),
+    add column fee_refund  decimal default 0 not null check (fee_refund >= 0);
+
+create index transactions_reversal_of_idx on transactions (reversal_of) where reversal_of is not null;
+
+-- migrate:down
+alter table transactions drop column fee_refund, drop column reversal_of;
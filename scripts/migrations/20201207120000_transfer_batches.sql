@@ -0,0 +1,14 @@
+-- migrate:up
+-- the transfers of a batch are applied in one database transaction, in the order of their ids
+create table transfer_batches (
+    id          serial primary key,
+    created_at  timestamptz default now() not null
+);
+
+alter table transactions add column batch integer references transfer_batches(id);
+
+create index transactions_batch_idx on transactions (batch) where batch is not null;
+
+-- migrate:down
+alter table transactions drop column batch;
+drop table transfer_batches;
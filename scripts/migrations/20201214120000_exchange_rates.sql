@@ -0,0 +1,36 @@
+-- migrate:up
+-- 1 base = rate quote, in effect from valid_from (inclusive) to valid_to (exclusive, open if null)
+create table exchange_rates (
+    id          serial primary key,
+    base        text references currencies(code)    not null,
+    quote       text references currencies(code)    not null,
+    rate        decimal                             not null check (rate > 0),
+    valid_from  timestamptz                         not null,
+    valid_to    timestamptz,
+    created_at  timestamptz default now()           not null,
+    check (base <> quote),
+    check (valid_to is null or valid_to > valid_from)
+);
+
+create index exchange_rates_pair_idx on exchange_rates (base, quote, valid_from desc);
+
+-- Cross-currency transfers: amount is in the currency of the sender and
+-- destination_amount in the currency of the receiver. exchange_rate_id
+-- identifies the rate in its provider, like fee_policy does for fees.
+-- fx_spread is the part of amount * exchange_rate kept as revenue by spread_wallet.
+alter table transactions
+    add column destination_amount   decimal check (destination_amount > 0),
+    add column exchange_rate        decimal check (exchange_rate > 0),
+    add column exchange_rate_id     text,
+    add column fx_spread            decimal default 0 not null check (fx_spread >= 0),
+    add column spread_wallet        integer references wallets(id),
+    add check ((destination_amount is null) = (exchange_rate is null));
+
+-- migrate:down
+alter table transactions
+    drop column spread_wallet,
+    drop column fx_spread,
+    drop column exchange_rate_id,
+    drop column exchange_rate,
+    drop column destination_amount;
+drop table exchange_rates;
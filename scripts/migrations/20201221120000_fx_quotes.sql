@@ -0,0 +1,22 @@
+-- migrate:up
+-- A quote locks the conversion of amount from sender to receiver until expires_at.
+-- base and quote are the currencies of the sender and the receiver.
+-- transfer is set when the quote is used, a quote can only be used once.
+create table fx_quotes (
+    id                  serial primary key,
+    sender              integer references wallets(id)      not null,
+    receiver            integer references wallets(id)      not null,
+    base                text references currencies(code)    not null,
+    quote               text references currencies(code)    not null,
+    amount              decimal                             not null check (amount > 0),
+    destination_amount  decimal                             not null check (destination_amount > 0),
+    exchange_rate       decimal                             not null check (exchange_rate > 0),
+    exchange_rate_id    text                                not null,
+    fx_spread           decimal                             not null check (fx_spread >= 0),
+    transfer            integer unique references transactions(id),
+    expires_at          timestamptz                         not null,
+    created_at          timestamptz default now()           not null
+);
+
+-- migrate:down
+drop table fx_quotes;
@@ -0,0 +1,19 @@
+-- migrate:up
+-- An owner holds at most one wallet per currency unless allow_multiple_wallets is set.
+-- The rule depends on the flag, so it is enforced by the application under a lock on the owner.
+create table owners (
+    id                      serial primary key,
+    name                    text                        not null check (length(name) > 0),
+    metadata                jsonb default '{}'          not null,
+    allow_multiple_wallets  boolean default false       not null,
+    created_at              timestamptz default now()   not null,
+    updated_at              timestamptz default now()   not null
+);
+
+alter table wallets add column owner_id integer references owners(id);
+
+create index wallets_owner_id_idx on wallets (owner_id, currency) where owner_id is not null;
+
+-- migrate:down
+alter table wallets drop column owner_id;
+drop table owners;
@@ -0,0 +1,19 @@
+-- migrate:up
+-- frozen wallets cannot send funds, closed wallets can neither send nor receive and never reopen
+alter table wallets add column status text default 'active' not null check (status in ('active', 'frozen', 'closed'));
+
+-- every status change is kept with the reason given for it
+create table wallet_status_changes (
+    id          serial primary key,
+    wallet      integer references wallets(id)  not null,
+    from_status text                            not null,
+    to_status   text                            not null,
+    reason      text                            not null check (length(reason) > 0),
+    created_at  timestamptz default now()       not null
+);
+
+create index wallet_status_changes_wallet_idx on wallet_status_changes (wallet, created_at);
+
+-- migrate:down
+drop table wallet_status_changes;
+alter table wallets drop column status;
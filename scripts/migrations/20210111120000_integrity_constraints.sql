@@ -0,0 +1,40 @@
+-- migrate:up
+-- The application checks all of these under row locks, the constraints catch what slips through.
+--
+-- Existing rows may break the new rules, and the migration cannot tell how they should be
+-- fixed. So every check is added "not valid": it holds for new and updated rows right away,
+-- existing rows are left as they are. Once the rows these queries return are fixed, the
+-- checks are validated separately, see "Integrity constraints" in the README:
+--
+--   select id from wallets where balance < -overdraft_limit;
+--   select id from transactions where sender is null or receiver is null or amount <= 0 or fee_amount < 0;
+
+-- A balance can go below zero down to the overdraft limit of the wallet, which is 0 unless
+-- an operator sets it.
+alter table wallets add column overdraft_limit decimal default 0 not null
+    constraint wallets_overdraft_limit_check check (overdraft_limit >= 0);
+alter table wallets add constraint wallets_balance_check check (balance >= -overdraft_limit) not valid;
+
+-- SET NOT NULL would scan the table and fail on existing nulls, check constraints do the same job
+alter table transactions
+    add constraint transactions_sender_not_null check (sender is not null) not valid,
+    add constraint transactions_receiver_not_null check (receiver is not null) not valid,
+    add constraint transactions_amount_check check (amount > 0) not valid,
+    add constraint transactions_fee_amount_check check (fee_amount >= 0) not valid;
+
+-- Transfers to the same wallet used to be accepted. These checks are never validated, the
+-- existing transfers are kept.
+alter table transactions add constraint transactions_sender_receiver_check check (sender <> receiver) not valid;
+alter table holds add constraint holds_sender_receiver_check check (sender <> receiver) not valid;
+
+-- migrate:down
+alter table holds drop constraint holds_sender_receiver_check;
+alter table transactions
+    drop constraint transactions_sender_receiver_check,
+    drop constraint transactions_fee_amount_check,
+    drop constraint transactions_amount_check,
+    drop constraint transactions_receiver_not_null,
+    drop constraint transactions_sender_not_null;
+alter table wallets
+    drop constraint wallets_balance_check,
+    drop column overdraft_limit;
@@ -1,5 +1,26 @@
-insert into wallets (balance, currency)
-values (10.0, 'BTC'),
-       (10.0, 'ETH'),
-       (20.0, 'BTC'),
-       (20.0, 'ETH');
+-- opening balances are recorded as fundings with their ledger entries
+with seeded as (
+    insert into wallets (balance, currency)
+    values (10.0, 'BTC'),
+           (10.0, 'ETH'),
+           (20.0, 'BTC'),
+           (20.0, 'ETH')
+    returning id, balance, currency
+), seeded_fundings as (
+    insert into fundings (wallet, amount, reason)
+    select id, balance, 'seed' from seeded
+    returning id, wallet, amount
+), seeded_journals as (
+    insert into journals (kind, funding)
+    select 'funding', id from seeded_fundings
+    returning id, funding
+)
+insert into ledger_entries (journal, account, wallet, currency, amount, balance_after)
+select j.id, a.account, a.wallet, s.currency, a.amount, a.balance_after
+from seeded_journals j
+join seeded_fundings f on f.id = j.funding
+join seeded s on s.id = f.wallet
+cross join lateral (
+    values ('wallet:' || s.id, s.id, f.amount, f.amount),
+           ('equity:funding:' || s.currency, null, -f.amount, null)
+) a (account, wallet, amount, balance_after);
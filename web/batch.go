@@ -0,0 +1,171 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type batchBody struct {
+	Legs []transferBody `json:"legs"`
+}
+
+type batchResponse struct {
+	ID string `json:"id"`
+	// Transfers are in the order of the legs.
+	Transfers []*transferResponse `json:"transfers"`
+	Time      time.Time           `json:"time"`
+}
+
+func batchToResponse(b *lib.Batch) *batchResponse {
+	br := batchResponse{
+		ID:        b.ID.String(),
+		Transfers: make([]*transferResponse, len(b.Transfers)),
+		Time:      b.CreatedAt,
+	}
+	for i := range b.Transfers {
+		br.Transfers[i] = transferToResponse(b.Transfers[i])
+	}
+
+	return &br
+}
+
+func batchLocation(id lib.BatchID) string {
+	return "/transfers/batch/" + id.String()
+}
+
+func transferBatch(w http.ResponseWriter, r *http.Request) {
+	var body batchBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	params := lib.TransferBatchParams{
+		Legs:   make([]lib.BatchLeg, len(body.Legs)),
+		Fee:    getFeePolicy(r.Context()),
+		Status: getWalletStatusRule(r.Context()),
+	}
+	for i, leg := range body.Legs {
+		from, to, err := parseWalletIDs(leg.From, leg.To)
+		if err != nil {
+			http.Error(w, lib.ErrInvalidBatch.New("leg %d: %v", i, err).Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		amount, err := lib.NewDecimalFromString(leg.Amount)
+		if err != nil {
+			http.Error(w, lib.ErrInvalidBatch.New("leg %d: %v", i, err).Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		params.Legs[i] = lib.BatchLeg{From: from, To: to, Amount: amount}
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	batch, err := doTransferBatch(r.Context(), db, &params)
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrFeeWalletNotConfigured.Has(err):
+			// the server is misconfigured, not the request
+			status = http.StatusInternalServerError
+		// a leg can still fail on a database constraint after the checks
+		case lib.ErrInvalidBatch.Has(err) || lib.ErrTransferFunds.Has(err) || lib.ErrInvalidAmount.Has(err):
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(batchToResponse(batch))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", batchLocation(batch.ID))
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("transferBatch handler: %v\n", err.Error())
+	}
+}
+
+// doTransferBatch applies the whole batch in one transaction.
+func doTransferBatch(ctx context.Context, db *sql.DB, params *lib.TransferBatchParams) (*lib.Batch, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	batch, err := lib.TransferBatch(ctx, tx, params)
+	if err != nil {
+		if err := tx.Rollback(); err != nil {
+			log.Printf("transferBatch handler: %v", err)
+		}
+
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("transferBatch handler: %v", err)
+
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+func batchByID(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	batchID, err := lib.ParseBatchID(mux.Vars(r)["batchID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	batch, err := lib.FindBatchByID(r.Context(), db, batchID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if batch == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	j, err := json.Marshal(batchToResponse(batch))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("batchByID handler: %v\n", err.Error())
+	}
+}
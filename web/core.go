@@ -10,9 +10,51 @@ func MakeRouter() *mux.Router {
 	router := mux.NewRouter()
 
 	router.HandleFunc("/wallets", allWallets).Methods(http.MethodGet)
+	router.HandleFunc("/wallets", createWallet).Methods(http.MethodPost)
 	router.HandleFunc("/wallets/{walletID}", walletByID).Methods(http.MethodGet)
+	router.HandleFunc("/wallets/{walletID}/transfers", walletTransfers).Methods(http.MethodGet)
+	router.HandleFunc("/wallets/{walletID}/balance", walletBalance).Methods(http.MethodGet)
+	router.HandleFunc("/wallets/{walletID}/statement", walletStatement).Methods(http.MethodGet)
+	router.HandleFunc("/wallets/{walletID}/deposits", deposit).Methods(http.MethodPost)
+	router.HandleFunc("/wallets/{walletID}/withdrawals", withdraw).Methods(http.MethodPost)
+	router.HandleFunc("/owners", allOwners).Methods(http.MethodGet)
+	router.HandleFunc("/owners", createOwner).Methods(http.MethodPost)
+	router.HandleFunc("/owners/{ownerID}", ownerByID).Methods(http.MethodGet)
+	router.HandleFunc("/owners/{ownerID}", updateOwner).Methods(http.MethodPatch)
+	router.HandleFunc("/owners/{ownerID}", deleteOwner).Methods(http.MethodDelete)
+	router.HandleFunc("/owners/{ownerID}/wallets", ownerWallets).Methods(http.MethodGet)
+	router.HandleFunc("/deposits/{depositID}", depositByID).Methods(http.MethodGet)
+	router.HandleFunc("/withdrawals/{withdrawalID}", withdrawalByID).Methods(http.MethodGet)
 	router.HandleFunc("/transfer", allTransfers).Methods(http.MethodGet)
 	router.HandleFunc("/transfer", transferFunds).Methods(http.MethodPost)
+	router.HandleFunc("/transfer/quote", quoteTransfer).Methods(http.MethodPost)
+	router.HandleFunc("/transfer/{transferID}", transferByID).Methods(http.MethodGet)
+	router.HandleFunc("/transfer/{transferID}/reversals", reverseTransfer).Methods(http.MethodPost)
+	router.HandleFunc("/transfers/batch", transferBatch).Methods(http.MethodPost)
+	router.HandleFunc("/transfers/batch/{batchID}", batchByID).Methods(http.MethodGet)
+	router.HandleFunc("/holds", createHold).Methods(http.MethodPost)
+	router.HandleFunc("/holds/{holdID}", holdByID).Methods(http.MethodGet)
+	router.HandleFunc("/holds/{holdID}/capture", captureHold).Methods(http.MethodPost)
+	router.HandleFunc("/holds/{holdID}/void", voidHold).Methods(http.MethodPost)
+	router.HandleFunc("/currencies", allCurrencies).Methods(http.MethodGet)
+	router.HandleFunc("/fx/rates/{base}/{quote}", exchangeRate).Methods(http.MethodGet)
+	router.HandleFunc("/fx/quotes", createFXQuote).Methods(http.MethodPost)
+	router.HandleFunc("/fx/quotes/{quoteID}", fxQuoteByID).Methods(http.MethodGet)
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/currencies", createCurrency).Methods(http.MethodPost)
+	admin.HandleFunc("/currencies/refresh", refreshCurrencies).Methods(http.MethodPost)
+	admin.HandleFunc("/currencies/{code}/enable", enableCurrency).Methods(http.MethodPost)
+	admin.HandleFunc("/currencies/{code}/disable", disableCurrency).Methods(http.MethodPost)
+	admin.HandleFunc("/currencies/{code}/fee-wallet", setFeeWallet).Methods(http.MethodPut)
+	admin.HandleFunc("/wallets/{walletID}/fundings", fundWallet).Methods(http.MethodPost)
+	admin.HandleFunc("/wallets/{walletID}/status", changeWalletStatus).Methods(http.MethodPost)
+	admin.HandleFunc("/wallets/{walletID}/status-changes", walletStatusChanges).Methods(http.MethodGet)
+	admin.HandleFunc("/withdrawals/pending", pendingWithdrawals).Methods(http.MethodGet)
+	admin.HandleFunc("/withdrawals/{withdrawalID}/complete", completeWithdrawal).Methods(http.MethodPost)
+	admin.HandleFunc("/withdrawals/{withdrawalID}/fail", failWithdrawal).Methods(http.MethodPost)
+	admin.HandleFunc("/reports/fees", feeRevenueReport).Methods(http.MethodGet)
+	admin.HandleFunc("/exchange-rates", createExchangeRate).Methods(http.MethodPost)
 
 	return router
 }
@@ -0,0 +1,238 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type currencyBody struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Scale    int32  `json:"scale"`
+	MinUnit  string `json:"min_unit"`
+	Rounding string `json:"rounding"`
+}
+
+type currencyResponse struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Scale     int32  `json:"scale"`
+	MinUnit   string `json:"min_unit"`
+	Rounding  string `json:"rounding"`
+	Enabled   bool   `json:"enabled"`
+	FeeWallet string `json:"fee_wallet,omitempty"`
+}
+
+type feeWalletBody struct {
+	Wallet string `json:"wallet"`
+}
+
+func currencyToResponse(c lib.Currency) *currencyResponse {
+	cr := currencyResponse{
+		Code:     c.String(),
+		Name:     c.Name(),
+		Scale:    c.Scale(),
+		MinUnit:  c.MinUnit().String(),
+		Rounding: c.Rounding().String(),
+		Enabled:  c.Enabled(),
+	}
+	if id, ok := c.FeeWallet(); ok {
+		cr.FeeWallet = id.String()
+	}
+
+	return &cr
+}
+
+func allCurrencies(w http.ResponseWriter, r *http.Request) {
+	currencies := lib.AllCurrencies()
+
+	cr := make([]*currencyResponse, len(currencies))
+	for i := range currencies {
+		cr[i] = currencyToResponse(currencies[i])
+	}
+
+	j, err := json.Marshal(map[string][]*currencyResponse{"data": cr})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("allCurrencies handler: %v\n", err.Error())
+	}
+}
+
+func createCurrency(w http.ResponseWriter, r *http.Request) {
+	var body currencyBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	params := lib.CreateCurrencyParams{
+		Code:  body.Code,
+		Name:  body.Name,
+		Scale: body.Scale,
+		// one unit of the last decimal place
+		MinUnit:  lib.NewDecimal(1, body.Scale),
+		Rounding: lib.RoundHalfEven,
+	}
+	if body.MinUnit != "" {
+		params.MinUnit, err = lib.NewDecimalFromString(body.MinUnit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+	if body.Rounding != "" {
+		params.Rounding, err = lib.ParseRoundingMode(body.Rounding)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	c, err := lib.CreateCurrency(r.Context(), db, &params)
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrInvalidCurrency.Has(err):
+			status = http.StatusBadRequest
+		case lib.ErrCurrencyAlreadyExists.Has(err):
+			status = http.StatusConflict
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(currencyToResponse(c))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("createCurrency handler: %v\n", err.Error())
+	}
+}
+
+func enableCurrency(w http.ResponseWriter, r *http.Request) {
+	setCurrencyEnabled(w, r, true)
+}
+
+func disableCurrency(w http.ResponseWriter, r *http.Request) {
+	setCurrencyEnabled(w, r, false)
+}
+
+func setCurrencyEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	c, err := lib.SetCurrencyEnabled(r.Context(), db, mux.Vars(r)["code"], enabled)
+	if err != nil {
+		if lib.ErrUnsupportedCurrency.Has(err) {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	j, err := json.Marshal(currencyToResponse(c))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("setCurrencyEnabled handler: %v\n", err.Error())
+	}
+}
+
+func setFeeWallet(w http.ResponseWriter, r *http.Request) {
+	var body feeWalletBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	walletID, err := lib.ParseWalletID(body.Wallet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	c, err := lib.SetFeeWallet(r.Context(), db, mux.Vars(r)["code"], walletID)
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrUnsupportedCurrency.Has(err):
+			status = http.StatusNotFound
+		case lib.ErrInvalidFeeWallet.Has(err):
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(currencyToResponse(c))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("setFeeWallet handler: %v\n", err.Error())
+	}
+}
+
+func refreshCurrencies(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	err := lib.LoadCurrencies(r.Context(), db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	allCurrencies(w, r)
+}
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/defbin/walletdb/database"
+	"github.com/defbin/walletdb/lib"
+)
+
+// openTestDB connects to the database in DATABASE_URL, which must be
+// migrated (make migrate). Tests that need it are skipped if it is not set.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if len(databaseURL) == 0 {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	db, err := database.OpenDB(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := lib.LoadCurrencies(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// testHandler serves the router with the values the server puts in the
+// request context, with no fees and no currency conversions.
+func testHandler(db *sql.DB) http.Handler {
+	values := map[string]interface{}{
+		"walletdb:db":                    db,
+		"walletdb:idempotency-retention": time.Hour,
+		"walletdb:fee-policy":            lib.NewFlatFee("test", lib.Decimal{}),
+		"walletdb:fee-refund-rule":       lib.FeeRefundNone,
+		"walletdb:exchange-rates":        lib.NewStaticExchangeRates(nil),
+		"walletdb:fx-spread":             lib.Decimal{},
+		"walletdb:wallet-status-rule":    lib.WalletStatusRule{},
+	}
+
+	router := MakeRouter()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		for k, v := range values {
+			ctx = context.WithValue(ctx, k, v)
+		}
+
+		router.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func createTestWallet(t *testing.T, db *sql.DB, code string, balance int64) *lib.Wallet {
+	t.Helper()
+
+	ctx := context.Background()
+
+	c, err := lib.NewCurrency(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := lib.CreateWallet(ctx, db, &lib.CreateWalletParams{Currency: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance == 0 {
+		return w
+	}
+
+	w, _, err = lib.FundWallet(ctx, db, &lib.FundWalletParams{
+		Wallet: w.ID,
+		Amount: lib.NewDecimalFromInt(balance),
+		Reason: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func findTestWallet(t *testing.T, db *sql.DB, id lib.WalletID) *lib.Wallet {
+	t.Helper()
+
+	w, err := lib.FindWalletByID(context.Background(), db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w == nil {
+		t.Fatalf("wallet %v does not exist", id)
+	}
+
+	return w
+}
@@ -0,0 +1,238 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type externalTransactionBody struct {
+	Amount    string `json:"amount"`
+	Reference string `json:"reference"`
+}
+
+type externalTransactionResponse struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Wallet     string     `json:"wallet"`
+	Amount     string     `json:"amount"`
+	Reference  string     `json:"reference"`
+	Status     string     `json:"status"`
+	Time       time.Time  `json:"time"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func externalTransactionToResponse(t *lib.ExternalTransaction) *externalTransactionResponse {
+	return &externalTransactionResponse{
+		ID:         t.ID.String(),
+		Kind:       t.Kind,
+		Wallet:     t.Wallet.String(),
+		Amount:     t.Amount.String(),
+		Reference:  t.Reference,
+		Status:     t.Status,
+		Time:       t.CreatedAt,
+		FinishedAt: t.FinishedAt,
+	}
+}
+
+func deposit(w http.ResponseWriter, r *http.Request) {
+	createExternalTransaction(w, r, lib.Deposit, http.StatusCreated)
+}
+
+// withdraw responds with 202 as the withdrawal is pending until it is
+// completed or failed by an operator.
+func withdraw(w http.ResponseWriter, r *http.Request) {
+	createExternalTransaction(w, r, lib.Withdraw, http.StatusAccepted)
+}
+
+type externalTransactionFunc func(ctx context.Context, db *sql.DB, params *lib.ExternalTransactionParams) (*lib.Wallet, *lib.ExternalTransaction, error)
+
+func createExternalTransaction(w http.ResponseWriter, r *http.Request, f externalTransactionFunc, status int) {
+	walletID, err := lib.ParseWalletID(mux.Vars(r)["walletID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var body externalTransactionBody
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	amount, err := lib.NewDecimalFromString(body.Amount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	params := lib.ExternalTransactionParams{
+		Wallet:    walletID,
+		Amount:    amount,
+		Reference: body.Reference,
+		Status:    getWalletStatusRule(r.Context()),
+	}
+
+	_, t, err := f(r.Context(), db, &params)
+	if err != nil {
+		http.Error(w, err.Error(), externalTransactionErrorStatus(err))
+
+		return
+	}
+
+	j, err := json.Marshal(externalTransactionToResponse(t))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", "/"+t.Kind+"s/"+t.ID.String())
+	w.WriteHeader(status)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("createExternalTransaction handler: %v\n", err.Error())
+	}
+}
+
+func externalTransactionErrorStatus(err error) int {
+	switch {
+	case lib.ErrWalletDoesNotExist.Has(err) || lib.ErrExternalTransactionDoesNotExist.Has(err):
+		return http.StatusNotFound
+	case lib.ErrDuplicateExternalReference.Has(err) || lib.ErrWithdrawalNotPending.Has(err):
+		return http.StatusConflict
+	case lib.ErrWalletFrozen.Has(err) || lib.ErrWalletClosed.Has(err):
+		return http.StatusConflict
+	case errs.Is(err, lib.ErrInsufficientFunds),
+		lib.ErrInvalidExternalTransaction.Has(err),
+		lib.ErrInvalidAmount.Has(err),
+		lib.ErrCurrencyDisabled.Has(err):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func depositByID(w http.ResponseWriter, r *http.Request) {
+	externalTransactionByID(w, r, lib.KindDeposit, "depositID")
+}
+
+func withdrawalByID(w http.ResponseWriter, r *http.Request) {
+	externalTransactionByID(w, r, lib.KindWithdrawal, "withdrawalID")
+}
+
+func externalTransactionByID(w http.ResponseWriter, r *http.Request, kind, param string) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	id, err := lib.ParseExternalTransactionID(mux.Vars(r)[param])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	t, err := lib.FindExternalTransactionByID(r.Context(), db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if t == nil || t.Kind != kind {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	j, err := json.Marshal(externalTransactionToResponse(t))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("externalTransactionByID handler: %v\n", err.Error())
+	}
+}
+
+func pendingWithdrawals(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	ts, err := lib.FindPendingWithdrawals(r.Context(), db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	tr := make([]*externalTransactionResponse, len(ts))
+	for i := range ts {
+		tr[i] = externalTransactionToResponse(ts[i])
+	}
+
+	j, err := json.Marshal(map[string][]*externalTransactionResponse{"data": tr})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("pendingWithdrawals handler: %v\n", err.Error())
+	}
+}
+
+func completeWithdrawal(w http.ResponseWriter, r *http.Request) {
+	finishWithdrawal(w, r, true)
+}
+
+func failWithdrawal(w http.ResponseWriter, r *http.Request) {
+	finishWithdrawal(w, r, false)
+}
+
+func finishWithdrawal(w http.ResponseWriter, r *http.Request, success bool) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	id, err := lib.ParseExternalTransactionID(mux.Vars(r)["withdrawalID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	_, t, err := lib.FinishWithdrawal(r.Context(), db, id, success)
+	if err != nil {
+		http.Error(w, err.Error(), externalTransactionErrorStatus(err))
+
+		return
+	}
+
+	j, err := json.Marshal(externalTransactionToResponse(t))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("finishWithdrawal handler: %v\n", err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type fundingBody struct {
+	Amount string `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+type fundingResponse struct {
+	ID     string          `json:"id"`
+	Amount string          `json:"amount"`
+	Reason string          `json:"reason"`
+	Time   time.Time       `json:"time"`
+	Wallet *walletResponse `json:"wallet"`
+}
+
+func fundWallet(w http.ResponseWriter, r *http.Request) {
+	walletID, err := lib.ParseWalletID(mux.Vars(r)["walletID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var body fundingBody
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	amount, err := lib.NewDecimalFromString(body.Amount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	params := lib.FundWalletParams{
+		Wallet: walletID,
+		Amount: amount,
+		Reason: body.Reason,
+		Status: getWalletStatusRule(r.Context()),
+	}
+
+	wlt, funding, err := lib.FundWallet(r.Context(), db, &params)
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrWalletDoesNotExist.Has(err):
+			status = http.StatusNotFound
+		case lib.ErrWalletFrozen.Has(err) || lib.ErrWalletClosed.Has(err):
+			status = http.StatusConflict
+		case lib.ErrInvalidFunding.Has(err) || lib.ErrInvalidAmount.Has(err) || lib.ErrCurrencyDisabled.Has(err):
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(&fundingResponse{
+		ID:     funding.ID.String(),
+		Amount: wlt.Currency.Format(funding.Amount),
+		Reason: funding.Reason,
+		Time:   funding.CreatedAt,
+		Wallet: walletToResponse(wlt),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("fundWallet handler: %v\n", err.Error())
+	}
+}
@@ -0,0 +1,327 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type exchangeRateBody struct {
+	Base  string `json:"base"`
+	Quote string `json:"quote"`
+	Rate  string `json:"rate"`
+	// ValidFrom defaults to now, ValidTo to no end. Both are RFC 3339.
+	ValidFrom *time.Time `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+}
+
+type exchangeRateResponse struct {
+	ID        string     `json:"id"`
+	Base      string     `json:"base"`
+	Quote     string     `json:"quote"`
+	Rate      string     `json:"rate"`
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+}
+
+func exchangeRateToResponse(r *lib.ExchangeRate) *exchangeRateResponse {
+	return &exchangeRateResponse{
+		ID:        r.ID,
+		Base:      r.Base.String(),
+		Quote:     r.Quote.String(),
+		Rate:      r.Rate.String(),
+		ValidFrom: r.ValidFrom,
+		ValidTo:   r.ValidTo,
+	}
+}
+
+func getExchangeRates(ctx context.Context) lib.ExchangeRateProvider {
+	return ctx.Value("walletdb:exchange-rates").(lib.ExchangeRateProvider)
+}
+
+func getFXSpread(ctx context.Context) lib.Decimal {
+	return ctx.Value("walletdb:fx-spread").(lib.Decimal)
+}
+
+// exchangeRate returns the rate TransferFunds would use at the time given by
+// the "at" parameter, now by default.
+func exchangeRate(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	base, err := lib.NewCurrency(vars["base"])
+	if err != nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	quote, err := lib.NewCurrency(vars["quote"])
+	if err != nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	at, err := parseTimeParam(r.URL.Query(), "at")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if at == nil {
+		now := time.Now()
+		at = &now
+	}
+
+	rate, err := getExchangeRates(r.Context()).ExchangeRate(r.Context(), base, quote, *at)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if rate == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	j, err := json.Marshal(exchangeRateToResponse(rate))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("exchangeRate handler: %v\n", err.Error())
+	}
+}
+
+// createExchangeRate adds a rate to the database. The rates are only used if
+// the server is not configured with a rates file.
+func createExchangeRate(w http.ResponseWriter, r *http.Request) {
+	var body exchangeRateBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	base, err := lib.NewCurrency(body.Base)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	quote, err := lib.NewCurrency(body.Quote)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	rate, err := lib.NewDecimalFromString(body.Rate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	params := lib.CreateExchangeRateParams{
+		Base:      base,
+		Quote:     quote,
+		Rate:      rate,
+		ValidFrom: time.Now(),
+		ValidTo:   body.ValidTo,
+	}
+	if body.ValidFrom != nil {
+		params.ValidFrom = *body.ValidFrom
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	er, err := lib.CreateExchangeRate(r.Context(), db, &params)
+	if err != nil {
+		var status int
+		if lib.ErrInvalidExchangeRate.Has(err) {
+			status = http.StatusBadRequest
+		} else {
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(exchangeRateToResponse(er))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("createExchangeRate handler: %v\n", err.Error())
+	}
+}
+
+type fxQuoteBody struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+}
+
+type fxQuoteResponse struct {
+	ID                string    `json:"id"`
+	From              string    `json:"from"`
+	To                string    `json:"to"`
+	Base              string    `json:"base"`
+	Quote             string    `json:"quote"`
+	Rate              string    `json:"rate"`
+	ExchangeRateID    string    `json:"exchange_rate_id"`
+	Amount            string    `json:"amount"`
+	DestinationAmount string    `json:"destination_amount"`
+	FXSpread          string    `json:"fx_spread"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	Time              time.Time `json:"time"`
+	// Transfer is set once the quote is used.
+	Transfer string `json:"transfer,omitempty"`
+}
+
+func fxQuoteToResponse(q *lib.FXQuote) *fxQuoteResponse {
+	qr := fxQuoteResponse{
+		ID:                q.ID.String(),
+		From:              q.From.String(),
+		To:                q.To.String(),
+		Base:              q.Base.String(),
+		Quote:             q.Quote.String(),
+		Rate:              q.ExchangeRate.String(),
+		ExchangeRateID:    q.ExchangeRateID,
+		Amount:            q.Amount.String(),
+		DestinationAmount: q.DestinationAmount.String(),
+		FXSpread:          q.FXSpread.String(),
+		ExpiresAt:         q.ExpiresAt,
+		Time:              q.CreatedAt,
+	}
+	if q.Transfer != nil {
+		qr.Transfer = q.Transfer.String()
+	}
+
+	return &qr
+}
+
+func createFXQuote(w http.ResponseWriter, r *http.Request) {
+	var body fxQuoteBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	from, to, err := parseWalletIDs(body.From, body.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	amount, err := lib.NewDecimalFromString(body.Amount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	q, err := lib.CreateFXQuote(r.Context(), db, &lib.CreateFXQuoteParams{
+		From:     from,
+		To:       to,
+		Amount:   amount,
+		Rates:    getExchangeRates(r.Context()),
+		FXSpread: getFXSpread(r.Context()),
+	})
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrWalletDoesNotExist.Has(err) || lib.ErrExchangeRateNotFound.Has(err):
+			status = http.StatusNotFound
+		case lib.ErrInvalidFXQuote.Has(err),
+			lib.ErrInvalidAmount.Has(err),
+			errs.Is(err, lib.ErrUnsupportedCurrencyConversation):
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(fxQuoteToResponse(q))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", "/fx/quotes/"+q.ID.String())
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("createFXQuote handler: %v\n", err.Error())
+	}
+}
+
+func fxQuoteByID(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	id, err := lib.ParseFXQuoteID(mux.Vars(r)["quoteID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	q, err := lib.FindFXQuoteByID(r.Context(), db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if q == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	j, err := json.Marshal(fxQuoteToResponse(q))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("fxQuoteByID handler: %v\n", err.Error())
+	}
+}
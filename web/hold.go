@@ -0,0 +1,244 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type holdBody struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+	// ExpiresIn is a duration, e.g. "24h". The default is lib.DefaultHoldTTL.
+	ExpiresIn string `json:"expires_in"`
+}
+
+type captureBody struct {
+	// Amount defaults to the held amount.
+	Amount string `json:"amount"`
+}
+
+type holdResponse struct {
+	ID         string     `json:"id"`
+	From       string     `json:"from"`
+	To         string     `json:"to"`
+	Amount     string     `json:"amount"`
+	FeeAmount  string     `json:"fee_amount"`
+	FeePolicy  string     `json:"fee_policy,omitempty"`
+	Status     string     `json:"status"`
+	Transfer   string     `json:"transfer,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Time       time.Time  `json:"time"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+func holdToResponse(h *lib.Hold) *holdResponse {
+	hr := holdResponse{
+		ID:         h.ID.String(),
+		From:       h.From.String(),
+		To:         h.To.String(),
+		Amount:     h.Amount.String(),
+		FeeAmount:  h.FeeAmount.String(),
+		FeePolicy:  h.FeePolicy,
+		Status:     h.Status,
+		ExpiresAt:  h.ExpiresAt,
+		Time:       h.CreatedAt,
+		FinishedAt: h.FinishedAt,
+	}
+	if h.Transfer != nil {
+		hr.Transfer = h.Transfer.String()
+	}
+
+	return &hr
+}
+
+func holdErrorStatus(err error) int {
+	switch {
+	case lib.ErrWalletDoesNotExist.Has(err) || lib.ErrHoldDoesNotExist.Has(err):
+		return http.StatusNotFound
+	case lib.ErrHoldNotPending.Has(err) || lib.ErrHoldExpired.Has(err):
+		return http.StatusConflict
+	case lib.ErrWalletFrozen.Has(err) || lib.ErrWalletClosed.Has(err):
+		return http.StatusConflict
+	case errs.Is(err, lib.ErrInsufficientFunds),
+		errs.Is(err, lib.ErrTransferToSameWallet),
+		errs.Is(err, lib.ErrUnsupportedCurrencyConversation),
+		lib.ErrInvalidHold.Has(err),
+		lib.ErrInvalidAmount.Has(err),
+		lib.ErrCurrencyDisabled.Has(err):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeHold(w http.ResponseWriter, h *lib.Hold, status int, handler string) {
+	j, err := json.Marshal(holdToResponse(h))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if status == http.StatusCreated {
+		w.Header().Set("Location", "/holds/"+h.ID.String())
+	}
+
+	w.WriteHeader(status)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("%s handler: %v\n", handler, err.Error())
+	}
+}
+
+func createHold(w http.ResponseWriter, r *http.Request) {
+	var body holdBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	from, to, err := parseWalletIDs(body.From, body.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	amount, err := lib.NewDecimalFromString(body.Amount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	params := lib.CreateHoldParams{
+		From:   from,
+		To:     to,
+		Amount: amount,
+		Fee:    getFeePolicy(r.Context()),
+		Status: getWalletStatusRule(r.Context()),
+	}
+	if body.ExpiresIn != "" {
+		params.TTL, err = time.ParseDuration(body.ExpiresIn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	h, err := lib.CreateHold(r.Context(), db, &params)
+	if err != nil {
+		http.Error(w, err.Error(), holdErrorStatus(err))
+
+		return
+	}
+
+	writeHold(w, h, http.StatusCreated, "createHold")
+}
+
+func holdByID(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	id, err := lib.ParseHoldID(mux.Vars(r)["holdID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	h, err := lib.FindHoldByID(r.Context(), db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if h == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	writeHold(w, h, http.StatusOK, "holdByID")
+}
+
+// captureHold accepts an empty body to capture the whole hold.
+func captureHold(w http.ResponseWriter, r *http.Request) {
+	id, err := lib.ParseHoldID(mux.Vars(r)["holdID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var body captureBody
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var amount *lib.Decimal
+	if body.Amount != "" {
+		v, err := lib.NewDecimalFromString(body.Amount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		amount = &v
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	h, err := lib.CaptureHold(r.Context(), db, id, amount, getFeePolicy(r.Context()), getWalletStatusRule(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), holdErrorStatus(err))
+
+		return
+	}
+
+	if h.Transfer != nil {
+		w.Header().Set("Content-Location", transferLocation(*h.Transfer))
+	}
+
+	writeHold(w, h, http.StatusOK, "captureHold")
+}
+
+func voidHold(w http.ResponseWriter, r *http.Request) {
+	id, err := lib.ParseHoldID(mux.Vars(r)["holdID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	h, err := lib.VoidHold(r.Context(), db, id)
+	if err != nil {
+		http.Error(w, err.Error(), holdErrorStatus(err))
+
+		return
+	}
+
+	writeHold(w, h, http.StatusOK, "voidHold")
+}
@@ -0,0 +1,274 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type ownerBody struct {
+	Name                 string            `json:"name"`
+	Metadata             map[string]string `json:"metadata"`
+	AllowMultipleWallets bool              `json:"allow_multiple_wallets"`
+}
+
+// ownerPatchBody changes the fields that are present.
+type ownerPatchBody struct {
+	Name                 *string           `json:"name"`
+	Metadata             map[string]string `json:"metadata"`
+	AllowMultipleWallets *bool             `json:"allow_multiple_wallets"`
+}
+
+type ownerResponse struct {
+	ID                   string            `json:"id"`
+	Name                 string            `json:"name"`
+	Metadata             map[string]string `json:"metadata,omitempty"`
+	AllowMultipleWallets bool              `json:"allow_multiple_wallets"`
+	Time                 time.Time         `json:"time"`
+	UpdatedAt            time.Time         `json:"updated_at"`
+}
+
+type currencyTotalResponse struct {
+	Currency         string `json:"currency"`
+	Wallets          int    `json:"wallets"`
+	Balance          string `json:"balance"`
+	AvailableBalance string `json:"available_balance"`
+}
+
+type ownerWalletsResponse struct {
+	Data   []*walletResponse        `json:"data"`
+	Totals []*currencyTotalResponse `json:"totals"`
+}
+
+func ownerToResponse(o *lib.Owner) *ownerResponse {
+	return &ownerResponse{
+		ID:                   o.ID.String(),
+		Name:                 o.Name,
+		Metadata:             o.Metadata,
+		AllowMultipleWallets: o.AllowMultipleWallets,
+		Time:                 o.CreatedAt,
+		UpdatedAt:            o.UpdatedAt,
+	}
+}
+
+func ownerErrorStatus(err error) int {
+	switch {
+	case lib.ErrOwnerDoesNotExist.Has(err):
+		return http.StatusNotFound
+	case lib.ErrOwnerHasWallets.Has(err):
+		return http.StatusConflict
+	case lib.ErrInvalidOwner.Has(err) || lib.ErrInvalidMetadata.Has(err):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeOwner(w http.ResponseWriter, o *lib.Owner, status int, handler string) {
+	j, err := json.Marshal(ownerToResponse(o))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if status == http.StatusCreated {
+		w.Header().Set("Location", "/owners/"+o.ID.String())
+	}
+
+	w.WriteHeader(status)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("%s handler: %v\n", handler, err.Error())
+	}
+}
+
+func allOwners(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	owners, err := lib.FindAllOwners(r.Context(), db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	or := make([]*ownerResponse, len(owners))
+	for i := range owners {
+		or[i] = ownerToResponse(owners[i])
+	}
+
+	j, err := json.Marshal(map[string][]*ownerResponse{"data": or})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("allOwners handler: %v\n", err.Error())
+	}
+}
+
+func createOwner(w http.ResponseWriter, r *http.Request) {
+	var body ownerBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	o, err := lib.CreateOwner(r.Context(), db, &lib.CreateOwnerParams{
+		Name:                 body.Name,
+		Metadata:             body.Metadata,
+		AllowMultipleWallets: body.AllowMultipleWallets,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), ownerErrorStatus(err))
+
+		return
+	}
+
+	writeOwner(w, o, http.StatusCreated, "createOwner")
+}
+
+func ownerByID(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	id, err := lib.ParseOwnerID(mux.Vars(r)["ownerID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	o, err := lib.FindOwnerByID(r.Context(), db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if o == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	writeOwner(w, o, http.StatusOK, "ownerByID")
+}
+
+func updateOwner(w http.ResponseWriter, r *http.Request) {
+	id, err := lib.ParseOwnerID(mux.Vars(r)["ownerID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var body ownerPatchBody
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	o, err := lib.UpdateOwner(r.Context(), db, id, &lib.UpdateOwnerParams{
+		Name:                 body.Name,
+		Metadata:             body.Metadata,
+		AllowMultipleWallets: body.AllowMultipleWallets,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), ownerErrorStatus(err))
+
+		return
+	}
+
+	writeOwner(w, o, http.StatusOK, "updateOwner")
+}
+
+// deleteOwner only deletes owners without wallets.
+func deleteOwner(w http.ResponseWriter, r *http.Request) {
+	id, err := lib.ParseOwnerID(mux.Vars(r)["ownerID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	err = lib.DeleteOwner(r.Context(), db, id)
+	if err != nil {
+		http.Error(w, err.Error(), ownerErrorStatus(err))
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ownerWallets(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	id, err := lib.ParseOwnerID(mux.Vars(r)["ownerID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	ow, err := lib.FindOwnerWallets(r.Context(), db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if ow == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	resp := ownerWalletsResponse{
+		Data:   make([]*walletResponse, len(ow.Wallets)),
+		Totals: make([]*currencyTotalResponse, len(ow.Totals)),
+	}
+	for i, wlt := range ow.Wallets {
+		resp.Data[i] = walletToResponse(wlt)
+	}
+	for i, t := range ow.Totals {
+		resp.Totals[i] = &currencyTotalResponse{
+			Currency:         t.Currency.String(),
+			Wallets:          t.Wallets,
+			Balance:          t.Currency.Format(t.Balance),
+			AvailableBalance: t.Currency.Format(t.Available),
+		}
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("ownerWallets handler: %v\n", err.Error())
+	}
+}
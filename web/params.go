@@ -0,0 +1,86 @@
+package web
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+var errInvalidParam = errs.Class("invalid query parameter")
+
+// The parsers below return nil if the parameter is absent.
+
+func parseWalletIDParam(q url.Values, name string) (*lib.WalletID, error) {
+	s := q.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+
+	id, err := lib.ParseWalletID(s)
+	if err != nil {
+		return nil, errInvalidParam.New("%s: %q", name, s)
+	}
+
+	return &id, nil
+}
+
+func parseDecimalParam(q url.Values, name string) (*lib.Decimal, error) {
+	s := q.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+
+	d, err := lib.NewDecimalFromString(s)
+	if err != nil {
+		return nil, errInvalidParam.New("%s: %q", name, s)
+	}
+
+	return &d, nil
+}
+
+func parseCurrencyParam(q url.Values, name string) (*lib.Currency, error) {
+	s := q.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+
+	c, err := lib.NewCurrency(s)
+	if err != nil {
+		return nil, errInvalidParam.Wrap(err)
+	}
+
+	return &c, nil
+}
+
+// parseTimeParam expects RFC 3339.
+func parseTimeParam(q url.Values, name string) (*time.Time, error) {
+	s := q.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errInvalidParam.New("%s: %q", name, s)
+	}
+
+	return &t, nil
+}
+
+func parseIntParam(q url.Values, name string) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errInvalidParam.New("%s: %q", name, s)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type feeRevenueResponse struct {
+	Currency string `json:"currency"`
+	Count    int64  `json:"count"`
+	Total    string `json:"total"`
+	FXSpread string `json:"fx_spread"`
+}
+
+func feeRevenueToResponse(f *lib.FeeRevenue) *feeRevenueResponse {
+	return &feeRevenueResponse{
+		Currency: f.Currency.String(),
+		Count:    f.Count,
+		Total:    f.Currency.Format(f.Total),
+		FXSpread: f.Currency.Format(f.FXSpread),
+	}
+}
+
+// parseTimeRange reads RFC 3339 `from` and `to` query parameters.
+// Missing bounds default to the unix epoch and the current time.
+func parseTimeRange(r *http.Request) (from, to time.Time, err error) {
+	from, to = time.Unix(0, 0), time.Now()
+
+	if s := r.URL.Query().Get("from"); s != "" {
+		from, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return
+		}
+	}
+
+	if s := r.URL.Query().Get("to"); s != "" {
+		to, err = time.Parse(time.RFC3339, s)
+	}
+
+	return
+}
+
+func feeRevenueReport(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	revenue, err := lib.FindFeeRevenue(r.Context(), db, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	fr := make([]*feeRevenueResponse, len(revenue))
+	for i := range revenue {
+		fr[i] = feeRevenueToResponse(revenue[i])
+	}
+
+	j, err := json.Marshal(map[string]interface{}{
+		"from": from,
+		"to":   to,
+		"data": fr,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("feeRevenueReport handler: %v\n", err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/zeebo/errs"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type reversalBody struct {
+	// Amount defaults to the part of the transfer that is not reversed yet.
+	Amount string `json:"amount"`
+	Reason string `json:"reason"`
+}
+
+func getFeeRefundRule(ctx context.Context) lib.FeeRefundRule {
+	return ctx.Value("walletdb:fee-refund-rule").(lib.FeeRefundRule)
+}
+
+func reverseTransfer(w http.ResponseWriter, r *http.Request) {
+	transferID, err := lib.ParseTransferID(mux.Vars(r)["transferID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var body reversalBody
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	params := lib.ReverseTransferParams{
+		Transfer:  transferID,
+		Reason:    body.Reason,
+		FeeRefund: getFeeRefundRule(r.Context()),
+		Status:    getWalletStatusRule(r.Context()),
+	}
+	if body.Amount != "" {
+		amount, err := lib.NewDecimalFromString(body.Amount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		params.Amount = &amount
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	reversal, err := lib.ReverseTransfer(r.Context(), db, &params)
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrTransferDoesNotExist.Has(err) || lib.ErrWalletDoesNotExist.Has(err):
+			status = http.StatusNotFound
+		case lib.ErrReversalExceedsTransfer.Has(err) || lib.ErrWalletFrozen.Has(err) || lib.ErrWalletClosed.Has(err):
+			status = http.StatusConflict
+		case errs.Is(err, lib.ErrInsufficientFunds),
+			errs.Is(err, lib.ErrTransferToSameWallet),
+			lib.ErrInvalidReversal.Has(err),
+			lib.ErrInvalidAmount.Has(err):
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(transferToResponse(reversal))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", transferLocation(reversal.ID))
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("reverseTransfer handler: %v\n", err.Error())
+	}
+}
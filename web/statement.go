@@ -0,0 +1,240 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+// statementCSVHeader is the layout of CSV statements, see README.md.
+var statementCSVHeader = []string{
+	"entry_id", "time", "type", "transfer_id", "counterparty", "reference", "amount", "fee", "balance",
+}
+
+// Types of the rows with the opening and closing balance.
+const (
+	statementOpening = "opening_balance"
+	statementClosing = "closing_balance"
+)
+
+type csvStatementWriter struct {
+	w        *csv.Writer
+	currency lib.Currency
+	from, to time.Time
+}
+
+func (s *csvStatementWriter) Opening(balance lib.Decimal) error {
+	err := s.w.Write(statementCSVHeader)
+	if err != nil {
+		return err
+	}
+
+	return s.balanceRow(s.from, statementOpening, balance)
+}
+
+func (s *csvStatementWriter) Entry(e *lib.StatementEntry) error {
+	var transfer, counterparty string
+	if e.Transfer != nil {
+		transfer = e.Transfer.String()
+	}
+	if e.Counterparty != nil {
+		counterparty = e.Counterparty.String()
+	}
+
+	return s.w.Write([]string{
+		strconv.FormatInt(int64(e.ID), 10),
+		e.Time.UTC().Format(time.RFC3339Nano),
+		e.Type,
+		transfer,
+		counterparty,
+		e.Reference,
+		s.currency.Format(e.Amount),
+		s.currency.Format(e.Fee),
+		s.currency.Format(e.Balance),
+	})
+}
+
+func (s *csvStatementWriter) Closing(balance lib.Decimal) error {
+	err := s.balanceRow(s.to, statementClosing, balance)
+	if err != nil {
+		return err
+	}
+
+	s.w.Flush()
+
+	return s.w.Error()
+}
+
+func (s *csvStatementWriter) balanceRow(t time.Time, typ string, balance lib.Decimal) error {
+	return s.w.Write([]string{"", t.UTC().Format(time.RFC3339Nano), typ, "", "", "", "", "", s.currency.Format(balance)})
+}
+
+type statementEntryResponse struct {
+	ID           int64     `json:"id"`
+	Time         time.Time `json:"time"`
+	Type         string    `json:"type"`
+	TransferID   string    `json:"transfer_id,omitempty"`
+	Counterparty string    `json:"counterparty,omitempty"`
+	Reference    string    `json:"reference,omitempty"`
+	Amount       string    `json:"amount"`
+	Fee          string    `json:"fee"`
+	Balance      string    `json:"balance"`
+}
+
+// jsonStatementWriter writes the statement as one object whose entries are
+// encoded one by one:
+//
+//	{"wallet": "1", "currency": "BTC", "from": "...", "to": "...",
+//	 "opening_balance": "1.0", "entries": [...], "closing_balance": "2.0"}
+type jsonStatementWriter struct {
+	w        io.Writer
+	wallet   *lib.Wallet
+	from, to time.Time
+	entries  int
+}
+
+func (s *jsonStatementWriter) Opening(balance lib.Decimal) error {
+	head, err := json.Marshal(map[string]interface{}{
+		"wallet":          s.wallet.ID.String(),
+		"currency":        s.wallet.Currency.String(),
+		"from":            s.from,
+		"to":              s.to,
+		"opening_balance": s.wallet.Currency.Format(balance),
+	})
+	if err != nil {
+		return err
+	}
+
+	// reopen the object to append the entries
+	_, err = s.w.Write(append(head[:len(head)-1], `,"entries":[`...))
+	return err
+}
+
+func (s *jsonStatementWriter) Entry(e *lib.StatementEntry) error {
+	er := statementEntryResponse{
+		ID:        int64(e.ID),
+		Time:      e.Time,
+		Type:      e.Type,
+		Reference: e.Reference,
+		Amount:    s.wallet.Currency.Format(e.Amount),
+		Fee:       s.wallet.Currency.Format(e.Fee),
+		Balance:   s.wallet.Currency.Format(e.Balance),
+	}
+	if e.Transfer != nil {
+		er.TransferID = e.Transfer.String()
+	}
+	if e.Counterparty != nil {
+		er.Counterparty = e.Counterparty.String()
+	}
+
+	j, err := json.Marshal(er)
+	if err != nil {
+		return err
+	}
+	if s.entries > 0 {
+		j = append([]byte{','}, j...)
+	}
+	s.entries++
+
+	_, err = s.w.Write(j)
+	return err
+}
+
+func (s *jsonStatementWriter) Closing(balance lib.Decimal) error {
+	b, err := json.Marshal(s.wallet.Currency.Format(balance))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.w.Write(append(append([]byte(`],"closing_balance":`), b...), '}'))
+	return err
+}
+
+// walletStatement streams the statement of the wallet for (from, to]. The
+// format parameter is csv or json, json by default. Errors that happen after
+// the first row is sent truncate the response.
+func walletStatement(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	walletID, err := lib.ParseWalletID(mux.Vars(r)["walletID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if to.Before(from) {
+		http.Error(w, "to is before from", http.StatusBadRequest)
+
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "csv" && format != "json" {
+		http.Error(w, errInvalidParam.New("format: %q", format).Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	wlt, err := lib.FindWalletByID(r.Context(), db, walletID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if wlt == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	out := &startedWriter{w: w}
+
+	var sw lib.StatementWriter
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="wallet-`+wlt.ID.String()+`-statement.csv"`)
+		sw = &csvStatementWriter{w: csv.NewWriter(out), currency: wlt.Currency, from: from, to: to}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		sw = &jsonStatementWriter{w: out, wallet: wlt, from: from, to: to}
+	}
+
+	err = lib.WriteStatement(r.Context(), db, walletID, from, to, sw)
+	if err != nil {
+		if !out.started {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		log.Printf("walletStatement handler: %v\n", err.Error())
+	}
+}
+
+// startedWriter records whether the response has been started.
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.started = true
+	return s.w.Write(p)
+}
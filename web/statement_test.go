@@ -0,0 +1,220 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+// statementJSON is the layout of JSON statements.
+type statementJSON struct {
+	Wallet         string                   `json:"wallet"`
+	Currency       string                   `json:"currency"`
+	From           time.Time                `json:"from"`
+	To             time.Time                `json:"to"`
+	OpeningBalance string                   `json:"opening_balance"`
+	Entries        []statementEntryResponse `json:"entries"`
+	ClosingBalance string                   `json:"closing_balance"`
+}
+
+func testStatementEntries(n int) []*lib.StatementEntry {
+	transfer, counterparty := lib.TransferID(7), lib.WalletID(2)
+
+	es := make([]*lib.StatementEntry, n)
+	for i := range es {
+		es[i] = &lib.StatementEntry{
+			ID:           1,
+			Time:         time.Date(2021, 1, 1, 0, i, 0, 0, time.UTC),
+			Type:         "out",
+			Transfer:     &transfer,
+			Counterparty: &counterparty,
+			Amount:       lib.NewDecimalFromInt(-2),
+			Fee:          lib.NewDecimalFromInt(1),
+			Balance:      lib.NewDecimalFromInt(int64(10 - 3*(i+1))),
+		}
+	}
+
+	return es
+}
+
+func writeTestStatement(t *testing.T, sw lib.StatementWriter, entries []*lib.StatementEntry) {
+	t.Helper()
+
+	if err := sw.Opening(lib.NewDecimalFromInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := sw.Entry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sw.Closing(lib.NewDecimalFromInt(int64(10 - 3*len(entries)))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSVStatementWriter(t *testing.T) {
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	// the zero currency has no decimal places
+	sw := &csvStatementWriter{w: csv.NewWriter(&buf), from: from, to: to}
+	writeTestStatement(t, sw, testStatementEntries(2))
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		statementCSVHeader,
+		{"", "2021-01-01T00:00:00Z", "opening_balance", "", "", "", "", "", "10"},
+		{"1", "2021-01-01T00:00:00Z", "out", "7", "2", "", "-2", "1", "7"},
+		{"1", "2021-01-01T00:01:00Z", "out", "7", "2", "", "-2", "1", "4"},
+		{"", "2021-01-02T00:00:00Z", "closing_balance", "", "", "", "", "", "4"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got\n%q\nwant\n%q", rows, want)
+	}
+}
+
+func TestJSONStatementWriter(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		var buf bytes.Buffer
+		sw := &jsonStatementWriter{w: &buf, wallet: &lib.Wallet{ID: 1}}
+		writeTestStatement(t, sw, testStatementEntries(n))
+
+		if !json.Valid(buf.Bytes()) {
+			t.Errorf("%d entries: invalid JSON %s", n, buf.Bytes())
+			continue
+		}
+
+		var s statementJSON
+		if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Wallet != "1" || s.OpeningBalance != "10" || len(s.Entries) != n {
+			t.Errorf("%d entries: got %+v", n, s)
+		}
+		if want := fmt.Sprint(10 - 3*n); s.ClosingBalance != want {
+			t.Errorf("%d entries: closing balance %s, want %s", n, s.ClosingBalance, want)
+		}
+	}
+}
+
+func getStatement(h http.Handler, wallet lib.WalletID, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/wallets/"+wallet.String()+"/statement?"+query, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestWalletStatement(t *testing.T) {
+	db := openTestDB(t)
+	h := testHandler(db)
+
+	w := createTestWallet(t, db, "BTC", 10)
+	other := createTestWallet(t, db, "BTC", 5)
+	for _, body := range []string{
+		fmt.Sprintf(`{"from": %q, "to": %q, "amount": "3"}`, w.ID, other.ID),
+		fmt.Sprintf(`{"from": %q, "to": %q, "amount": "2"}`, other.ID, w.ID),
+	} {
+		if resp := postTransfer(h, "", body); resp.Code != http.StatusOK {
+			t.Fatalf("transfer: %d %s", resp.Code, resp.Body)
+		}
+	}
+
+	resp := getStatement(h, w.ID, "format=csv")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("csv: %d %s", resp.Code, resp.Body)
+	}
+
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 6 || !reflect.DeepEqual(rows[0], statementCSVHeader) {
+		t.Fatalf("got rows %q", rows)
+	}
+
+	var types []string
+	for _, row := range rows[1:] {
+		types = append(types, row[2])
+	}
+	if want := []string{"opening_balance", "funding", "out", "in", "closing_balance"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("types %q, want %q", types, want)
+	}
+
+	// balance = previous balance + amount - fee
+	balance := lib.Decimal{}
+	for _, row := range rows[2 : len(rows)-1] {
+		amount, fee, got := parseTestDecimal(t, row[6]), parseTestDecimal(t, row[7]), parseTestDecimal(t, row[8])
+		balance = balance.Add(amount).Sub(fee)
+		if !got.Equal(balance) {
+			t.Errorf("row %q: balance %v, want %v", row, got, balance)
+		}
+	}
+	if opening := rows[1][8]; opening != "0.00000000" {
+		t.Errorf("opening balance %s, want 0.00000000", opening)
+	}
+	if closing := rows[len(rows)-1][8]; closing != "9.00000000" {
+		t.Errorf("closing balance %s, want 9.00000000", closing)
+	}
+
+	resp = getStatement(h, w.ID, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("json: %d %s", resp.Code, resp.Body)
+	}
+
+	var s statementJSON
+	if err := json.Unmarshal(resp.Body.Bytes(), &s); err != nil {
+		t.Fatalf("json: %v in %s", err, resp.Body)
+	}
+	if len(s.Entries) != 3 || s.ClosingBalance != "9.00000000" || s.Entries[2].Balance != s.ClosingBalance {
+		t.Errorf("json: got %+v", s)
+	}
+}
+
+func parseTestDecimal(t *testing.T, s string) lib.Decimal {
+	t.Helper()
+
+	d, err := lib.NewDecimalFromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestWalletStatementWalletDoesNotExist(t *testing.T) {
+	db := openTestDB(t)
+	h := testHandler(db)
+
+	for _, format := range []string{"csv", "json"} {
+		resp := getStatement(h, lib.WalletID(1<<31-1), "format="+format)
+		if resp.Code != http.StatusNotFound {
+			t.Errorf("%s: %d %s, want %d", format, resp.Code, resp.Body, http.StatusNotFound)
+		}
+	}
+}
+
+func TestWalletStatementInvalidRange(t *testing.T) {
+	// the range is checked before the database is used
+	h := testHandler(nil)
+
+	resp := getStatement(h, 1, "from=2021-01-02T00:00:00Z&to=2021-01-01T00:00:00Z")
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "before") {
+		t.Errorf("got %d %s, want %d", resp.Code, resp.Body, http.StatusBadRequest)
+	}
+}
@@ -2,61 +2,159 @@ package web
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/gorilla/mux"
+
 	"github.com/defbin/walletdb/lib"
 )
 
 type transferBody struct {
-	From   string `json:"from"`
-	To     string `json:"to"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Amount may be omitted if QuoteID is set.
 	Amount string `json:"amount"`
+	// QuoteID is the id of an FX quote to convert the amount with.
+	QuoteID string `json:"quote_id,omitempty"`
 }
 
 type transferResponse struct {
+	ID        string    `json:"id"`
 	From      string    `json:"from"`
 	To        string    `json:"to"`
 	Amount    string    `json:"amount"`
 	FeeAmount string    `json:"fee_amount"`
+	FeeWallet string    `json:"fee_wallet,omitempty"`
+	FeePolicy string    `json:"fee_policy,omitempty"`
 	Time      time.Time `json:"time"`
+	// ReversalOf and FeeRefund are only set for reversals.
+	ReversalOf string `json:"reversal_of,omitempty"`
+	FeeRefund  string `json:"fee_refund,omitempty"`
+	// The conversion fields are only set for transfers between currencies.
+	DestinationAmount string `json:"destination_amount,omitempty"`
+	ExchangeRate      string `json:"exchange_rate,omitempty"`
+	ExchangeRateID    string `json:"exchange_rate_id,omitempty"`
+	FXSpread          string `json:"fx_spread,omitempty"`
+	SpreadWallet      string `json:"spread_wallet,omitempty"`
+	// Reversals are only listed by transferByID.
+	Reversals []*transferResponse `json:"reversals,omitempty"`
 }
 
 func transferToResponse(t *lib.Transfer) *transferResponse {
-	return &transferResponse{
+	tr := transferResponse{
+		ID:        t.ID.String(),
 		From:      t.From.String(),
 		To:        t.To.String(),
 		Amount:    t.Amount.String(),
 		FeeAmount: t.FeeAmount.String(),
+		FeePolicy: t.FeePolicy,
 		Time:      t.CreatedAt,
 	}
+	if t.FeeWallet != nil {
+		tr.FeeWallet = t.FeeWallet.String()
+	}
+	if t.ReversalOf != nil {
+		tr.ReversalOf = t.ReversalOf.String()
+		tr.FeeRefund = t.FeeRefund.String()
+	}
+	if t.ExchangeRate != nil {
+		tr.DestinationAmount = t.DestinationAmount.String()
+		tr.ExchangeRate = t.ExchangeRate.String()
+		tr.ExchangeRateID = t.ExchangeRateID
+		tr.FXSpread = t.FXSpread.String()
+		if t.SpreadWallet != nil {
+			tr.SpreadWallet = t.SpreadWallet.String()
+		}
+	}
+
+	return &tr
+}
+
+func getFeePolicy(ctx context.Context) lib.FeePolicy {
+	return ctx.Value("walletdb:fee-policy").(lib.FeePolicy)
 }
 
-func getServiceFee() lib.Decimal {
-	// todo: make configurable
-	const serviceFee = 1.5
-	return lib.NewDecimal(serviceFee)
+type transferPageResponse struct {
+	Data       []*transferResponse `json:"data"`
+	NextCursor *string             `json:"next_cursor"`
+}
+
+func parseTransferFilter(q url.Values) (*lib.TransferFilter, error) {
+	var f lib.TransferFilter
+	var err error
+
+	if f.Sender, err = parseWalletIDParam(q, "sender"); err != nil {
+		return nil, err
+	}
+	if f.Receiver, err = parseWalletIDParam(q, "receiver"); err != nil {
+		return nil, err
+	}
+	if f.Wallet, err = parseWalletIDParam(q, "wallet"); err != nil {
+		return nil, err
+	}
+	if f.MinAmount, err = parseDecimalParam(q, "min_amount"); err != nil {
+		return nil, err
+	}
+	if f.MaxAmount, err = parseDecimalParam(q, "max_amount"); err != nil {
+		return nil, err
+	}
+	if f.Currency, err = parseCurrencyParam(q, "currency"); err != nil {
+		return nil, err
+	}
+	if f.From, err = parseTimeParam(q, "from"); err != nil {
+		return nil, err
+	}
+	if f.To, err = parseTimeParam(q, "to"); err != nil {
+		return nil, err
+	}
+	if f.Limit, err = parseIntParam(q, "limit"); err != nil {
+		return nil, err
+	}
+	f.Cursor = q.Get("cursor")
+
+	return &f, nil
 }
 
 func allTransfers(w http.ResponseWriter, r *http.Request) {
 	db := r.Context().Value("walletdb:db").(*sql.DB)
 
-	transfers, err := lib.FindAllTransfers(r.Context(), db)
+	filter, err := parseTransferFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
 		return
 	}
 
-	wr := make([]*transferResponse, len(transfers))
-	for i := range transfers {
-		wr[i] = transferToResponse(transfers[i])
+	page, err := lib.FindTransfers(r.Context(), db, filter)
+	if err != nil {
+		var status int
+		if lib.ErrInvalidCursor.Has(err) || lib.ErrInvalidTransferFilter.Has(err) {
+			status = http.StatusBadRequest
+		} else {
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	resp := transferPageResponse{Data: make([]*transferResponse, len(page.Transfers))}
+	for i := range page.Transfers {
+		resp.Data[i] = transferToResponse(page.Transfers[i])
+	}
+	if page.NextCursor != "" {
+		resp.NextCursor = &page.NextCursor
 	}
 
-	j, err := json.Marshal(map[string][]*transferResponse{"data": wr})
+	j, err := json.Marshal(resp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 
@@ -65,8 +163,29 @@ func allTransfers(w http.ResponseWriter, r *http.Request) {
 
 	_, err = w.Write(j)
 	if err != nil {
-		log.Printf("allWallets handler: %v\n", err.Error())
+		log.Printf("allTransfers handler: %v\n", err.Error())
+	}
+}
+
+// maxIdempotencyKeyLen limits the Idempotency-Key header.
+const maxIdempotencyKeyLen = 255
+
+func getIdempotencyRetention(ctx context.Context) time.Duration {
+	return ctx.Value("walletdb:idempotency-retention").(time.Duration)
+}
+
+// transferRequestHash identifies the request an idempotency key was used with.
+func transferRequestHash(body *transferBody) (string, error) {
+	j, err := json.Marshal(body)
+	if err != nil {
+		return "", err
 	}
+
+	h := sha256.New()
+	h.Write([]byte("POST /transfer\n"))
+	h.Write(j)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func transferFunds(w http.ResponseWriter, r *http.Request) {
@@ -75,6 +194,8 @@ func transferFunds(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
 	}
 
 	db := r.Context().Value("walletdb:db").(*sql.DB)
@@ -86,19 +207,69 @@ func transferFunds(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	amount, err := lib.NewDecimalFromString(body.Amount)
+	var amount lib.Decimal
+	if body.Amount != "" || body.QuoteID == "" {
+		amount, err = lib.NewDecimalFromString(body.Amount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	var quoteID *lib.FXQuoteID
+	if body.QuoteID != "" {
+		id, err := lib.ParseFXQuoteID(body.QuoteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		quoteID = &id
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLen {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+
+		return
+	}
+
+	hash, err := transferRequestHash(&body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 
 		return
 	}
 
-	transfer, err := doTransfer(r.Context(), db, from, to, amount)
+	params := lib.TransferFundsParams{
+		From:     from,
+		To:       to,
+		Amount:   amount,
+		Fee:      getFeePolicy(r.Context()),
+		Rates:    getExchangeRates(r.Context()),
+		FXSpread: getFXSpread(r.Context()),
+		Quote:    quoteID,
+		Status:   getWalletStatusRule(r.Context()),
+	}
+
+	resp, err := doTransfer(r.Context(), db, key, hash, &params)
 	if err != nil {
 		var status int
-		if lib.ErrTransferFunds.Has(err) {
+		switch {
+		case lib.ErrIdempotencyKeyReused.Has(err):
+			status = http.StatusUnprocessableEntity
+		case lib.ErrFXQuoteUsed.Has(err) || lib.ErrFXQuoteExpired.Has(err):
+			status = http.StatusConflict
+		case lib.ErrWalletFrozen.Has(err) || lib.ErrWalletClosed.Has(err):
+			status = http.StatusConflict
+		case lib.ErrFeeWalletNotConfigured.Has(err):
+			// the server is misconfigured, not the request
+			status = http.StatusInternalServerError
+		case lib.ErrTransferFunds.Has(err) || lib.ErrInvalidAmount.Has(err):
 			status = http.StatusBadRequest
-		} else {
+		default:
 			status = http.StatusInternalServerError
 		}
 
@@ -107,7 +278,56 @@ func transferFunds(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	j, err := json.Marshal(transferToResponse(transfer))
+	if resp.TransferID != nil {
+		w.Header().Set("Location", transferLocation(*resp.TransferID))
+	}
+
+	w.WriteHeader(resp.Status)
+	_, err = w.Write(resp.Body)
+	if err != nil {
+		log.Printf("transferFunds handler: %v\n", err.Error())
+	}
+}
+
+func transferLocation(id lib.TransferID) string {
+	return "/transfer/" + id.String()
+}
+
+func transferByID(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	transferID, err := lib.ParseTransferID(mux.Vars(r)["transferID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	transfer, err := lib.FindTransferByID(r.Context(), db, transferID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if transfer == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	reversals, err := lib.FindReversalsOf(r.Context(), db, transfer.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	tr := transferToResponse(transfer)
+	for _, rt := range reversals {
+		tr.Reversals = append(tr.Reversals, transferToResponse(rt))
+	}
+
+	j, err := json.Marshal(tr)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 
@@ -116,24 +336,20 @@ func transferFunds(w http.ResponseWriter, r *http.Request) {
 
 	_, err = w.Write(j)
 	if err != nil {
-		log.Printf("transferFunds handler: %v\n", err.Error())
+		log.Printf("transferByID handler: %v\n", err.Error())
 	}
 }
 
-func doTransfer(ctx context.Context, db *sql.DB, from, to lib.WalletID, amount lib.Decimal) (*lib.Transfer, error) {
+// doTransfer transfers the funds and returns the response to send. If key is
+// not empty, the response is saved along with the transfer, and a retry with
+// the same key gets the saved response instead of a new transfer.
+func doTransfer(ctx context.Context, db *sql.DB, key, hash string, params *lib.TransferFundsParams) (*lib.IdempotentResponse, error) {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	params := lib.TransferFundsParams{
-		From:   from,
-		To:     to,
-		Amount: amount,
-		Fee:    getServiceFee(),
-	}
-
-	res, err := lib.TransferFunds(ctx, db, &params)
+	resp, err := transferWithinTx(ctx, tx, key, hash, params)
 	if err != nil {
 		if err := tx.Rollback(); err != nil {
 			log.Printf("transferFunds handler: %v", err)
@@ -148,7 +364,41 @@ func doTransfer(ctx context.Context, db *sql.DB, from, to lib.WalletID, amount l
 		return nil, err
 	}
 
-	return res.Transfer(), nil
+	return resp, nil
+}
+
+func transferWithinTx(ctx context.Context, tx *sql.Tx, key, hash string, params *lib.TransferFundsParams) (*lib.IdempotentResponse, error) {
+	if key != "" {
+		saved, err := lib.ClaimIdempotencyKey(ctx, tx, key, hash, getIdempotencyRetention(ctx))
+		if err != nil || saved != nil {
+			return saved, err
+		}
+	}
+
+	res, err := lib.TransferFunds(ctx, tx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := json.Marshal(transferToResponse(res.Transfer()))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := lib.IdempotentResponse{
+		Status:     http.StatusOK,
+		Body:       j,
+		TransferID: &res.Transfer().ID,
+	}
+
+	if key != "" {
+		err = lib.SaveIdempotentResponse(ctx, tx, key, &resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &resp, nil
 }
 
 func parseWalletIDs(fromS, toS string) (from, to lib.WalletID, err error) {
@@ -161,3 +411,133 @@ func parseWalletIDs(fromS, toS string) (from, to lib.WalletID, err error) {
 
 	return
 }
+
+type quoteBody struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+	// Mode is "receive" (default) if amount is what the receiver must get
+	// or "debit" if amount is the total to take from the sender.
+	Mode string `json:"mode"`
+}
+
+type quoteResponse struct {
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Currency     string `json:"currency"`
+	Amount       string `json:"amount"`
+	FeeAmount    string `json:"fee_amount"`
+	FeePolicy    string `json:"fee_policy"`
+	TotalDebit   string `json:"total_debit"`
+	BalanceAfter string `json:"balance_after"`
+	// AvailableAfter is BalanceAfter less the funds on hold.
+	AvailableAfter string `json:"available_balance_after"`
+	// The conversion fields are only set for transfers between currencies.
+	DestinationCurrency string `json:"destination_currency,omitempty"`
+	DestinationAmount   string `json:"destination_amount,omitempty"`
+	ExchangeRate        string `json:"exchange_rate,omitempty"`
+	ExchangeRateID      string `json:"exchange_rate_id,omitempty"`
+	FXSpread            string `json:"fx_spread,omitempty"`
+	OK                  bool   `json:"ok"`
+	Reason              string `json:"reason,omitempty"`
+}
+
+func quoteToResponse(q *lib.TransferQuote) *quoteResponse {
+	c := q.From.Currency
+	qr := quoteResponse{
+		From:           q.From.ID.String(),
+		To:             q.To.ID.String(),
+		Currency:       c.String(),
+		Amount:         q.Amount.String(),
+		FeeAmount:      c.Format(q.FeeAmount),
+		FeePolicy:      q.FeePolicy,
+		TotalDebit:     c.Format(q.TotalDebit),
+		BalanceAfter:   c.Format(q.BalanceAfter),
+		AvailableAfter: c.Format(q.AvailableAfter),
+		OK:             q.Err == nil,
+	}
+	if q.ExchangeRate != nil {
+		dc := q.To.Currency
+		qr.DestinationCurrency = dc.String()
+		qr.DestinationAmount = dc.Format(q.DestinationAmount)
+		qr.ExchangeRate = q.ExchangeRate.Rate.String()
+		qr.ExchangeRateID = q.ExchangeRate.ID
+		qr.FXSpread = dc.Format(q.FXSpread)
+	}
+	if q.Err != nil {
+		qr.Reason = q.Err.Error()
+	}
+
+	return &qr
+}
+
+func quoteTransfer(w http.ResponseWriter, r *http.Request) {
+	var body quoteBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	from, to, err := parseWalletIDs(body.From, body.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	amount, err := lib.NewDecimalFromString(body.Amount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	mode, err := lib.ParseQuoteMode(body.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	params := lib.QuoteTransferParams{
+		From:     from,
+		To:       to,
+		Amount:   amount,
+		Mode:     mode,
+		Fee:      getFeePolicy(r.Context()),
+		Rates:    getExchangeRates(r.Context()),
+		FXSpread: getFXSpread(r.Context()),
+		Status:   getWalletStatusRule(r.Context()),
+	}
+
+	quote, err := lib.QuoteTransfer(r.Context(), db, &params)
+	if err != nil {
+		var status int
+		if lib.ErrWalletDoesNotExist.Has(err) || lib.ErrInvalidAmount.Has(err) {
+			status = http.StatusBadRequest
+		} else {
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(quoteToResponse(quote))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("quoteTransfer handler: %v\n", err.Error())
+	}
+}
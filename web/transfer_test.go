@@ -0,0 +1,94 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+func postTransfer(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/transfer", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestTransferIdempotencyKey(t *testing.T) {
+	db := openTestDB(t)
+	h := testHandler(db)
+
+	from := createTestWallet(t, db, "BTC", 10)
+	to := createTestWallet(t, db, "BTC", 0)
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	body := fmt.Sprintf(`{"from": %q, "to": %q, "amount": "1"}`, from.ID, to.ID)
+
+	first := postTransfer(h, key, body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request: %d %s, want %d", first.Code, first.Body, http.StatusOK)
+	}
+	if loc := first.Header().Get("Location"); !strings.HasPrefix(loc, "/transfer/") {
+		t.Errorf("first request: Location %q", loc)
+	}
+
+	replayed := postTransfer(h, key, body)
+	if replayed.Code != first.Code || replayed.Body.String() != first.Body.String() {
+		t.Errorf("replay: %d %s, want %d %s", replayed.Code, replayed.Body, first.Code, first.Body)
+	}
+	if got, want := replayed.Header().Get("Location"), first.Header().Get("Location"); got != want {
+		t.Errorf("replay: Location %q, want %q", got, want)
+	}
+
+	other := postTransfer(h, key, fmt.Sprintf(`{"from": %q, "to": %q, "amount": "2"}`, from.ID, to.ID))
+	if other.Code != http.StatusUnprocessableEntity {
+		t.Errorf("other body: %d %s, want %d", other.Code, other.Body, http.StatusUnprocessableEntity)
+	}
+
+	if got := findTestWallet(t, db, from.ID).Balance; !got.Equal(lib.NewDecimalFromInt(9)) {
+		t.Errorf("sender balance is %v, want a single transfer", got)
+	}
+}
+
+// TestTransferIdempotencyKeyFailure checks that the key of a failed transfer
+// is released with the rolled back transaction, so the request can be retried.
+func TestTransferIdempotencyKeyFailure(t *testing.T) {
+	db := openTestDB(t)
+	h := testHandler(db)
+
+	from := createTestWallet(t, db, "BTC", 10)
+	to := createTestWallet(t, db, "BTC", 0)
+
+	key := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	body := fmt.Sprintf(`{"from": %q, "to": %q, "amount": "15"}`, from.ID, to.ID)
+
+	if w := postTransfer(h, key, body); w.Code != http.StatusBadRequest {
+		t.Fatalf("insufficient funds: %d %s, want %d", w.Code, w.Body, http.StatusBadRequest)
+	}
+
+	_, _, err := lib.FundWallet(context.Background(), db, &lib.FundWalletParams{
+		Wallet: from.ID,
+		Amount: lib.NewDecimalFromInt(5),
+		Reason: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := postTransfer(h, key, body); w.Code != http.StatusOK {
+		t.Fatalf("retry: %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	if got := findTestWallet(t, db, from.ID).Balance; !got.IsZero() {
+		t.Errorf("sender balance is %v, want 0", got)
+	}
+}
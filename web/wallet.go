@@ -5,24 +5,53 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/defbin/walletdb/lib"
 )
 
+type walletBody struct {
+	Currency string            `json:"currency"`
+	Metadata map[string]string `json:"metadata"`
+	OwnerID  string            `json:"owner_id"`
+}
+
+// walletResponse shows the total balance and what can be spent: the part
+// of it not reserved by holds plus the overdraft limit.
 type walletResponse struct {
-	ID       string `json:"id"`
-	Balance  string `json:"balance"`
-	Currency string `json:"currency"`
+	ID               string            `json:"id"`
+	Balance          string            `json:"balance"`
+	AvailableBalance string            `json:"available_balance"`
+	OverdraftLimit   string            `json:"overdraft_limit,omitempty"`
+	Currency         string            `json:"currency"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	OwnerID          string            `json:"owner_id,omitempty"`
+	Status           string            `json:"status"`
 }
 
 func walletToResponse(w *lib.Wallet) *walletResponse {
-	return &walletResponse{
-		ID:       w.ID.String(),
-		Balance:  w.Balance.String(),
-		Currency: w.Currency.String(),
+	wr := walletResponse{
+		ID:               w.ID.String(),
+		Balance:          w.Currency.Format(w.Balance),
+		AvailableBalance: w.Currency.Format(w.Available()),
+		Currency:         w.Currency.String(),
+		Metadata:         w.Metadata,
+		Status:           w.Status,
+	}
+	if w.OverdraftLimit.Sign() > 0 {
+		wr.OverdraftLimit = w.Currency.Format(w.OverdraftLimit)
 	}
+	if w.Owner != nil {
+		wr.OwnerID = w.Owner.String()
+	}
+
+	return &wr
+}
+
+func walletLocation(id lib.WalletID) string {
+	return "/wallets/" + id.String()
 }
 
 func allWallets(w http.ResponseWriter, r *http.Request) {
@@ -51,6 +80,74 @@ func allWallets(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func createWallet(w http.ResponseWriter, r *http.Request) {
+	var body walletBody
+
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	currency, err := lib.NewCurrency(body.Currency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	params := lib.CreateWalletParams{
+		Currency: currency,
+		Metadata: body.Metadata,
+	}
+	if body.OwnerID != "" {
+		owner, err := lib.ParseOwnerID(body.OwnerID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		params.Owner = &owner
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	wlt, err := lib.CreateWallet(r.Context(), db, &params)
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrCurrencyDisabled.Has(err),
+			lib.ErrInvalidMetadata.Has(err),
+			lib.ErrOwnerDoesNotExist.Has(err):
+			status = http.StatusBadRequest
+		case lib.ErrOwnerWalletCurrency.Has(err):
+			status = http.StatusConflict
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(walletToResponse(wlt))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Location", walletLocation(wlt.ID))
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("createWallet handler: %v\n", err.Error())
+	}
+}
+
 func walletByID(w http.ResponseWriter, r *http.Request) {
 	db := r.Context().Value("walletdb:db").(*sql.DB)
 
@@ -82,3 +179,176 @@ func walletByID(w http.ResponseWriter, r *http.Request) {
 		log.Printf("walletByID handler: %v\n", err.Error())
 	}
 }
+
+type walletTransferResponse struct {
+	TransferID   string    `json:"transfer_id"`
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Amount       string    `json:"amount"`
+	Fee          string    `json:"fee"`
+	BalanceAfter string    `json:"balance_after"`
+	Time         time.Time `json:"time"`
+}
+
+type walletTransferPageResponse struct {
+	Data       []*walletTransferResponse `json:"data"`
+	NextCursor *string                   `json:"next_cursor"`
+}
+
+func walletTransferToResponse(c lib.Currency, t *lib.WalletTransfer) *walletTransferResponse {
+	return &walletTransferResponse{
+		TransferID:   t.ID.String(),
+		Direction:    t.Direction,
+		Counterparty: t.Counterparty().String(),
+		Amount:       c.Format(t.SignedAmount),
+		Fee:          c.Format(t.FeePaid),
+		BalanceAfter: c.Format(t.BalanceAfter),
+		Time:         t.CreatedAt,
+	}
+}
+
+func walletTransfers(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	walletID, err := lib.ParseWalletID(mux.Vars(r)["walletID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	filter := lib.WalletTransferFilter{Wallet: walletID, Cursor: r.URL.Query().Get("cursor")}
+	if filter.From, err = parseTimeParam(r.URL.Query(), "from"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if filter.To, err = parseTimeParam(r.URL.Query(), "to"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if filter.Limit, err = parseIntParam(r.URL.Query(), "limit"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	wlt, err := lib.FindWalletByID(r.Context(), db, walletID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if wlt == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	page, err := lib.FindWalletTransfers(r.Context(), db, &filter)
+	if err != nil {
+		var status int
+		if lib.ErrInvalidCursor.Has(err) || lib.ErrInvalidTransferFilter.Has(err) {
+			status = http.StatusBadRequest
+		} else {
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	resp := walletTransferPageResponse{Data: make([]*walletTransferResponse, len(page.Transfers))}
+	for i := range page.Transfers {
+		resp.Data[i] = walletTransferToResponse(wlt.Currency, page.Transfers[i])
+	}
+	if page.NextCursor != "" {
+		resp.NextCursor = &page.NextCursor
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("walletTransfers handler: %v\n", err.Error())
+	}
+}
+
+type balanceResponse struct {
+	Wallet   string    `json:"wallet"`
+	Currency string    `json:"currency"`
+	Balance  string    `json:"balance"`
+	At       time.Time `json:"at"`
+}
+
+// walletBalance returns the balance of the wallet at the time given by the
+// at parameter, now by default.
+func walletBalance(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	walletID, err := lib.ParseWalletID(mux.Vars(r)["walletID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	at, err := parseTimeParam(r.URL.Query(), "at")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+	if at == nil {
+		now := time.Now()
+		at = &now
+	}
+
+	wlt, err := lib.FindWalletByID(r.Context(), db, walletID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if wlt == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	balance, err := lib.BalanceAt(r.Context(), db, walletID, *at)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if balance == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	j, err := json.Marshal(&balanceResponse{
+		Wallet:   wlt.ID.String(),
+		Currency: wlt.Currency.String(),
+		Balance:  wlt.Currency.Format(*balance),
+		At:       *at,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("walletBalance handler: %v\n", err.Error())
+	}
+}
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/defbin/walletdb/lib"
+)
+
+type walletStatusBody struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type walletStatusChangeResponse struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+func getWalletStatusRule(ctx context.Context) lib.WalletStatusRule {
+	return ctx.Value("walletdb:wallet-status-rule").(lib.WalletStatusRule)
+}
+
+func changeWalletStatus(w http.ResponseWriter, r *http.Request) {
+	walletID, err := lib.ParseWalletID(mux.Vars(r)["walletID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var body walletStatusBody
+
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	wlt, err := lib.ChangeWalletStatus(r.Context(), db, &lib.ChangeWalletStatusParams{
+		Wallet: walletID,
+		Status: body.Status,
+		Reason: body.Reason,
+	})
+	if err != nil {
+		var status int
+		switch {
+		case lib.ErrWalletDoesNotExist.Has(err):
+			status = http.StatusNotFound
+		case lib.ErrWalletStatusTransition.Has(err):
+			status = http.StatusConflict
+		case lib.ErrInvalidWalletStatus.Has(err):
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusInternalServerError
+		}
+
+		http.Error(w, err.Error(), status)
+
+		return
+	}
+
+	j, err := json.Marshal(walletToResponse(wlt))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("changeWalletStatus handler: %v\n", err.Error())
+	}
+}
+
+func walletStatusChanges(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("walletdb:db").(*sql.DB)
+
+	walletID, err := lib.ParseWalletID(mux.Vars(r)["walletID"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	wlt, err := lib.FindWalletByID(r.Context(), db, walletID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if wlt == nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	changes, err := lib.FindWalletStatusChanges(r.Context(), db, walletID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	cr := make([]*walletStatusChangeResponse, len(changes))
+	for i, c := range changes {
+		cr[i] = &walletStatusChangeResponse{
+			From:   c.From,
+			To:     c.To,
+			Reason: c.Reason,
+			Time:   c.CreatedAt,
+		}
+	}
+
+	j, err := json.Marshal(map[string][]*walletStatusChangeResponse{"data": cr})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	_, err = w.Write(j)
+	if err != nil {
+		log.Printf("walletStatusChanges handler: %v\n", err.Error())
+	}
+}